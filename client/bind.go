@@ -0,0 +1,281 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BindTag is the struct tag naming the record property a field is bound to, for example
+//
+//	type Subject struct {
+//		client.RecordMetadata
+//		Name    string    `pennsieve:"name,required"`
+//		Age     *int      `pennsieve:"age"`
+//		Born    time.Time `pennsieve:"birthday"`
+//		Aliases []string  `pennsieve:"synonyms"`
+//	}
+//
+// The "required" option makes Bind fail if the property is null or missing. Fields without the tag, or tagged "-",
+// are left alone.
+const BindTag = "pennsieve"
+
+// ErrRequiredProperty is returned, wrapped, by Bind when a required property is null or missing
+var ErrRequiredProperty = errors.New("required property is null or missing")
+
+// RecordMetadata can be embedded in a struct passed to Bind to receive the record's id and audit fields
+type RecordMetadata struct {
+	ID        string
+	Type      string
+	CreatedAt time.Time
+	CreatedBy string
+	UpdatedAt time.Time
+	UpdatedBy string
+}
+
+var recordMetadataType = reflect.TypeOf(RecordMetadata{})
+var timeType = reflect.TypeOf(time.Time{})
+
+// boundField is a struct field tagged with BindTag
+type boundField struct {
+	index    []int
+	name     string
+	property string
+	required bool
+}
+
+// bindPlan is what Bind needs to know about a struct type. Plans are cached by type.
+type bindPlan struct {
+	metadataIndex []int
+	fields        []boundField
+}
+
+var bindPlans sync.Map
+
+// Bind sets the fields of the struct pointed to by target from the record, as described by BindTag. A property value
+// is converted according to its data type: String to string, Long to any integer or float kind, Double to any float
+// kind, Boolean to bool, Date to time.Time, and arrays to slices of those. An empty interface field receives the
+// converted value as is. A pointer or slice field is set to nil for a null value, and any other field is left
+// unchanged. A null array item is the zero value of the slice's element type, which fails a required field unless the
+// element type can be nil. Every field that cannot be set is reported in the returned error.
+func Bind(record instance.Record, target any) error {
+	targetValue := reflect.ValueOf(target)
+	if targetValue.Kind() != reflect.Pointer || targetValue.IsNil() || targetValue.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind target must be a non-nil pointer to a struct; got %T", target)
+	}
+	structValue := targetValue.Elem()
+	plan, err := bindPlanFor(structValue.Type())
+	if err != nil {
+		return err
+	}
+	if plan.metadataIndex != nil {
+		structValue.FieldByIndex(plan.metadataIndex).Set(reflect.ValueOf(RecordMetadata{
+			ID:        record.ID,
+			Type:      record.Type,
+			CreatedAt: record.CreatedAt,
+			CreatedBy: record.CreatedBy,
+			UpdatedAt: record.UpdatedAt,
+			UpdatedBy: record.UpdatedBy,
+		}))
+	}
+	var errs []error
+	for _, field := range plan.fields {
+		if err := bindField(record, structValue.FieldByIndex(field.index), field); err != nil {
+			errs = append(errs, fmt.Errorf("error binding field %s of record %s: %w", field.name, record.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetRecordsAs returns the records of the given model, each bound to a new T with Bind
+func GetRecordsAs[T any](r *Reader, modelName string) ([]T, error) {
+	records, err := r.GetRecordsForModel(modelName)
+	if err != nil {
+		return nil, err
+	}
+	bound := make([]T, len(records))
+	for i, record := range records {
+		if err := Bind(record, &bound[i]); err != nil {
+			return nil, fmt.Errorf("error binding %s records: %w", modelName, err)
+		}
+	}
+	return bound, nil
+}
+
+func bindPlanFor(structType reflect.Type) (*bindPlan, error) {
+	if cached, found := bindPlans.Load(structType); found {
+		return cached.(*bindPlan), nil
+	}
+	plan := &bindPlan{}
+	for _, field := range reflect.VisibleFields(structType) {
+		if field.Anonymous && field.Type == recordMetadataType {
+			plan.metadataIndex = field.Index
+			continue
+		}
+		tag, tagged := field.Tag.Lookup(BindTag)
+		if !tagged || tag == "-" {
+			continue
+		}
+		if !field.IsExported() {
+			return nil, fmt.Errorf("field %s of %s has a %s tag but is not exported", field.Name, structType, BindTag)
+		}
+		propertyName, options, _ := strings.Cut(tag, ",")
+		if len(propertyName) == 0 {
+			return nil, fmt.Errorf("field %s of %s has an empty %s tag", field.Name, structType, BindTag)
+		}
+		boundField := boundField{index: field.Index, name: field.Name, property: propertyName}
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "":
+			case "required":
+				boundField.required = true
+			default:
+				return nil, fmt.Errorf("field %s of %s has unknown %s tag option %q", field.Name, structType, BindTag, option)
+			}
+		}
+		plan.fields = append(plan.fields, boundField)
+	}
+	cached, _ := bindPlans.LoadOrStore(structType, plan)
+	return cached.(*bindPlan), nil
+}
+
+func bindField(record instance.Record, fieldValue reflect.Value, field boundField) error {
+	property, hasProperty := record.Property(field.property)
+	if !hasProperty || property.IsNull() {
+		if field.required {
+			return fmt.Errorf("property %s: %w", field.property, ErrRequiredProperty)
+		}
+		if fieldValue.Kind() == reflect.Pointer || fieldValue.Kind() == reflect.Slice {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		}
+		return nil
+	}
+	dataType, err := property.DecodeDataType()
+	if err != nil {
+		return fmt.Errorf("property %s: %w", field.property, err)
+	}
+	if dataType.IsArray() {
+		return setArray(fieldValue, dataType.BaseType(), property.Value, field.required)
+	}
+	return setScalar(fieldValue, dataType.BaseType(), property.Value)
+}
+
+// setArray sets the items of the array value in the slice fieldValue. A null item is left as the zero value of the
+// slice's element type, unless the field is required and the element type cannot be nil.
+func setArray(fieldValue reflect.Value, itemType datatypes.SimpleType, value any, required bool) error {
+	if fieldValue.Kind() == reflect.Pointer {
+		fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+		fieldValue = fieldValue.Elem()
+	}
+	if fieldValue.Kind() != reflect.Slice {
+		return fmt.Errorf("cannot set array of %s value in %s field", itemType, fieldValue.Type())
+	}
+	items, isSlice := value.([]any)
+	if !isSlice {
+		return fmt.Errorf("array of %s value is a %T", itemType, value)
+	}
+	slice := reflect.MakeSlice(fieldValue.Type(), len(items), len(items))
+	for i, item := range items {
+		if item == nil {
+			if required && !isNillable(slice.Index(i).Kind()) {
+				return fmt.Errorf("item %d: %w", i, ErrRequiredProperty)
+			}
+			continue
+		}
+		if err := setScalar(slice.Index(i), itemType, item); err != nil {
+			return fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	fieldValue.Set(slice)
+	return nil
+}
+
+func isNillable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+		return true
+	default:
+		return false
+	}
+}
+
+// setScalar converts value with datatypes.Normalize and sets it in fieldValue, allocating if fieldValue is a pointer
+func setScalar(fieldValue reflect.Value, simpleType datatypes.SimpleType, value any) error {
+	normalized, err := datatypes.Normalize(simpleType, value)
+	if err != nil {
+		return err
+	}
+	target := fieldValue
+	if target.Kind() == reflect.Pointer {
+		target = reflect.New(fieldValue.Type().Elem()).Elem()
+	}
+	if err := assignNormalized(target, simpleType, normalized); err != nil {
+		return err
+	}
+	if fieldValue.Kind() == reflect.Pointer {
+		fieldValue.Set(target.Addr())
+	}
+	return nil
+}
+
+func assignNormalized(target reflect.Value, simpleType datatypes.SimpleType, normalized any) error {
+	if target.Kind() == reflect.Interface && target.NumMethod() == 0 {
+		target.Set(reflect.ValueOf(normalized))
+		return nil
+	}
+	switch v := normalized.(type) {
+	case string:
+		if target.Kind() != reflect.String {
+			return assignMismatchError(target, simpleType, normalized)
+		}
+		target.SetString(v)
+	case bool:
+		if target.Kind() != reflect.Bool {
+			return assignMismatchError(target, simpleType, normalized)
+		}
+		target.SetBool(v)
+	case time.Time:
+		if target.Type() != timeType {
+			return assignMismatchError(target, simpleType, normalized)
+		}
+		target.Set(reflect.ValueOf(v))
+	case int64:
+		switch {
+		case target.CanInt():
+			if target.OverflowInt(v) {
+				return fmt.Errorf("%s value %d overflows %s field", simpleType, v, target.Type())
+			}
+			target.SetInt(v)
+		case target.CanUint():
+			if v < 0 || target.OverflowUint(uint64(v)) {
+				return fmt.Errorf("%s value %d overflows %s field", simpleType, v, target.Type())
+			}
+			target.SetUint(uint64(v))
+		case target.CanFloat():
+			target.SetFloat(float64(v))
+		default:
+			return assignMismatchError(target, simpleType, normalized)
+		}
+	case float64:
+		if !target.CanFloat() {
+			return assignMismatchError(target, simpleType, normalized)
+		}
+		if target.OverflowFloat(v) {
+			return fmt.Errorf("%s value %v overflows %s field", simpleType, v, target.Type())
+		}
+		target.SetFloat(v)
+	default:
+		return assignMismatchError(target, simpleType, normalized)
+	}
+	return nil
+}
+
+// assignMismatchError is only built on failure, since assignNormalized runs for every bound field of every record
+func assignMismatchError(target reflect.Value, simpleType datatypes.SimpleType, normalized any) error {
+	return fmt.Errorf("cannot set %s value %v in %s field", simpleType, normalized, target.Type())
+}
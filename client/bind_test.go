@@ -0,0 +1,157 @@
+package client
+
+import (
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testObject struct {
+	RecordMetadata
+	ID       int64     `pennsieve:"id,required"`
+	Name     string    `pennsieve:"name"`
+	Weights  []int     `pennsieve:"weights"`
+	Synonyms []string  `pennsieve:"synonyms"`
+	GPA      *float64  `pennsieve:"gpa"`
+	Birthday time.Time `pennsieve:"birthday"`
+	IsSolid  *bool     `pennsieve:"is_solid"`
+	Ignored  string
+	Skipped  string `pennsieve:"-"`
+}
+
+func TestGetRecordsAs(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	objects, err := GetRecordsAs[testObject](reader, "object")
+	require.NoError(t, err)
+	byID := map[string]testObject{}
+	for _, object := range objects {
+		byID[object.RecordMetadata.ID] = object
+	}
+	require.Len(t, byID, 3)
+
+	whatsit := byID["a9b9d03b-19b3-4a43-b40e-5673ec955e49"]
+	assert.Equal(t, "object", whatsit.Type)
+	assert.Equal(t, "N:user:a6f827dc-46e0-487c-9e19-ffe7dda54b42", whatsit.CreatedBy)
+	assert.False(t, whatsit.CreatedAt.IsZero())
+	assert.Equal(t, int64(57), whatsit.ID)
+	assert.Equal(t, "whatsit", whatsit.Name)
+	assert.Equal(t, []int{3, 5, 7}, whatsit.Weights)
+	assert.Equal(t, []string{"thingamabob", "whosit", "doo-dad"}, whatsit.Synonyms)
+	if assert.NotNil(t, whatsit.GPA) {
+		assert.Equal(t, 6.78, *whatsit.GPA)
+	}
+	assert.Equal(t, time.Date(2024, 9, 26, 22, 1, 4, 0, time.UTC), whatsit.Birthday)
+	if assert.NotNil(t, whatsit.IsSolid) {
+		assert.True(t, *whatsit.IsSolid)
+	}
+
+	stone := byID["5b07e038-9829-46c9-b698-bf4efef81341"]
+	assert.Equal(t, int64(1), stone.ID)
+	assert.Equal(t, "stone", stone.Name)
+	assert.Nil(t, stone.Weights)
+	assert.Nil(t, stone.GPA)
+	assert.True(t, stone.Birthday.IsZero())
+	assert.Nil(t, stone.IsSolid)
+}
+
+func TestBind(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+	records, err := reader.Query("object").Where("name", Eq, "whatsit").Records()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	record := records[0]
+
+	// conversions to other numeric kinds and to an empty interface
+	var converted struct {
+		ID      uint8      `pennsieve:"id"`
+		IDFloat float32    `pennsieve:"id"`
+		GPA     any        `pennsieve:"gpa"`
+		Weights *[]float64 `pennsieve:"weights"`
+	}
+	require.NoError(t, Bind(record, &converted))
+	assert.Equal(t, uint8(57), converted.ID)
+	assert.Equal(t, float32(57), converted.IDFloat)
+	assert.Equal(t, 6.78, converted.GPA)
+	if assert.NotNil(t, converted.Weights) {
+		assert.Equal(t, []float64{3, 5, 7}, *converted.Weights)
+	}
+
+	// a null value leaves a non-pointer field unchanged
+	stone, err := reader.Query("object").Where("name", Eq, "stone").Records()
+	require.NoError(t, err)
+	withDefault := struct {
+		GPA float64 `pennsieve:"gpa"`
+	}{GPA: -1}
+	require.NoError(t, Bind(stone[0], &withDefault))
+	assert.Equal(t, -1.0, withDefault.GPA)
+}
+
+func TestBind_NullArrayItems(t *testing.T) {
+	record := instance.Record{Values: []instance.Property{
+		{Name: "weights", DataType: []byte(`{"type": "array", "items": {"type": "Long"}}`), Value: []any{3.0, nil, 7.0}},
+	}}
+
+	var nillable struct {
+		Any      []any  `pennsieve:"weights"`
+		Pointers []*int `pennsieve:"weights,required"`
+		Values   []int  `pennsieve:"weights"`
+	}
+	require.NoError(t, Bind(record, &nillable))
+	assert.Equal(t, []any{int64(3), nil, int64(7)}, nillable.Any)
+	if assert.Len(t, nillable.Pointers, 3) {
+		assert.Equal(t, 3, *nillable.Pointers[0])
+		assert.Nil(t, nillable.Pointers[1])
+		assert.Equal(t, 7, *nillable.Pointers[2])
+	}
+	assert.Equal(t, []int{3, 0, 7}, nillable.Values)
+
+	var required struct {
+		Values []int `pennsieve:"weights,required"`
+	}
+	err := Bind(record, &required)
+	assert.ErrorIs(t, err, ErrRequiredProperty)
+	assert.ErrorContains(t, err, "item 1")
+}
+
+func TestBind_Errors(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+	records, err := reader.Query("object").Where("name", Eq, "stone").Records()
+	require.NoError(t, err)
+	stone := records[0]
+
+	var required struct {
+		GPA     float64 `pennsieve:"gpa,required"`
+		Missing string  `pennsieve:"missing,required"`
+	}
+	err = Bind(stone, &required)
+	assert.ErrorIs(t, err, ErrRequiredProperty)
+	assert.ErrorContains(t, err, "field GPA")
+	assert.ErrorContains(t, err, "field Missing")
+
+	var mismatched struct {
+		Name int    `pennsieve:"name"`
+		ID   string `pennsieve:"id"`
+	}
+	records, err = reader.Query("object").Where("name", Eq, "whatsit").Records()
+	require.NoError(t, err)
+	records[0].Values = append(records[0].Values, instance.Property{Name: "big", DataType: []byte(`"Long"`), Value: float64(1000)})
+	var overflow struct {
+		Big int8 `pennsieve:"big"`
+	}
+	assert.ErrorContains(t, Bind(records[0], &overflow), "Long value 1000 overflows int8 field")
+	err = Bind(stone, &mismatched)
+	assert.ErrorContains(t, err, "cannot set String value stone in int field")
+	assert.ErrorContains(t, err, "cannot set Long value 1 in string field")
+
+	assert.ErrorContains(t, Bind(stone, required), "bind target must be a non-nil pointer to a struct")
+	var badTag struct {
+		Name string `pennsieve:"name,optional"`
+	}
+	assert.ErrorContains(t, Bind(stone, &badTag), `unknown pennsieve tag option "optional"`)
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+)
+
+// DataTypeName returns a name for a data type that ignores formats, units and allowed values, for example "Long" or
+// "array of String"
+func DataTypeName(dataType datatypes.DataType) string {
+	if dataType.IsArray() {
+		return "array of " + string(dataType.BaseType())
+	}
+	return string(dataType.BaseType())
+}
+
+// CheckSchema compares the schema with the expected one, as recorded by code generated with metadata-gen.
+// expectedProperties maps model name to property name to DataTypeName, and expectedEdges maps relationship and linked
+// property names to their from and to model names. The returned error lists every expected model, property,
+// relationship or linked property that is missing from the schema or differs from what was expected.
+// Elements in the schema that were not expected are not reported.
+func CheckSchema(s *Schema, expectedProperties map[string]map[string]string, expectedEdges map[string][2]string) error {
+	var errs []error
+	for _, modelName := range sortedKeys(expectedProperties) {
+		if _, modelExists := s.ModelByName(modelName); !modelExists {
+			errs = append(errs, fmt.Errorf("model %s is missing", modelName))
+			continue
+		}
+		actualTypes := map[string]string{}
+		for _, property := range s.PropertiesForModel(modelName) {
+			dataType, err := property.DecodeDataType()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("property %s.%s: %w", modelName, property.Name, err))
+				continue
+			}
+			actualTypes[property.Name] = DataTypeName(dataType)
+		}
+		for _, propertyName := range sortedKeys(expectedProperties[modelName]) {
+			expectedType := expectedProperties[modelName][propertyName]
+			if actualType, propertyExists := actualTypes[propertyName]; !propertyExists {
+				errs = append(errs, fmt.Errorf("property %s.%s is missing", modelName, propertyName))
+			} else if actualType != expectedType {
+				errs = append(errs, fmt.Errorf("property %s.%s has type %s; expected %s", modelName, propertyName, actualType, expectedType))
+			}
+		}
+	}
+
+	modelNamesByID := map[string]string{}
+	for name, element := range s.modelNamesToSchemaElements {
+		modelNamesByID[element.ID] = name
+	}
+	actualEdges := map[string][2]string{}
+	for _, relationship := range s.Relationships() {
+		actualEdges[relationship.Name] = [2]string{modelNamesByID[relationship.From], modelNamesByID[relationship.To]}
+	}
+	for _, linkedProperty := range s.LinkedProperties() {
+		actualEdges[linkedProperty.Name] = [2]string{modelNamesByID[linkedProperty.From], modelNamesByID[linkedProperty.To]}
+	}
+	for _, edgeName := range sortedKeys(expectedEdges) {
+		expected := expectedEdges[edgeName]
+		if actual, edgeExists := actualEdges[edgeName]; !edgeExists {
+			errs = append(errs, fmt.Errorf("relationship or linked property %s is missing", edgeName))
+		} else if actual != expected {
+			errs = append(errs, fmt.Errorf("relationship or linked property %s is from %s to %s; expected from %s to %s",
+				edgeName, actual[0], actual[1], expected[0], expected[1]))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("schema has drifted: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	expectedProperties := map[string]map[string]string{
+		"subject":  {"name": "String", "id": "Long"},
+		"object":   {"weights": "array of Long", "birthday": "Date"},
+		"location": {"coordinates": "String"},
+	}
+	expectedEdges := map[string][2]string{
+		beholdsRelationship:   {"subject", "object"},
+		addressLinkedProperty: {"subject", "location"},
+	}
+	assert.NoError(t, CheckSchema(reader.Schema, expectedProperties, expectedEdges))
+
+	expectedProperties["subject"]["id"] = "String"
+	expectedProperties["object"]["color"] = "String"
+	expectedProperties["animal"] = map[string]string{"name": "String"}
+	expectedEdges[beholdsRelationship] = [2]string{"object", "subject"}
+	expectedEdges["eats"] = [2]string{"subject", "object"}
+	err = CheckSchema(reader.Schema, expectedProperties, expectedEdges)
+	require.Error(t, err)
+	for _, drift := range []string{
+		"property subject.id has type Long; expected String",
+		"property object.color is missing",
+		"model animal is missing",
+		"relationship or linked property " + beholdsRelationship + " is from subject to object; expected from object to subject",
+		"relationship or linked property eats is missing",
+	} {
+		assert.ErrorContains(t, err, drift)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"go/format"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// uuidSuffix matches the suffix Pennsieve adds to relationship names, as in has_been_at_9de740e0-29c1-11ef-bd79-2da515dfdab1
+var uuidSuffix = regexp.MustCompile(`_[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// initialisms are written in upper case in generated identifiers, following Go naming conventions
+var initialisms = map[string]bool{"id": true, "ids": true, "url": true, "uuid": true, "api": true, "http": true, "json": true, "doi": true}
+
+// graphMethods are the methods of client.Graph, which the generated Graph must not shadow
+var graphMethods = []string{"Record", "RecordCount", "EdgeCount", "Outgoing", "Incoming", "LinkedRecord", "Neighbors", "ShortestPath"}
+
+type modelInfo struct {
+	name   string
+	ident  string
+	fields []fieldInfo
+}
+
+type fieldInfo struct {
+	ident    string
+	property schema.Property
+	goType   string
+	typeName string
+}
+
+// generator holds the identifiers chosen for the schema elements
+type generator struct {
+	schema      *client.Schema
+	packageName string
+	models      []modelInfo
+	modelsByID  map[string]*modelInfo
+	// idents are the top level identifiers already used, and methods those of the generated Graph
+	idents  identifiers
+	methods identifiers
+	// relationshipIdents maps relationship names, which may share a name before their UUID suffix, to unique identifiers
+	relationshipIdents map[string]string
+	usesTime           bool
+}
+
+// Generate returns the formatted Go source for the models, relationships and linked properties of the reader's schema
+func Generate(reader *client.Reader, packageName string) ([]byte, error) {
+	g := &generator{
+		schema:             reader.Schema,
+		packageName:        packageName,
+		modelsByID:         map[string]*modelInfo{},
+		idents:             identifiers{"Graph": true, "NewGraph": true, "CheckSchema": true},
+		methods:            identifiers{},
+		relationshipIdents: map[string]string{},
+	}
+	for _, method := range graphMethods {
+		g.methods[method] = true
+	}
+	for _, modelName := range reader.Schema.ModelNames() {
+		model, err := g.modelInfo(modelName)
+		if err != nil {
+			return nil, err
+		}
+		g.models = append(g.models, model)
+	}
+	for i := range g.models {
+		model, _ := reader.Schema.ModelByName(g.models[i].name)
+		g.modelsByID[model.ID] = &g.models[i]
+	}
+	relationshipIdents := identifiers{}
+	for _, relationship := range reader.Schema.Relationships() {
+		g.relationshipIdents[relationship.Name] = relationshipIdents.unique(goIdent(uuidSuffix.ReplaceAllString(relationship.Name, "")))
+	}
+
+	var body bytes.Buffer
+	g.writeConstants(&body)
+	for _, model := range g.models {
+		g.writeModel(&body, model)
+	}
+	g.writeGraph(&body)
+	g.writeCheckSchema(&body)
+
+	var source bytes.Buffer
+	fmt.Fprintf(&source, "// Code generated by metadata-gen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", packageName)
+	fmt.Fprintf(&source, "\t\"fmt\"\n\t\"github.com/pennsieve/processor-pre-metadata/client\"\n")
+	if g.usesTime {
+		fmt.Fprintf(&source, "\t\"time\"\n")
+	}
+	fmt.Fprintf(&source, ")\n\n")
+	source.Write(body.Bytes())
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error formatting generated code: %w", err)
+	}
+	return formatted, nil
+}
+
+func (g *generator) modelInfo(modelName string) (modelInfo, error) {
+	model := modelInfo{name: modelName, ident: g.idents.unique(goIdent(modelName))}
+	fieldIdents := identifiers{"RecordMetadata": true}
+	for _, property := range g.schema.PropertiesForModel(modelName) {
+		dataType, err := property.DecodeDataType()
+		if err != nil {
+			return modelInfo{}, fmt.Errorf("error decoding data type of %s.%s: %w", modelName, property.Name, err)
+		}
+		goType, typeName := g.goType(dataType, property.Required)
+		model.fields = append(model.fields, fieldInfo{
+			ident:    fieldIdents.unique(goIdent(property.Name)),
+			property: property,
+			goType:   goType,
+			typeName: typeName,
+		})
+	}
+	return model, nil
+}
+
+// goType returns the Go type of a field for the given data type, and the data type's name as given by client.DataTypeName.
+// Optional scalars are pointers so that null values can be told apart from zero values.
+func (g *generator) goType(dataType datatypes.DataType, required bool) (goType string, typeName string) {
+	if dataType.IsArray() {
+		return "[]" + g.scalarGoType(dataType.BaseType()), client.DataTypeName(dataType)
+	}
+	if required {
+		return g.scalarGoType(dataType.BaseType()), client.DataTypeName(dataType)
+	}
+	return "*" + g.scalarGoType(dataType.BaseType()), client.DataTypeName(dataType)
+}
+
+func (g *generator) scalarGoType(simpleType datatypes.SimpleType) string {
+	switch simpleType {
+	case datatypes.StringType:
+		return "string"
+	case datatypes.LongType:
+		return "int64"
+	case datatypes.DoubleType:
+		return "float64"
+	case datatypes.BooleanType:
+		return "bool"
+	case datatypes.DateType:
+		g.usesTime = true
+		return "time.Time"
+	default:
+		return "any"
+	}
+}
+
+func (g *generator) writeConstants(w *bytes.Buffer) {
+	fmt.Fprintf(w, "// Model names\nconst (\n")
+	for _, model := range g.models {
+		fmt.Fprintf(w, "Model%s = %q\n", model.ident, model.name)
+	}
+	fmt.Fprintf(w, ")\n\n")
+	if relationships := g.schema.Relationships(); len(relationships) > 0 {
+		fmt.Fprintf(w, "// Relationship names\nconst (\n")
+		for _, relationship := range relationships {
+			fmt.Fprintf(w, "Relationship%s = %q\n", g.relationshipIdents[relationship.Name], relationship.Name)
+		}
+		fmt.Fprintf(w, ")\n\n")
+	}
+	if linkedProperties := g.schema.LinkedProperties(); len(linkedProperties) > 0 {
+		fmt.Fprintf(w, "// Linked property names\nconst (\n")
+		for _, linkedProperty := range linkedProperties {
+			fmt.Fprintf(w, "LinkedProperty%s = %q\n", goIdent(linkedProperty.Name), linkedProperty.Name)
+		}
+		fmt.Fprintf(w, ")\n\n")
+	}
+}
+
+func (g *generator) writeModel(w *bytes.Buffer, model modelInfo) {
+	fmt.Fprintf(w, "// %s is a record of the %s model\ntype %s struct {\nclient.RecordMetadata\n", model.ident, model.name, model.ident)
+	for _, field := range model.fields {
+		tag := field.property.Name
+		if field.property.Required {
+			tag += ",required"
+		}
+		fmt.Fprintf(w, "%s %s `pennsieve:%q`\n", field.ident, field.goType, tag)
+	}
+	fmt.Fprintf(w, "}\n\n")
+	getter := g.idents.unique("Get" + model.ident + "Records")
+	fmt.Fprintf(w, "// %s returns all records of the %s model\nfunc %s(reader *client.Reader) ([]%s, error) {\nreturn client.GetRecordsAs[%s](reader, Model%s)\n}\n\n",
+		getter, model.name, getter, model.ident, model.ident, model.ident)
+}
+
+func (g *generator) writeGraph(w *bytes.Buffer) {
+	fmt.Fprintf(w, `// Graph adds typed accessors for the models to a client.Graph
+type Graph struct {
+	*client.Graph
+}
+
+// NewGraph returns a Graph of the records read by reader
+func NewGraph(reader *client.Reader) (*Graph, error) {
+	graph, err := client.NewGraph(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Graph{Graph: graph}, nil
+}
+
+`)
+	for _, model := range g.models {
+		method := g.methods.unique(model.ident)
+		fmt.Fprintf(w, "// %s returns the %s record with the given id, or nil if there is none\nfunc (g *Graph) %s(id string) (*%s, error) {\nreturn bindRecord[%s](g.Graph, id, Model%s)\n}\n\n",
+			method, model.name, method, model.ident, model.ident, model.ident)
+	}
+	for _, relationship := range g.schema.Relationships() {
+		from, to := g.modelsByID[relationship.From], g.modelsByID[relationship.To]
+		if from == nil || to == nil {
+			continue
+		}
+		relIdent := g.relationshipIdents[relationship.Name]
+		outgoing := g.methods.unique(from.ident + relIdent)
+		fmt.Fprintf(w, "// %s returns the %s records that the given %s record has a %q relationship to\nfunc (g *Graph) %s(id string) ([]%s, error) {\nreturn bindEdgeEnds[%s](g.Graph, g.Outgoing(id, Relationship%s), true, Model%s)\n}\n\n",
+			outgoing, to.name, from.name, relationship.DisplayName, outgoing, to.ident, to.ident, relIdent, to.ident)
+		incoming := g.methods.unique(to.ident + relIdent + "From")
+		fmt.Fprintf(w, "// %s returns the %s records that have a %q relationship to the given %s record\nfunc (g *Graph) %s(id string) ([]%s, error) {\nreturn bindEdgeEnds[%s](g.Graph, g.Incoming(id, Relationship%s), false, Model%s)\n}\n\n",
+			incoming, from.name, relationship.DisplayName, to.name, incoming, from.ident, from.ident, relIdent, from.ident)
+	}
+	for _, linkedProperty := range g.schema.LinkedProperties() {
+		from, to := g.modelsByID[linkedProperty.From], g.modelsByID[linkedProperty.To]
+		if from == nil || to == nil {
+			continue
+		}
+		linkIdent := goIdent(linkedProperty.Name)
+		method := g.methods.unique(from.ident + linkIdent)
+		fmt.Fprintf(w, "// %s returns the %s record linked by the %s property of the given %s record, or nil if there is none\nfunc (g *Graph) %s(id string) (*%s, error) {\nlinked, isLinked := g.LinkedRecord(id, LinkedProperty%s)\nif !isLinked {\nreturn nil, nil\n}\nreturn bindRecord[%s](g.Graph, linked.ID, Model%s)\n}\n\n",
+			method, to.name, linkedProperty.Name, from.name, method, to.ident, linkIdent, to.ident, to.ident)
+	}
+	fmt.Fprintf(w, `func bindRecord[T any](graph *client.Graph, id string, modelName string) (*T, error) {
+	record, exists := graph.Record(id)
+	if !exists {
+		return nil, nil
+	}
+	if record.ModelName != modelName {
+		return nil, fmt.Errorf("record %%s is a %%s record, not a %%s record", id, record.ModelName, modelName)
+	}
+	var bound T
+	if err := client.Bind(record.Record, &bound); err != nil {
+		return nil, err
+	}
+	return &bound, nil
+}
+
+// bindEdgeEnds binds the record at the to end of each edge if outgoing, and at the from end otherwise
+func bindEdgeEnds[T any](graph *client.Graph, edges []client.Edge, outgoing bool, modelName string) ([]T, error) {
+	bound := make([]T, 0, len(edges))
+	for _, edge := range edges {
+		id := edge.From
+		if outgoing {
+			id = edge.To
+		}
+		record, err := bindRecord[T](graph, id, modelName)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			bound = append(bound, *record)
+		}
+	}
+	return bound, nil
+}
+
+`)
+}
+
+func (g *generator) writeCheckSchema(w *bytes.Buffer) {
+	modelName := func(modelID string) string {
+		if model := g.modelsByID[modelID]; model != nil {
+			return model.name
+		}
+		return modelID
+	}
+	fmt.Fprintf(w, "// generatedProperties maps each model name to its property names and data types when this file was generated\nvar generatedProperties = map[string]map[string]string{\n")
+	for _, model := range g.models {
+		fmt.Fprintf(w, "%q: {\n", model.name)
+		for _, field := range model.fields {
+			fmt.Fprintf(w, "%q: %q,\n", field.property.Name, field.typeName)
+		}
+		fmt.Fprintf(w, "},\n")
+	}
+	fmt.Fprintf(w, "}\n\n// generatedEdges maps each relationship and linked property name to its from and to model names when this file was generated\nvar generatedEdges = map[string][2]string{\n")
+	for _, relationship := range g.schema.Relationships() {
+		fmt.Fprintf(w, "%q: {%q, %q},\n", relationship.Name, modelName(relationship.From), modelName(relationship.To))
+	}
+	for _, linkedProperty := range g.schema.LinkedProperties() {
+		fmt.Fprintf(w, "%q: {%q, %q},\n", linkedProperty.Name, modelName(linkedProperty.From), modelName(linkedProperty.To))
+	}
+	fmt.Fprintf(w, `}
+
+// CheckSchema returns an error listing every model, property, relationship and linked property of this file that is
+// missing from the reader's schema or has changed type or ends. Additions to the schema are not reported.
+func CheckSchema(reader *client.Reader) error {
+	return client.CheckSchema(reader.Schema, generatedProperties, generatedEdges)
+}
+`)
+}
+
+// goIdent returns an exported Go identifier for a Pennsieve name, such as IsSolid for is_solid or hasBeenAt
+func goIdent(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var ident strings.Builder
+	for _, part := range parts {
+		if initialisms[strings.ToLower(part)] {
+			ident.WriteString(strings.ToUpper(part))
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		ident.WriteString(string(runes))
+	}
+	if ident.Len() == 0 {
+		return "X"
+	}
+	if first := []rune(ident.String())[0]; !unicode.IsLetter(first) {
+		return "X" + ident.String()
+	}
+	return ident.String()
+}
+
+// identifiers is a set of identifiers already in use
+type identifiers map[string]bool
+
+// unique returns ident, or ident followed by the smallest number greater than 1 that is not in use, and marks it used
+func (ids identifiers) unique(ident string) string {
+	candidate := ident
+	for n := 2; ids[candidate]; n++ {
+		candidate = ident + strconv.Itoa(n)
+	}
+	ids[candidate] = true
+	return candidate
+}
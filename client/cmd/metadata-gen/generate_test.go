@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/pennsieve/processor-pre-metadata/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+// TestGenerate_UpToDate fails if internal/testmodels was not regenerated after a change to the generator or the test data
+func TestGenerate_UpToDate(t *testing.T) {
+	reader, err := client.NewReader("../../testdata")
+	require.NoError(t, err)
+	source, err := Generate(reader, "testmodels")
+	require.NoError(t, err)
+
+	committed, err := os.ReadFile("internal/testmodels/models_gen.go")
+	require.NoError(t, err)
+	assert.Equal(t, string(committed), string(source), "run go generate ./... in internal/testmodels")
+}
+
+func TestGoIdent(t *testing.T) {
+	for name, expected := range map[string]string{
+		"is_solid":    "IsSolid",
+		"hasBeenAt":   "HasBeenAt",
+		"id":          "ID",
+		"subject_url": "SubjectURL",
+		"2nd-visit":   "X2ndVisit",
+		"Name":        "Name",
+		"---":         "X",
+	} {
+		assert.Equal(t, expected, goIdent(name), name)
+	}
+}
+
+func TestIdentifiers_Unique(t *testing.T) {
+	ids := identifiers{"Record": true}
+	assert.Equal(t, "Record2", ids.unique("Record"))
+	assert.Equal(t, "Record3", ids.unique("Record"))
+	assert.Equal(t, "Subject", ids.unique("Subject"))
+}
@@ -0,0 +1,5 @@
+// Package testmodels is generated by metadata-gen from the client's test data, to check that generated code compiles
+// and works against the schema it was generated from.
+package testmodels
+
+//go:generate go run ../.. -dir ../../../../testdata -out models_gen.go
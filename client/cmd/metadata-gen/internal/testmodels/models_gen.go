@@ -0,0 +1,192 @@
+// Code generated by metadata-gen. DO NOT EDIT.
+
+package testmodels
+
+import (
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client"
+	"time"
+)
+
+// Model names
+const (
+	ModelLocation = "location"
+	ModelObject   = "object"
+	ModelSubject  = "subject"
+)
+
+// Relationship names
+const (
+	RelationshipBeholds   = "beholds_6a012da0-29bb-11ef-a8a5-6d16b0d3d9a0"
+	RelationshipHasBeenAt = "has_been_at_9de740e0-29c1-11ef-bd79-2da515dfdab1"
+)
+
+// Linked property names
+const (
+	LinkedPropertyAddress = "address"
+)
+
+// Location is a record of the location model
+type Location struct {
+	client.RecordMetadata
+	Coordinates string `pennsieve:"coordinates,required"`
+}
+
+// GetLocationRecords returns all records of the location model
+func GetLocationRecords(reader *client.Reader) ([]Location, error) {
+	return client.GetRecordsAs[Location](reader, ModelLocation)
+}
+
+// Object is a record of the object model
+type Object struct {
+	client.RecordMetadata
+	Weights  []int64    `pennsieve:"weights"`
+	Synonyms []string   `pennsieve:"synonyms"`
+	Gpa      *float64   `pennsieve:"gpa"`
+	Birthday *time.Time `pennsieve:"birthday"`
+	IsSolid  *bool      `pennsieve:"is_solid"`
+	ID       int64      `pennsieve:"id,required"`
+	Name     *string    `pennsieve:"name"`
+}
+
+// GetObjectRecords returns all records of the object model
+func GetObjectRecords(reader *client.Reader) ([]Object, error) {
+	return client.GetRecordsAs[Object](reader, ModelObject)
+}
+
+// Subject is a record of the subject model
+type Subject struct {
+	client.RecordMetadata
+	Name string `pennsieve:"name,required"`
+	ID   *int64 `pennsieve:"id"`
+}
+
+// GetSubjectRecords returns all records of the subject model
+func GetSubjectRecords(reader *client.Reader) ([]Subject, error) {
+	return client.GetRecordsAs[Subject](reader, ModelSubject)
+}
+
+// Graph adds typed accessors for the models to a client.Graph
+type Graph struct {
+	*client.Graph
+}
+
+// NewGraph returns a Graph of the records read by reader
+func NewGraph(reader *client.Reader) (*Graph, error) {
+	graph, err := client.NewGraph(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Graph{Graph: graph}, nil
+}
+
+// Location returns the location record with the given id, or nil if there is none
+func (g *Graph) Location(id string) (*Location, error) {
+	return bindRecord[Location](g.Graph, id, ModelLocation)
+}
+
+// Object returns the object record with the given id, or nil if there is none
+func (g *Graph) Object(id string) (*Object, error) {
+	return bindRecord[Object](g.Graph, id, ModelObject)
+}
+
+// Subject returns the subject record with the given id, or nil if there is none
+func (g *Graph) Subject(id string) (*Subject, error) {
+	return bindRecord[Subject](g.Graph, id, ModelSubject)
+}
+
+// SubjectBeholds returns the object records that the given subject record has a "Beholds" relationship to
+func (g *Graph) SubjectBeholds(id string) ([]Object, error) {
+	return bindEdgeEnds[Object](g.Graph, g.Outgoing(id, RelationshipBeholds), true, ModelObject)
+}
+
+// ObjectBeholdsFrom returns the subject records that have a "Beholds" relationship to the given object record
+func (g *Graph) ObjectBeholdsFrom(id string) ([]Subject, error) {
+	return bindEdgeEnds[Subject](g.Graph, g.Incoming(id, RelationshipBeholds), false, ModelSubject)
+}
+
+// ObjectHasBeenAt returns the location records that the given object record has a "Has Been At" relationship to
+func (g *Graph) ObjectHasBeenAt(id string) ([]Location, error) {
+	return bindEdgeEnds[Location](g.Graph, g.Outgoing(id, RelationshipHasBeenAt), true, ModelLocation)
+}
+
+// LocationHasBeenAtFrom returns the object records that have a "Has Been At" relationship to the given location record
+func (g *Graph) LocationHasBeenAtFrom(id string) ([]Object, error) {
+	return bindEdgeEnds[Object](g.Graph, g.Incoming(id, RelationshipHasBeenAt), false, ModelObject)
+}
+
+// SubjectAddress returns the location record linked by the address property of the given subject record, or nil if there is none
+func (g *Graph) SubjectAddress(id string) (*Location, error) {
+	linked, isLinked := g.LinkedRecord(id, LinkedPropertyAddress)
+	if !isLinked {
+		return nil, nil
+	}
+	return bindRecord[Location](g.Graph, linked.ID, ModelLocation)
+}
+
+func bindRecord[T any](graph *client.Graph, id string, modelName string) (*T, error) {
+	record, exists := graph.Record(id)
+	if !exists {
+		return nil, nil
+	}
+	if record.ModelName != modelName {
+		return nil, fmt.Errorf("record %s is a %s record, not a %s record", id, record.ModelName, modelName)
+	}
+	var bound T
+	if err := client.Bind(record.Record, &bound); err != nil {
+		return nil, err
+	}
+	return &bound, nil
+}
+
+// bindEdgeEnds binds the record at the to end of each edge if outgoing, and at the from end otherwise
+func bindEdgeEnds[T any](graph *client.Graph, edges []client.Edge, outgoing bool, modelName string) ([]T, error) {
+	bound := make([]T, 0, len(edges))
+	for _, edge := range edges {
+		id := edge.From
+		if outgoing {
+			id = edge.To
+		}
+		record, err := bindRecord[T](graph, id, modelName)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			bound = append(bound, *record)
+		}
+	}
+	return bound, nil
+}
+
+// generatedProperties maps each model name to its property names and data types when this file was generated
+var generatedProperties = map[string]map[string]string{
+	"location": {
+		"coordinates": "String",
+	},
+	"object": {
+		"weights":  "array of Long",
+		"synonyms": "array of String",
+		"gpa":      "Double",
+		"birthday": "Date",
+		"is_solid": "Boolean",
+		"id":       "Long",
+		"name":     "String",
+	},
+	"subject": {
+		"name": "String",
+		"id":   "Long",
+	},
+}
+
+// generatedEdges maps each relationship and linked property name to its from and to model names when this file was generated
+var generatedEdges = map[string][2]string{
+	"beholds_6a012da0-29bb-11ef-a8a5-6d16b0d3d9a0":     {"subject", "object"},
+	"has_been_at_9de740e0-29c1-11ef-bd79-2da515dfdab1": {"object", "location"},
+	"address": {"subject", "location"},
+}
+
+// CheckSchema returns an error listing every model, property, relationship and linked property of this file that is
+// missing from the reader's schema or has changed type or ends. Additions to the schema are not reported.
+func CheckSchema(reader *client.Reader) error {
+	return client.CheckSchema(reader.Schema, generatedProperties, generatedEdges)
+}
@@ -0,0 +1,98 @@
+package testmodels
+
+import (
+	"github.com/pennsieve/processor-pre-metadata/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const (
+	subjectRecordID        = "7681b4f8-7d10-4855-8c87-7fef3b408c0b"
+	objectRecordID         = "5b07e038-9829-46c9-b698-bf4efef81341"
+	locationRecordID       = "e79e8d65-b094-4f36-94f2-1553cd84b4a2"
+	unlinkedObjectRecordID = "a9b9d03b-19b3-4a43-b40e-5673ec955e49"
+)
+
+func newTestReader(t *testing.T) *client.Reader {
+	reader, err := client.NewReader("../../../../testdata")
+	require.NoError(t, err)
+	return reader
+}
+
+func TestCheckSchema(t *testing.T) {
+	assert.NoError(t, CheckSchema(newTestReader(t)))
+}
+
+func TestGetRecords(t *testing.T) {
+	reader := newTestReader(t)
+
+	subjects, err := GetSubjectRecords(reader)
+	require.NoError(t, err)
+	require.Len(t, subjects, 1)
+	assert.Equal(t, subjectRecordID, subjects[0].RecordMetadata.ID)
+	assert.Equal(t, "Person A", subjects[0].Name)
+	require.NotNil(t, subjects[0].ID)
+	assert.Equal(t, int64(1), *subjects[0].ID)
+
+	objects, err := GetObjectRecords(reader)
+	require.NoError(t, err)
+	require.Len(t, objects, 3)
+	for _, object := range objects {
+		if object.RecordMetadata.ID != unlinkedObjectRecordID {
+			continue
+		}
+		assert.Equal(t, int64(57), object.ID)
+		assert.Equal(t, []int64{3, 5, 7}, object.Weights)
+		assert.Equal(t, []string{"thingamabob", "whosit", "doo-dad"}, object.Synonyms)
+		require.NotNil(t, object.Birthday)
+		assert.Equal(t, time.Date(2024, 9, 26, 22, 1, 4, 0, time.UTC), *object.Birthday)
+		require.NotNil(t, object.IsSolid)
+		assert.True(t, *object.IsSolid)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	graph, err := NewGraph(newTestReader(t))
+	require.NoError(t, err)
+
+	beheld, err := graph.SubjectBeholds(subjectRecordID)
+	require.NoError(t, err)
+	require.Len(t, beheld, 1)
+	assert.Equal(t, objectRecordID, beheld[0].RecordMetadata.ID)
+	assert.Equal(t, "stone", *beheld[0].Name)
+	assert.Nil(t, beheld[0].Weights)
+	assert.Nil(t, beheld[0].Gpa)
+
+	beholders, err := graph.ObjectBeholdsFrom(objectRecordID)
+	require.NoError(t, err)
+	require.Len(t, beholders, 1)
+	assert.Equal(t, subjectRecordID, beholders[0].RecordMetadata.ID)
+
+	locations, err := graph.ObjectHasBeenAt(objectRecordID)
+	require.NoError(t, err)
+	require.Len(t, locations, 1)
+	assert.Equal(t, "(x_1,y_1,z_1)", locations[0].Coordinates)
+
+	address, err := graph.SubjectAddress(subjectRecordID)
+	require.NoError(t, err)
+	require.NotNil(t, address)
+	assert.Equal(t, locationRecordID, address.RecordMetadata.ID)
+
+	none, err := graph.SubjectBeholds(unlinkedObjectRecordID)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	subject, err := graph.Subject(subjectRecordID)
+	require.NoError(t, err)
+	require.NotNil(t, subject)
+	assert.Equal(t, "Person A", subject.Name)
+
+	missing, err := graph.Location("missing")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	_, err = graph.Location(subjectRecordID)
+	assert.ErrorContains(t, err, "is a subject record")
+}
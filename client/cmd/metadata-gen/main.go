@@ -0,0 +1,52 @@
+// Command metadata-gen generates Go types for the models of a dataset from the metadata directory written by the
+// pre-processor. It is meant to be run with go:generate, for example
+//
+//	//go:generate go run github.com/pennsieve/processor-pre-metadata/client/cmd/metadata-gen -dir ../testdata -out models_gen.go
+//
+// where -dir is the directory containing the metadata directory. The generated file has
+//   - a struct per model, bindable with client.Bind and client.GetRecordsAs
+//   - constants for the model, relationship and linked property names
+//   - a Graph type with typed accessors for the relationships and linked properties between the models
+//   - a CheckSchema function that reports where a downloaded schema has drifted from the generated code
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client"
+	"os"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "metadata-gen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("metadata-gen", flag.ContinueOnError)
+	dir := flags.String("dir", ".", "the directory containing the metadata directory")
+	packageName := flags.String("package", os.Getenv("GOPACKAGE"), "the package of the generated file. Defaults to $GOPACKAGE, set by go generate")
+	out := flags.String("out", "metadata_gen.go", "the generated file")
+	allowIncomplete := flags.Bool("allow-incomplete", false, "read a metadata directory without a completion marker")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*packageName) == 0 {
+		return fmt.Errorf("-package is required outside of go generate")
+	}
+	var readerOptions []client.ReaderOption
+	if *allowIncomplete {
+		readerOptions = append(readerOptions, client.AllowIncomplete())
+	}
+	reader, err := client.NewReader(*dir, readerOptions...)
+	if err != nil {
+		return err
+	}
+	source, err := Generate(reader, *packageName)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*out, source, 0644)
+}
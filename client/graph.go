@@ -0,0 +1,310 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"os"
+	"sort"
+)
+
+// EdgeKind distinguishes the two kinds of Edge in a Graph
+type EdgeKind uint8
+
+const (
+	RelationshipEdge EdgeKind = iota
+	LinkedPropertyEdge
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case RelationshipEdge:
+		return "relationship"
+	case LinkedPropertyEdge:
+		return "linkedProperty"
+	default:
+		return fmt.Sprintf("EdgeKind(%d)", k)
+	}
+}
+
+// Edge is a relationship instance or linked property instance between two records of a Graph
+type Edge struct {
+	Kind EdgeKind
+	// ID is the id of the relationship or linked property instance
+	ID string
+	// Name is the name of the schema relationship or linked property
+	Name string
+	From string
+	To   string
+}
+
+// GraphRecord is a record in a Graph along with the name of its model
+type GraphRecord struct {
+	ModelName string
+	instance.Record
+}
+
+// graphEdge is the compact form of an Edge. Record ids and names are replaced by indices into Graph.records and Graph.names.
+type graphEdge struct {
+	from, to int32
+	name     int32
+	kind     EdgeKind
+	id       string
+}
+
+// Graph is an in-memory index of the records of a dataset and the relationship and linked property instances between
+// them. Records are identified by their id across all models. A Graph is safe for concurrent reads.
+type Graph struct {
+	records     []GraphRecord
+	recordIndex map[string]int32
+	// names are the interned relationship and linked property names, indexed by graphEdge.name
+	names     []string
+	nameIndex map[string]int32
+	edges     []graphEdge
+	// outgoing and incoming hold, for each record index, the indices of its edges in edges
+	outgoing [][]int32
+	incoming [][]int32
+}
+
+// NewGraph reads every record, relationship instance and linked property instance available to the reader and
+// returns a Graph of them. Instances with an end that is not one of the records, for example because its model was
+// not exported, are left out, and a linked property without an instances file has no edges.
+func NewGraph(reader *Reader) (*Graph, error) {
+	g := &Graph{
+		recordIndex: map[string]int32{},
+		nameIndex:   map[string]int32{},
+	}
+	for _, modelName := range sortedKeys(reader.Schema.modelNamesToSchemaElements) {
+		records, err := reader.GetRecordsForModel(modelName)
+		if err != nil {
+			return nil, fmt.Errorf("error building graph: %w", err)
+		}
+		for _, record := range records {
+			g.recordIndex[record.ID] = int32(len(g.records))
+			g.records = append(g.records, GraphRecord{ModelName: modelName, Record: record})
+		}
+	}
+	g.outgoing = make([][]int32, len(g.records))
+	g.incoming = make([][]int32, len(g.records))
+
+	for _, relationshipName := range sortedKeys(reader.Schema.relationshipNamesToRelationship) {
+		relationships, err := reader.GetRelationshipInstances(relationshipName)
+		if err != nil {
+			return nil, fmt.Errorf("error building graph: %w", err)
+		}
+		for _, relationship := range relationships {
+			g.addEdge(RelationshipEdge, relationship.ID, relationshipName, relationship.From, relationship.To)
+		}
+	}
+	for _, linkedPropertyName := range sortedKeys(reader.Schema.linkedPropNamesToSchemaElements) {
+		links, err := reader.GetLinkInstancesForProperty(linkedPropertyName)
+		if errors.Is(err, os.ErrNotExist) {
+			// the pre-processor writes no instances file for a linked property without instances
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error building graph: %w", err)
+		}
+		for _, link := range links {
+			g.addEdge(LinkedPropertyEdge, link.ID, linkedPropertyName, link.From, link.To)
+		}
+	}
+	return g, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (g *Graph) addEdge(kind EdgeKind, id, name, fromID, toID string) {
+	from, fromExists := g.recordIndex[fromID]
+	to, toExists := g.recordIndex[toID]
+	if !fromExists || !toExists {
+		return
+	}
+	nameIdx, interned := g.nameIndex[name]
+	if !interned {
+		nameIdx = int32(len(g.names))
+		g.names = append(g.names, name)
+		g.nameIndex[name] = nameIdx
+	}
+	edgeIdx := int32(len(g.edges))
+	g.edges = append(g.edges, graphEdge{from: from, to: to, name: nameIdx, kind: kind, id: id})
+	g.outgoing[from] = append(g.outgoing[from], edgeIdx)
+	g.incoming[to] = append(g.incoming[to], edgeIdx)
+}
+
+func (g *Graph) edge(edgeIdx int32) Edge {
+	e := g.edges[edgeIdx]
+	return Edge{
+		Kind: e.kind,
+		ID:   e.id,
+		Name: g.names[e.name],
+		From: g.records[e.from].ID,
+		To:   g.records[e.to].ID,
+	}
+}
+
+// RecordCount returns the number of records in the graph
+func (g *Graph) RecordCount() int {
+	return len(g.records)
+}
+
+// EdgeCount returns the number of relationship and linked property instances in the graph
+func (g *Graph) EdgeCount() int {
+	return len(g.edges)
+}
+
+// Record returns the record with the given id, whatever its model
+func (g *Graph) Record(recordID string) (record GraphRecord, recordExists bool) {
+	idx, recordExists := g.recordIndex[recordID]
+	if !recordExists {
+		return GraphRecord{}, false
+	}
+	return g.records[idx], true
+}
+
+// Outgoing returns the edges from the given record with the given relationship or linked property name.
+// An empty name matches all edges.
+func (g *Graph) Outgoing(recordID string, name string) []Edge {
+	idx, recordExists := g.recordIndex[recordID]
+	if !recordExists {
+		return nil
+	}
+	return g.filterEdges(g.outgoing[idx], name)
+}
+
+// Incoming returns the edges to the given record with the given relationship or linked property name.
+// An empty name matches all edges.
+func (g *Graph) Incoming(recordID string, name string) []Edge {
+	idx, recordExists := g.recordIndex[recordID]
+	if !recordExists {
+		return nil
+	}
+	return g.filterEdges(g.incoming[idx], name)
+}
+
+func (g *Graph) filterEdges(edgeIndices []int32, name string) []Edge {
+	nameIdx := int32(-1)
+	if len(name) > 0 {
+		var interned bool
+		if nameIdx, interned = g.nameIndex[name]; !interned {
+			return nil
+		}
+	}
+	var edges []Edge
+	for _, edgeIdx := range edgeIndices {
+		if nameIdx < 0 || g.edges[edgeIdx].name == nameIdx {
+			edges = append(edges, g.edge(edgeIdx))
+		}
+	}
+	return edges
+}
+
+// LinkedRecord returns the record that the given record links to with the given linked property
+func (g *Graph) LinkedRecord(recordID string, linkedPropertyName string) (record GraphRecord, linked bool) {
+	idx, recordExists := g.recordIndex[recordID]
+	if !recordExists {
+		return GraphRecord{}, false
+	}
+	nameIdx, interned := g.nameIndex[linkedPropertyName]
+	if !interned {
+		return GraphRecord{}, false
+	}
+	for _, edgeIdx := range g.outgoing[idx] {
+		if e := g.edges[edgeIdx]; e.kind == LinkedPropertyEdge && e.name == nameIdx {
+			return g.records[e.to], true
+		}
+	}
+	return GraphRecord{}, false
+}
+
+// Neighbors returns the records at most hops edges away from the given record, following edges in either direction.
+// The records are ordered by distance, and the given record itself is not included.
+func (g *Graph) Neighbors(recordID string, hops int) []GraphRecord {
+	start, recordExists := g.recordIndex[recordID]
+	if !recordExists || hops < 1 {
+		return nil
+	}
+	visited := map[int32]bool{start: true}
+	frontier := []int32{start}
+	var neighbors []GraphRecord
+	for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+		var next []int32
+		for _, idx := range frontier {
+			g.forEachNeighbor(idx, func(neighbor int32, _ int32) {
+				if !visited[neighbor] {
+					visited[neighbor] = true
+					next = append(next, neighbor)
+					neighbors = append(neighbors, g.records[neighbor])
+				}
+			})
+		}
+		frontier = next
+	}
+	return neighbors
+}
+
+// forEachNeighbor calls f with the other end of each edge of the record at idx, and the index of that edge
+func (g *Graph) forEachNeighbor(idx int32, f func(neighbor int32, edgeIdx int32)) {
+	for _, edgeIdx := range g.outgoing[idx] {
+		f(g.edges[edgeIdx].to, edgeIdx)
+	}
+	for _, edgeIdx := range g.incoming[idx] {
+		f(g.edges[edgeIdx].from, edgeIdx)
+	}
+}
+
+// ShortestPath returns the edges of a shortest path between the given records, following edges in either direction.
+// Each Edge keeps its own direction, so consecutive edges may not be head to tail. Returns false if there is no path
+// or either record does not exist. The path from a record to itself is empty.
+func (g *Graph) ShortestPath(fromID, toID string) (path []Edge, pathExists bool) {
+	from, fromExists := g.recordIndex[fromID]
+	to, toExists := g.recordIndex[toID]
+	if !fromExists || !toExists {
+		return nil, false
+	}
+	if from == to {
+		return []Edge{}, true
+	}
+	// via maps each visited record to the edge by which it was reached
+	via := map[int32]int32{from: -1}
+	frontier := []int32{from}
+	for len(frontier) > 0 {
+		var next []int32
+		for _, idx := range frontier {
+			g.forEachNeighbor(idx, func(neighbor int32, edgeIdx int32) {
+				if _, visited := via[neighbor]; !visited {
+					via[neighbor] = edgeIdx
+					next = append(next, neighbor)
+				}
+			})
+			if _, found := via[to]; found {
+				return g.tracePath(via, from, to), true
+			}
+		}
+		frontier = next
+	}
+	return nil, false
+}
+
+func (g *Graph) tracePath(via map[int32]int32, from, to int32) []Edge {
+	var path []Edge
+	for idx := to; idx != from; {
+		edgeIdx := via[idx]
+		path = append(path, g.edge(edgeIdx))
+		if e := g.edges[edgeIdx]; e.to == idx {
+			idx = e.from
+		} else {
+			idx = e.to
+		}
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// In the testdata, subject 7681b4f8 beholds object 5b07e038 which has been at location e79e8d65,
+// and subject 7681b4f8 has location e79e8d65 as its address. Objects a9b9d03b and bcf06e0c are not linked to anything.
+const (
+	subjectRecordID         = "7681b4f8-7d10-4855-8c87-7fef3b408c0b"
+	objectRecordID          = "5b07e038-9829-46c9-b698-bf4efef81341"
+	locationRecordID        = "e79e8d65-b094-4f36-94f2-1553cd84b4a2"
+	unlinkedObjectRecordID  = "a9b9d03b-19b3-4a43-b40e-5673ec955e49"
+	beholdsRelationship     = "beholds_6a012da0-29bb-11ef-a8a5-6d16b0d3d9a0"
+	hasBeenAtRelationship   = "has_been_at_9de740e0-29c1-11ef-bd79-2da515dfdab1"
+	addressLinkedProperty   = "address"
+	beholdsInstanceID       = "cf2a668c-0e4c-46bc-b799-c29397b22feb"
+	hasBeenAtInstanceID     = "d2839796-4496-471d-b1e2-d6fe16582bff"
+	addressLinkedInstanceID = "b7bcfc2b-a406-44d7-aeb8-09f440802b3a"
+)
+
+func newTestGraph(t *testing.T) *Graph {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+	graph, err := NewGraph(reader)
+	require.NoError(t, err)
+	return graph
+}
+
+func TestGraph_Record(t *testing.T) {
+	graph := newTestGraph(t)
+	assert.Equal(t, 5, graph.RecordCount())
+	assert.Equal(t, 3, graph.EdgeCount())
+
+	for recordID, expectedModel := range map[string]string{
+		subjectRecordID:        "subject",
+		objectRecordID:         "object",
+		locationRecordID:       "location",
+		unlinkedObjectRecordID: "object",
+	} {
+		record, exists := graph.Record(recordID)
+		if assert.True(t, exists, "record %s not found", recordID) {
+			assert.Equal(t, recordID, record.ID)
+			assert.Equal(t, expectedModel, record.ModelName)
+		}
+	}
+	_, exists := graph.Record("missing")
+	assert.False(t, exists)
+}
+
+func TestGraph_OutgoingIncoming(t *testing.T) {
+	graph := newTestGraph(t)
+
+	beholds := Edge{Kind: RelationshipEdge, ID: beholdsInstanceID, Name: beholdsRelationship, From: subjectRecordID, To: objectRecordID}
+	address := Edge{Kind: LinkedPropertyEdge, ID: addressLinkedInstanceID, Name: addressLinkedProperty, From: subjectRecordID, To: locationRecordID}
+	hasBeenAt := Edge{Kind: RelationshipEdge, ID: hasBeenAtInstanceID, Name: hasBeenAtRelationship, From: objectRecordID, To: locationRecordID}
+
+	assert.ElementsMatch(t, []Edge{beholds, address}, graph.Outgoing(subjectRecordID, ""))
+	assert.Equal(t, []Edge{beholds}, graph.Outgoing(subjectRecordID, beholdsRelationship))
+	assert.Empty(t, graph.Outgoing(subjectRecordID, hasBeenAtRelationship))
+	assert.Empty(t, graph.Outgoing(subjectRecordID, "unknown"))
+	assert.Empty(t, graph.Incoming(subjectRecordID, ""))
+
+	assert.ElementsMatch(t, []Edge{address, hasBeenAt}, graph.Incoming(locationRecordID, ""))
+	assert.Equal(t, []Edge{hasBeenAt}, graph.Incoming(locationRecordID, hasBeenAtRelationship))
+	assert.Empty(t, graph.Outgoing(unlinkedObjectRecordID, ""))
+	assert.Nil(t, graph.Outgoing("missing", ""))
+}
+
+func TestGraph_LinkedRecord(t *testing.T) {
+	graph := newTestGraph(t)
+
+	location, linked := graph.LinkedRecord(subjectRecordID, addressLinkedProperty)
+	require.True(t, linked)
+	assert.Equal(t, locationRecordID, location.ID)
+	assert.Equal(t, "location", location.ModelName)
+
+	_, linked = graph.LinkedRecord(objectRecordID, addressLinkedProperty)
+	assert.False(t, linked)
+	_, linked = graph.LinkedRecord(subjectRecordID, beholdsRelationship)
+	assert.False(t, linked, "relationships are not linked properties")
+}
+
+func TestGraph_Neighbors(t *testing.T) {
+	graph := newTestGraph(t)
+
+	recordIDs := func(records []GraphRecord) []string {
+		var ids []string
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	assert.Nil(t, graph.Neighbors(objectRecordID, 0))
+	assert.ElementsMatch(t, []string{subjectRecordID, locationRecordID}, recordIDs(graph.Neighbors(objectRecordID, 1)))
+	assert.ElementsMatch(t, []string{objectRecordID, locationRecordID}, recordIDs(graph.Neighbors(subjectRecordID, 1)))
+	assert.ElementsMatch(t, []string{objectRecordID, locationRecordID}, recordIDs(graph.Neighbors(subjectRecordID, 5)))
+	assert.Empty(t, graph.Neighbors(unlinkedObjectRecordID, 3))
+}
+
+func TestGraph_ShortestPath(t *testing.T) {
+	graph := newTestGraph(t)
+
+	path, exists := graph.ShortestPath(subjectRecordID, locationRecordID)
+	require.True(t, exists)
+	assert.Equal(t, []Edge{{Kind: LinkedPropertyEdge, ID: addressLinkedInstanceID, Name: addressLinkedProperty, From: subjectRecordID, To: locationRecordID}}, path)
+
+	// object to subject is against the direction of the beholds relationship
+	path, exists = graph.ShortestPath(locationRecordID, objectRecordID)
+	require.True(t, exists)
+	assert.Equal(t, []Edge{{Kind: RelationshipEdge, ID: hasBeenAtInstanceID, Name: hasBeenAtRelationship, From: objectRecordID, To: locationRecordID}}, path)
+
+	path, exists = graph.ShortestPath(objectRecordID, objectRecordID)
+	assert.True(t, exists)
+	assert.Empty(t, path)
+
+	_, exists = graph.ShortestPath(subjectRecordID, unlinkedObjectRecordID)
+	assert.False(t, exists)
+	_, exists = graph.ShortestPath(subjectRecordID, "missing")
+	assert.False(t, exists)
+}
+
+func TestGraph_ShortestPath_Chain(t *testing.T) {
+	// r0 -> r1 <- r2 -> r3, plus a longer detour r0 -> r4 -> r5 -> r6 -> r3
+	g := &Graph{recordIndex: map[string]int32{}, nameIndex: map[string]int32{}}
+	recordIDs := []string{"r0", "r1", "r2", "r3", "r4", "r5", "r6"}
+	for i, id := range recordIDs {
+		g.recordIndex[id] = int32(i)
+		g.records = append(g.records, GraphRecord{ModelName: "model"})
+		g.records[i].ID = id
+	}
+	g.outgoing = make([][]int32, len(recordIDs))
+	g.incoming = make([][]int32, len(recordIDs))
+	g.addEdge(RelationshipEdge, "e0", "rel", "r0", "r1")
+	g.addEdge(RelationshipEdge, "e1", "rel", "r2", "r1")
+	g.addEdge(LinkedPropertyEdge, "e2", "link", "r2", "r3")
+	g.addEdge(RelationshipEdge, "e3", "rel", "r0", "r4")
+	g.addEdge(RelationshipEdge, "e4", "rel", "r4", "r5")
+	g.addEdge(RelationshipEdge, "e5", "rel", "r5", "r6")
+	g.addEdge(RelationshipEdge, "e6", "rel", "r6", "r3")
+	g.addEdge(RelationshipEdge, "dangling", "rel", "r0", "missing")
+	assert.Equal(t, 7, g.EdgeCount())
+
+	path, exists := g.ShortestPath("r0", "r3")
+	require.True(t, exists)
+	var edgeIDs []string
+	for _, e := range path {
+		edgeIDs = append(edgeIDs, e.ID)
+	}
+	assert.Equal(t, []string{"e0", "e1", "e2"}, edgeIDs)
+	assert.Equal(t, Edge{Kind: RelationshipEdge, ID: "e1", Name: "rel", From: "r2", To: "r1"}, path[1])
+	assert.Len(t, g.Neighbors("r0", 2), 4)
+}
+
+func TestNewGraph_MissingLinkedPropertyInstances(t *testing.T) {
+	dir := copyTestdata(t)
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "metadata", "instances", "linkedProperties")))
+	reader, err := NewReader(dir)
+	require.NoError(t, err)
+	graph, err := NewGraph(reader)
+	require.NoError(t, err)
+
+	_, linked := graph.LinkedRecord(subjectRecordID, addressLinkedProperty)
+	assert.False(t, linked)
+	_, found := graph.Record(subjectRecordID)
+	assert.True(t, found)
+}
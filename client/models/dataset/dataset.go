@@ -0,0 +1,34 @@
+package dataset
+
+import "time"
+
+// Dataset represents the contents of metadata/dataset.json
+type Dataset struct {
+	// ID is the dataset node id, for example N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5
+	ID          string   `json:"id"`
+	IntID       int64    `json:"intId"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	// License is empty if no license has been chosen
+	License string `json:"license"`
+	// Status is the name of the dataset's status, for example NO_STATUS
+	Status string `json:"status"`
+	// OwnerID is the node id of the user who owns the dataset
+	OwnerID      string        `json:"ownerId"`
+	Contributors []Contributor `json:"contributors"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	UpdatedAt    time.Time     `json:"updatedAt"`
+}
+
+type Contributor struct {
+	ID            int64  `json:"id"`
+	FirstName     string `json:"firstName"`
+	MiddleInitial string `json:"middleInitial,omitempty"`
+	LastName      string `json:"lastName"`
+	Degree        string `json:"degree,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Orcid         string `json:"orcid,omitempty"`
+	// UserID is nil if the contributor is not a Pennsieve user
+	UserID *int64 `json:"userId,omitempty"`
+}
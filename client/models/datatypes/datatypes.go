@@ -1,5 +1,28 @@
 package datatypes
 
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// DataType is a decoded property data type. The implementations are SimpleType, FormattedDataType,
+// UnitDataType, EnumDataType, and ArrayDataType, whose items may themselves be formatted, carry a unit, or be an
+// enumeration. Each encodes back to the JSON it was decoded from.
+type DataType interface {
+	// BaseType returns the SimpleType of the value, or of each item if the data type is an array
+	BaseType() SimpleType
+	// IsArray returns true if values of the data type are arrays
+	IsArray() bool
+	// Validate returns an error wrapping ErrInvalidValue if value, as found in decoded JSON or as returned by
+	// Normalize, is not a valid value of the data type. A nil value is valid.
+	Validate(value any) error
+	fmt.Stringer
+}
+
 type SimpleType string
 
 const StringType SimpleType = "String"
@@ -8,17 +31,260 @@ const DoubleType SimpleType = "Double"
 const BooleanType SimpleType = "Boolean"
 const DateType SimpleType = "Date"
 
+func (t SimpleType) BaseType() SimpleType {
+	return t
+}
+
+func (t SimpleType) IsArray() bool {
+	return false
+}
+
+func (t SimpleType) String() string {
+	return string(t)
+}
+
 type ComplexType string
 
 const ArrayType ComplexType = "array"
+const EnumType ComplexType = "enum"
+
+// FormattedDataType is a SimpleType with a format, for example {"type": "String", "format": "url"}. See Formats.
+type FormattedDataType struct {
+	Type   SimpleType `json:"type"`
+	Format string     `json:"format"`
+}
+
+func (t FormattedDataType) BaseType() SimpleType {
+	return t.Type
+}
 
+func (t FormattedDataType) IsArray() bool {
+	return false
+}
+
+func (t FormattedDataType) String() string {
+	return fmt.Sprintf("%s with format %s", t.Type, t.Format)
+}
+
+// UnitDataType is a SimpleType with a unit, for example {"type": "Double", "unit": "kg"}
+type UnitDataType struct {
+	Type SimpleType `json:"type"`
+	Unit string     `json:"unit"`
+}
+
+func (t UnitDataType) BaseType() SimpleType {
+	return t.Type
+}
+
+func (t UnitDataType) IsArray() bool {
+	return false
+}
+
+func (t UnitDataType) String() string {
+	return fmt.Sprintf("%s in %s", t.Type, t.Unit)
+}
+
+// EnumDataType is a single value from Items.Enum, for example {"type": "enum", "items": {"type": "String", "enum": ["red", "blue"]}}
+type EnumDataType struct {
+	Type  ComplexType `json:"type"`
+	Items ItemsType   `json:"items"`
+}
+
+func (t EnumDataType) BaseType() SimpleType {
+	return t.Items.Type
+}
+
+func (t EnumDataType) IsArray() bool {
+	return false
+}
+
+func (t EnumDataType) String() string {
+	return fmt.Sprintf("enum of %s", t.Items.Type)
+}
+
+// ArrayDataType is an array of Items, for example {"type": "array", "items": {"type": "Long", "unit": "kg"}}
 type ArrayDataType struct {
 	Type  ComplexType `json:"type"`
 	Items ItemsType   `json:"items"`
 }
 
+func (t ArrayDataType) BaseType() SimpleType {
+	return t.Items.Type
+}
+
+func (t ArrayDataType) IsArray() bool {
+	return true
+}
+
+func (t ArrayDataType) String() string {
+	return fmt.Sprintf("array of %s", t.Items.DataType())
+}
+
+// Unit returns the unit of a value, or of each item of an array, of the given data type, or an empty string if there is none
+func Unit(dataType DataType) string {
+	switch dt := dataType.(type) {
+	case UnitDataType:
+		return dt.Unit
+	case EnumDataType:
+		return dt.Items.Unit
+	case ArrayDataType:
+		return dt.Items.Unit
+	default:
+		return ""
+	}
+}
+
+// ItemsType describes the items of an ArrayDataType or the allowed values of an EnumDataType
 type ItemsType struct {
 	Type   SimpleType `json:"type"`
 	Format string     `json:"format,omitempty"`
 	Unit   string     `json:"unit,omitempty"`
+	// Enum lists the allowed values, if any
+	Enum []any `json:"enum,omitempty"`
+}
+
+// DataType returns the data type of a single item: an EnumDataType if there are allowed values, otherwise a
+// UnitDataType, FormattedDataType or SimpleType
+func (i ItemsType) DataType() DataType {
+	switch {
+	case len(i.Enum) > 0:
+		return EnumDataType{Type: EnumType, Items: i}
+	case len(i.Unit) > 0:
+		return UnitDataType{Type: i.Type, Unit: i.Unit}
+	case len(i.Format) > 0:
+		return FormattedDataType{Type: i.Type, Format: i.Format}
+	default:
+		return i.Type
+	}
+}
+
+// check returns an error if the items have both a format and a unit, or allowed values that are not of their type
+func (i ItemsType) check() error {
+	if len(i.Format) > 0 && len(i.Unit) > 0 {
+		return fmt.Errorf("%s has both format %s and unit %s", i.Type, i.Format, i.Unit)
+	}
+	for _, allowed := range i.Enum {
+		if _, err := Normalize(i.Type, allowed); err != nil {
+			return fmt.Errorf("invalid enum value: %w", err)
+		}
+	}
+	return nil
+}
+
+// DateLayouts are the formats in which Pennsieve writes Date values, in the order they are tried
+var DateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
+// Decode returns the DataType encoded in the given dataType, which may be a JSON string, for a SimpleType,
+// or a JSON object.
+func Decode(dataType json.RawMessage) (DataType, error) {
+	var simpleType SimpleType
+	if err := json.Unmarshal(dataType, &simpleType); err == nil && len(simpleType) > 0 {
+		return simpleType, nil
+	}
+	var typeOnly struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(dataType, &typeOnly); err != nil || len(typeOnly.Type) == 0 {
+		return nil, fmt.Errorf("data type %s is not a string or an object with a type", dataType)
+	}
+	switch ComplexType(typeOnly.Type) {
+	case ArrayType:
+		var arrayType ArrayDataType
+		if err := json.Unmarshal(dataType, &arrayType); err != nil {
+			return nil, fmt.Errorf("data type %s is not a valid array type: %w", dataType, err)
+		}
+		if err := arrayType.Items.check(); err != nil {
+			return nil, fmt.Errorf("data type %s is not a valid array type: %w", dataType, err)
+		}
+		return arrayType, nil
+	case EnumType:
+		var enumType EnumDataType
+		if err := json.Unmarshal(dataType, &enumType); err != nil {
+			return nil, fmt.Errorf("data type %s is not a valid enum type: %w", dataType, err)
+		}
+		if err := enumType.Items.check(); err != nil {
+			return nil, fmt.Errorf("data type %s is not a valid enum type: %w", dataType, err)
+		}
+		return enumType, nil
+	}
+	// a scalar object has the same fields as array items
+	var scalarType ItemsType
+	if err := json.Unmarshal(dataType, &scalarType); err != nil {
+		return nil, fmt.Errorf("data type %s is not a valid scalar type: %w", dataType, err)
+	}
+	if len(scalarType.Enum) > 0 {
+		return nil, fmt.Errorf("data type %s is not a valid scalar type: allowed values require type %s", dataType, EnumType)
+	}
+	if err := scalarType.check(); err != nil {
+		return nil, fmt.Errorf("data type %s is not a valid scalar type: %w", dataType, err)
+	}
+	return scalarType.DataType(), nil
+}
+
+// Normalize converts a value to the Go type used for simpleType: string for String, int64 for Long, float64 for
+// Double, bool for Boolean, and time.Time for Date. It accepts the values found in decoded JSON, including Boolean
+// values written as "true" or "false" and Date values written in one of the DateLayouts, as well as any Go integer
+// or float where the conversion does not lose information. A nil value returns nil.
+func Normalize(simpleType SimpleType, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch simpleType {
+	case StringType:
+		if s, isString := value.(string); isString {
+			return s, nil
+		}
+	case LongType:
+		if n, isNumber := value.(json.Number); isNumber {
+			return n.Int64()
+		}
+		if v := reflect.ValueOf(value); v.CanInt() {
+			return v.Int(), nil
+		} else if v.CanUint() {
+			return int64(v.Uint()), nil
+		} else if v.CanFloat() {
+			if f := v.Float(); f == math.Trunc(f) {
+				return int64(f), nil
+			}
+			return nil, fmt.Errorf("%v is not an integer", value)
+		}
+	case DoubleType:
+		if n, isNumber := value.(json.Number); isNumber {
+			return n.Float64()
+		}
+		if v := reflect.ValueOf(value); v.CanFloat() {
+			return v.Float(), nil
+		} else if v.CanInt() {
+			return float64(v.Int()), nil
+		} else if v.CanUint() {
+			return float64(v.Uint()), nil
+		}
+	case BooleanType:
+		switch b := value.(type) {
+		case bool:
+			return b, nil
+		case string:
+			return strconv.ParseBool(b)
+		}
+	case DateType:
+		switch d := value.(type) {
+		case time.Time:
+			return d, nil
+		case string:
+			return ParseDate(d)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data type %s", simpleType)
+	}
+	return nil, fmt.Errorf("cannot use %v (%T) as %s", value, value, simpleType)
+}
+
+// ParseDate parses a Date value written in one of the DateLayouts
+func ParseDate(value string) (time.Time, error) {
+	for _, layout := range DateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a date", value)
 }
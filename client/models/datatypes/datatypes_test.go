@@ -0,0 +1,115 @@
+package datatypes
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	for dataType, expected := range map[string]DataType{
+		`"Long"`:                           LongType,
+		`{"type":"String","format":"url"}`: FormattedDataType{Type: StringType, Format: "url"},
+		`{"type":"Double","unit":"kg"}`:    UnitDataType{Type: DoubleType, Unit: "kg"},
+		`{"type":"enum","items":{"type":"String","enum":["red","blue"]}}`: EnumDataType{
+			Type:  EnumType,
+			Items: ItemsType{Type: StringType, Enum: []any{"red", "blue"}},
+		},
+		`{"type":"array","items":{"type":"Long","unit":"cm"}}`: ArrayDataType{
+			Type:  ArrayType,
+			Items: ItemsType{Type: LongType, Unit: "cm"},
+		},
+		`{"type":"array","items":{"type":"Long","enum":[1,2,3]}}`: ArrayDataType{
+			Type:  ArrayType,
+			Items: ItemsType{Type: LongType, Enum: []any{float64(1), float64(2), float64(3)}},
+		},
+	} {
+		decoded, err := Decode(json.RawMessage(dataType))
+		if assert.NoError(t, err, dataType) {
+			assert.Equal(t, expected, decoded, dataType)
+			encoded, err := json.Marshal(decoded)
+			require.NoError(t, err)
+			assert.JSONEq(t, dataType, string(encoded), "round trip of %s", dataType)
+		}
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	for _, dataType := range []string{
+		`null`,
+		`{"format":"url"}`,
+		`{"type":"Double","format":"percent","unit":"kg"}`,
+		`{"type":"String","enum":["red"]}`,
+		`{"type":"enum","items":{"type":"Long","enum":["one"]}}`,
+		`{"type":"array","items":"Long"}`,
+	} {
+		_, err := Decode(json.RawMessage(dataType))
+		assert.Error(t, err, dataType)
+	}
+}
+
+func TestDataType_Accessors(t *testing.T) {
+	enumArray := ArrayDataType{Type: ArrayType, Items: ItemsType{Type: StringType, Enum: []any{"red"}}}
+	assert.Equal(t, StringType, enumArray.BaseType())
+	assert.True(t, enumArray.IsArray())
+	assert.Equal(t, "array of enum of String", enumArray.String())
+	assert.Equal(t, EnumDataType{Type: EnumType, Items: enumArray.Items}, enumArray.Items.DataType())
+
+	weight := UnitDataType{Type: DoubleType, Unit: "kg"}
+	assert.Equal(t, DoubleType, weight.BaseType())
+	assert.False(t, weight.IsArray())
+	assert.Equal(t, "kg", Unit(weight))
+	assert.Equal(t, "cm", Unit(ArrayDataType{Type: ArrayType, Items: ItemsType{Type: LongType, Unit: "cm"}}))
+	assert.Empty(t, Unit(LongType))
+}
+
+func TestValidate(t *testing.T) {
+	colors := EnumDataType{Type: EnumType, Items: ItemsType{Type: StringType, Enum: []any{"red", "blue"}}}
+	sizes := EnumDataType{Type: EnumType, Items: ItemsType{Type: LongType, Enum: []any{float64(1), float64(2)}}}
+	days := EnumDataType{Type: EnumType, Items: ItemsType{Type: DateType, Enum: []any{"2024-09-26"}}}
+	for _, test := range []struct {
+		dataType DataType
+		value    any
+		valid    bool
+	}{
+		{LongType, float64(3), true},
+		{LongType, 3.5, false},
+		{LongType, "3", false},
+		{BooleanType, "true", true},
+		{DateType, "2024-09-26T22:01:04", true},
+		{DateType, "yesterday", false},
+		{StringType, nil, true},
+		{FormattedDataType{Type: StringType, Format: "url"}, "https://pennsieve.io/datasets", true},
+		{FormattedDataType{Type: StringType, Format: "url"}, "pennsieve.io", false},
+		{FormattedDataType{Type: StringType, Format: "email"}, "someone@example.com", true},
+		{FormattedDataType{Type: StringType, Format: "email"}, "someone", false},
+		{FormattedDataType{Type: StringType, Format: "date-time"}, "2024-09-26T22:01:04Z", true},
+		{FormattedDataType{Type: StringType, Format: "date"}, "26/09/2024", false},
+		{FormattedDataType{Type: StringType, Format: "unchecked"}, "anything", true},
+		{FormattedDataType{Type: StringType, Format: "url"}, 7, false},
+		{UnitDataType{Type: DoubleType, Unit: "kg"}, 6.78, true},
+		{UnitDataType{Type: DoubleType, Unit: "kg"}, "heavy", false},
+		{colors, "red", true},
+		{colors, "green", false},
+		{colors, nil, true},
+		{sizes, int64(2), true},
+		{sizes, float64(3), false},
+		{days, time.Date(2024, 9, 26, 0, 0, 0, 0, time.UTC), true},
+		{ArrayDataType{Type: ArrayType, Items: ItemsType{Type: LongType}}, []any{float64(3), float64(5)}, true},
+		{ArrayDataType{Type: ArrayType, Items: ItemsType{Type: LongType}}, []int64{3, 5}, true},
+		{ArrayDataType{Type: ArrayType, Items: ItemsType{Type: LongType}}, []any{float64(3), "five"}, false},
+		{ArrayDataType{Type: ArrayType, Items: ItemsType{Type: LongType}}, float64(3), false},
+		{ArrayDataType{Type: ArrayType, Items: colors.Items}, []any{"red", "blue"}, true},
+		{ArrayDataType{Type: ArrayType, Items: colors.Items}, []any{"red", "green"}, false},
+		{ArrayDataType{Type: ArrayType, Items: ItemsType{Type: StringType, Format: "email"}}, []any{"someone"}, false},
+	} {
+		err := test.dataType.Validate(test.value)
+		if test.valid {
+			assert.NoError(t, err, "%s %v", test.dataType, test.value)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidValue, "%s %v", test.dataType, test.value)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package datatypes
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// ErrInvalidValue is returned, wrapped, by DataType.Validate when a value is not valid for the data type
+var ErrInvalidValue = errors.New("invalid value")
+
+// Formats maps the String formats that Validate checks to a function returning an error if a value does not have the
+// format. Values of other formats are only checked to be strings.
+var Formats = map[string]func(value string) error{
+	"date":      validateDate,
+	"date-time": validateDate,
+	"datetime":  validateDate,
+	"time": func(value string) error {
+		_, err := time.Parse("15:04:05", value)
+		return err
+	},
+	"email": func(value string) error {
+		_, err := mail.ParseAddress(value)
+		return err
+	},
+	"url": validateURL,
+	"uri": validateURL,
+}
+
+func validateDate(value string) error {
+	_, err := ParseDate(value)
+	return err
+}
+
+func validateURL(value string) error {
+	parsed, err := url.ParseRequestURI(value)
+	if err != nil {
+		return err
+	}
+	if len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+		return fmt.Errorf("%q is not an absolute URL", value)
+	}
+	return nil
+}
+
+func invalidValue(dataType DataType, value any, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: cannot use %v (%T) as %s: %s", ErrInvalidValue, value, value, dataType, err)
+	}
+	return fmt.Errorf("%w: cannot use %v (%T) as %s", ErrInvalidValue, value, value, dataType)
+}
+
+func (t SimpleType) Validate(value any) error {
+	if _, err := Normalize(t, value); err != nil {
+		return invalidValue(t, value, err)
+	}
+	return nil
+}
+
+func (t FormattedDataType) Validate(value any) error {
+	if value == nil {
+		return nil
+	}
+	if err := t.Type.Validate(value); err != nil {
+		return err
+	}
+	validateFormat, checked := Formats[t.Format]
+	if s, isString := value.(string); checked && isString && t.Type == StringType {
+		if err := validateFormat(s); err != nil {
+			return invalidValue(t, value, err)
+		}
+	}
+	return nil
+}
+
+func (t UnitDataType) Validate(value any) error {
+	return t.Type.Validate(value)
+}
+
+func (t EnumDataType) Validate(value any) error {
+	if value == nil {
+		return nil
+	}
+	normalized, err := Normalize(t.Items.Type, value)
+	if err != nil {
+		return invalidValue(t, value, err)
+	}
+	for _, allowed := range t.Items.Enum {
+		if normalizedAllowed, err := Normalize(t.Items.Type, allowed); err == nil && equalNormalized(normalized, normalizedAllowed) {
+			return nil
+		}
+	}
+	return invalidValue(t, value, fmt.Errorf("allowed values are %v", t.Items.Enum))
+}
+
+func (t ArrayDataType) Validate(value any) error {
+	if value == nil {
+		return nil
+	}
+	slice := reflect.ValueOf(value)
+	if slice.Kind() != reflect.Slice && slice.Kind() != reflect.Array {
+		return invalidValue(t, value, nil)
+	}
+	itemType := t.Items.DataType()
+	var errs []error
+	for i := 0; i < slice.Len(); i++ {
+		if err := itemType.Validate(slice.Index(i).Interface()); err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// equalNormalized compares two values returned by Normalize for the same SimpleType
+func equalNormalized(left any, right any) bool {
+	if leftTime, isTime := left.(time.Time); isTime {
+		return leftTime.Equal(right.(time.Time))
+	}
+	return left == right
+}
@@ -1,5 +1,7 @@
 package instance
 
+import "time"
+
 type LinkedProperty struct {
 	DisplayName          string `json:"displayName"`
 	From                 string `json:"from"`
@@ -9,3 +11,18 @@ type LinkedProperty struct {
 	To                   string `json:"to"`
 	Type                 string `json:"type"`
 }
+
+type Relationship struct {
+	CreatedAt            time.Time  `json:"createdAt"`
+	CreatedBy            string     `json:"createdBy"`
+	DisplayName          string     `json:"displayName"`
+	From                 string     `json:"from"`
+	ID                   string     `json:"id"`
+	Name                 string     `json:"name"`
+	SchemaRelationshipID string     `json:"schemaRelationshipId"`
+	To                   string     `json:"to"`
+	Type                 string     `json:"type"`
+	UpdatedAt            time.Time  `json:"updatedAt"`
+	UpdatedBy            string     `json:"updatedBy"`
+	Values               []Property `json:"values"`
+}
@@ -5,8 +5,38 @@ import (
 	"errors"
 	"fmt"
 	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"time"
 )
 
+// ErrNullValue is returned, wrapped, by the typed accessors of a Property whose value is null
+var ErrNullValue = errors.New("property value is null")
+
+// TypeMismatchError is returned by the typed accessors of a Property when the property's data type or value
+// cannot be read as the requested type
+type TypeMismatchError struct {
+	// Property is the name of the property
+	Property string
+	// Requested is the type the caller asked for, for example "Long" or "array of String"
+	Requested string
+	// DataType is the property's data type as written in the record
+	DataType json.RawMessage
+	// Value is the property's value, or nil if the mismatch is with the data type
+	Value any
+	// Err is the underlying problem, if any
+	Err error
+}
+
+func (e *TypeMismatchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("cannot read property %s with data type %s as %s: %s", e.Property, e.DataType, e.Requested, e.Err)
+	}
+	return fmt.Sprintf("cannot read property %s with data type %s as %s", e.Property, e.DataType, e.Requested)
+}
+
+func (e *TypeMismatchError) Unwrap() error {
+	return e.Err
+}
+
 type Property struct {
 	ConceptTitle bool `json:"conceptTitle"`
 	// DataType can be a string or a JSON object
@@ -19,80 +49,212 @@ type Property struct {
 	Value       any             `json:"value"`
 }
 
-func (p Property) DecodeDataType() (any, error) {
-	var simpleType datatypes.SimpleType
-	if err := json.Unmarshal(p.DataType, &simpleType); err != nil {
-		var arrayType datatypes.ArrayDataType
-		if arrErr := json.Unmarshal(p.DataType, &arrayType); arrErr != nil {
-			return nil, fmt.Errorf("data type %s is not simple or array: %w", p.DataType, errors.Join(err, arrErr))
-		}
-		return arrayType, nil
+func (p Property) DecodeDataType() (datatypes.DataType, error) {
+	return datatypes.Decode(p.DataType)
+}
+
+// Validate returns an error if the property's value is not valid for its data type, wrapping
+// datatypes.ErrInvalidValue if the data type could be decoded. A null value is valid.
+func (p Property) Validate() error {
+	dataType, err := p.DecodeDataType()
+	if err != nil {
+		return fmt.Errorf("property %s: %w", p.Name, err)
+	}
+	if err := dataType.Validate(p.Value); err != nil {
+		return fmt.Errorf("property %s: %w", p.Name, err)
 	}
-	return simpleType, nil
+	return nil
 }
 
-func (p Property) LongValue() (int64, error) {
+// IsNull returns true if the property has no value
+func (p Property) IsNull() bool {
+	return p.Value == nil
+}
+
+// isMissing is true for the zero Property returned by Record.Property for a property the record does not have
+func (p Property) isMissing() bool {
+	return len(p.DataType) == 0 && p.Value == nil
+}
+
+func (p Property) mismatch(requested string, value any, err error) error {
+	return &TypeMismatchError{Property: p.Name, Requested: requested, DataType: p.DataType, Value: value, Err: err}
+}
+
+func (p Property) nullValue() error {
+	return fmt.Errorf("property %s: %w", p.Name, ErrNullValue)
+}
+
+// scalarValue returns the property's value as the Go type datatypes.Normalize uses for the requested type, along
+// with the unit of the data type, if any
+func (p Property) scalarValue(requested datatypes.SimpleType) (value any, unit string, err error) {
+	if p.isMissing() {
+		return nil, "", p.nullValue()
+	}
 	dataType, err := p.DecodeDataType()
 	if err != nil {
-		return 0, err
-	}
-	switch dt := dataType.(type) {
-	case datatypes.SimpleType:
-		if dt == datatypes.LongType {
-			return int64(p.Value.(float64)), nil
-		} else {
-			return 0, fmt.Errorf("data type is not Long: %s", dt)
-		}
-	case datatypes.ArrayDataType:
-		return 0, fmt.Errorf("data type is not Long: %T", dt)
-	default:
-		return 0, fmt.Errorf("unknown dataType: %T", dt)
+		return nil, "", p.mismatch(string(requested), nil, err)
+	}
+	simpleType, unit := dataType.BaseType(), datatypes.Unit(dataType)
+	if dataType.IsArray() || simpleType != requested {
+		return nil, "", p.mismatch(string(requested), nil, nil)
+	}
+	if p.Value == nil {
+		return nil, unit, p.nullValue()
+	}
+	value, err = datatypes.Normalize(simpleType, p.Value)
+	if err != nil {
+		return nil, unit, p.mismatch(string(requested), p.Value, err)
+	}
+	return value, unit, nil
+}
+
+// StringValue returns the value of a String property. Returns an error wrapping ErrNullValue if the value is null,
+// or a *TypeMismatchError if the property is not a String.
+func (p Property) StringValue() (string, error) {
+	value, _, err := p.scalarValue(datatypes.StringType)
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// LongValue returns the value of a Long property. Returns an error wrapping ErrNullValue if the value is null,
+// or a *TypeMismatchError if the property is not a Long.
+func (p Property) LongValue() (int64, error) {
+	value, _, err := p.LongWithUnit()
+	return value, err
+}
+
+// LongWithUnit returns the value of a Long property along with its unit, which is empty if the data type has none
+func (p Property) LongWithUnit() (int64, string, error) {
+	value, unit, err := p.scalarValue(datatypes.LongType)
+	if err != nil {
+		return 0, unit, err
+	}
+	return value.(int64), unit, nil
+}
+
+// DoubleValue returns the value of a Double property. Returns an error wrapping ErrNullValue if the value is null,
+// or a *TypeMismatchError if the property is not a Double.
+func (p Property) DoubleValue() (float64, error) {
+	value, _, err := p.DoubleWithUnit()
+	return value, err
+}
+
+// DoubleWithUnit returns the value of a Double property along with its unit, which is empty if the data type has none
+func (p Property) DoubleWithUnit() (float64, string, error) {
+	value, unit, err := p.scalarValue(datatypes.DoubleType)
+	if err != nil {
+		return 0, unit, err
 	}
+	return value.(float64), unit, nil
+}
 
+// BoolValue returns the value of a Boolean property, which Pennsieve may write as a JSON boolean or as "true" or "false".
+// Returns an error wrapping ErrNullValue if the value is null, or a *TypeMismatchError if the property is not a Boolean.
+func (p Property) BoolValue() (bool, error) {
+	value, _, err := p.scalarValue(datatypes.BooleanType)
+	if err != nil {
+		return false, err
+	}
+	return value.(bool), nil
 }
 
+// DateValue returns the value of a Date property, parsed with datatypes.DateLayouts. Returns an error wrapping
+// ErrNullValue if the value is null, or a *TypeMismatchError if the property is not a Date.
+func (p Property) DateValue() (time.Time, error) {
+	value, _, err := p.scalarValue(datatypes.DateType)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return value.(time.Time), nil
+}
+
+// ArrayValue returns the value of an array property as a []int64, []string, []float64, []bool, or, for arrays of
+// Date, []string. A null value returns a nil slice of the right type. Prefer ArrayOf, which returns dates as time.Time.
 func (p Property) ArrayValue() (any, error) {
 	dataType, err := p.DecodeDataType()
 	if err != nil {
 		return nil, err
 	}
-	switch dt := dataType.(type) {
-	case datatypes.ArrayDataType:
-		if dt.Type == datatypes.ArrayType && dt.Items.Type == datatypes.LongType {
-			var longs []int64
-			if p.Value == nil {
-				return longs, nil
-			}
-			for _, l := range p.Value.([]any) {
-				longs = append(longs, int64(l.(float64)))
-			}
-			return longs, nil
-		} else if dt.Type == datatypes.ArrayType && dt.Items.Type == datatypes.StringType {
-			return convertArray[string](p.Value), nil
-		} else if dt.Type == datatypes.ArrayType && dt.Items.Type == datatypes.DoubleType {
-			return convertArray[float64](p.Value), nil
-		} else if dt.Type == datatypes.ArrayType && dt.Items.Type == datatypes.BooleanType {
-			return convertArray[bool](p.Value), nil
-		} else if dt.Type == datatypes.ArrayType && dt.Items.Type == datatypes.DateType {
-			return convertArray[string](p.Value), nil
-		} else {
-			return nil, fmt.Errorf("data type is not array of Longs: %s of %s", dt.Type, dt.Items.Type)
+	arrayType, isArray := dataType.(datatypes.ArrayDataType)
+	if !isArray {
+		return nil, p.mismatch("array", nil, nil)
+	}
+	switch arrayType.Items.Type {
+	case datatypes.LongType:
+		return nullableArray[int64](p)
+	case datatypes.StringType, datatypes.DateType:
+		if p.Value == nil {
+			return []string(nil), nil
 		}
-	case datatypes.SimpleType:
-		return nil, fmt.Errorf("data type is not array: %T", dt)
+		return arrayOf[string](p, datatypes.StringType)
+	case datatypes.DoubleType:
+		return nullableArray[float64](p)
+	case datatypes.BooleanType:
+		return nullableArray[bool](p)
 	default:
-		return nil, fmt.Errorf("unknown dataType: %T", dt)
+		return nil, p.mismatch("array", nil, fmt.Errorf("unsupported item type %s", arrayType.Items.Type))
 	}
 }
 
-func convertArray[T any](src any) []T {
-	if src == nil {
-		return []T(nil)
+func nullableArray[T any](p Property) ([]T, error) {
+	if p.Value == nil {
+		return nil, nil
 	}
-	srcSlice := src.([]any)
-	converted := make([]T, len(srcSlice))
-	for i, v := range srcSlice {
-		converted[i] = v.(T)
+	return ArrayOf[T](p)
+}
+
+// ArrayOf returns the value of an array property as a []T, where T is string, int64, float64, bool or time.Time,
+// matching the array's item type as datatypes.Normalize does. Returns an error wrapping ErrNullValue if the value is
+// null, or a *TypeMismatchError if the property is not an array of T.
+func ArrayOf[T any](p Property) ([]T, error) {
+	values, _, err := ArrayWithUnitOf[T](p)
+	return values, err
+}
+
+// ArrayWithUnitOf is ArrayOf that also returns the unit of the array's items, which is empty if there is none
+func ArrayWithUnitOf[T any](p Property) ([]T, string, error) {
+	var zero T
+	requested := fmt.Sprintf("array of %T", zero)
+	if p.isMissing() {
+		return nil, "", p.nullValue()
+	}
+	dataType, err := p.DecodeDataType()
+	if err != nil {
+		return nil, "", p.mismatch(requested, nil, err)
+	}
+	arrayType, isArray := dataType.(datatypes.ArrayDataType)
+	if !isArray {
+		return nil, "", p.mismatch(requested, nil, nil)
+	}
+	unit := arrayType.Items.Unit
+	if p.Value == nil {
+		return nil, unit, p.nullValue()
+	}
+	values, err := arrayOf[T](p, arrayType.Items.Type)
+	return values, unit, err
+}
+
+// arrayOf converts each item of the property's value with datatypes.Normalize for itemType
+func arrayOf[T any](p Property, itemType datatypes.SimpleType) ([]T, error) {
+	var zero T
+	requested := fmt.Sprintf("array of %T", zero)
+	items, isSlice := p.Value.([]any)
+	if !isSlice {
+		return nil, p.mismatch(requested, p.Value, nil)
+	}
+	values := make([]T, len(items))
+	for i, item := range items {
+		normalized, err := datatypes.Normalize(itemType, item)
+		if err != nil {
+			return nil, p.mismatch(requested, p.Value, fmt.Errorf("item %d: %w", i, err))
+		}
+		value, isT := normalized.(T)
+		if !isT {
+			return nil, p.mismatch(requested, nil, nil)
+		}
+		values[i] = value
 	}
-	return converted
+	return values, nil
 }
@@ -0,0 +1,173 @@
+package instance
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func property(t *testing.T, name string, dataType string, value string) Property {
+	var p Property
+	require.NoError(t, json.Unmarshal([]byte(`{"name": "`+name+`", "dataType": `+dataType+`, "value": `+value+`}`), &p))
+	return p
+}
+
+func TestProperty_ScalarAccessors(t *testing.T) {
+	s, err := property(t, "name", `"String"`, `"stone"`).StringValue()
+	require.NoError(t, err)
+	assert.Equal(t, "stone", s)
+
+	l, err := property(t, "id", `"Long"`, `57`).LongValue()
+	require.NoError(t, err)
+	assert.Equal(t, int64(57), l)
+
+	d, err := property(t, "gpa", `"Double"`, `6.78`).DoubleValue()
+	require.NoError(t, err)
+	assert.Equal(t, 6.78, d)
+
+	// Pennsieve may write booleans as strings
+	for _, value := range []string{`true`, `"true"`} {
+		b, err := property(t, "is_solid", `"Boolean"`, value).BoolValue()
+		require.NoError(t, err)
+		assert.True(t, b)
+	}
+
+	for value, expected := range map[string]time.Time{
+		`"2024-09-26T22:01:04"`:           time.Date(2024, 9, 26, 22, 1, 4, 0, time.UTC),
+		`"2024-09-26T22:01:04.5"`:         time.Date(2024, 9, 26, 22, 1, 4, 500000000, time.UTC),
+		`"2024-09-26T22:01:04.5+02:00"`:   time.Date(2024, 9, 26, 20, 1, 4, 500000000, time.UTC),
+		`"2024-09-26"`:                    time.Date(2024, 9, 26, 0, 0, 0, 0, time.UTC),
+		`"2024-06-13T19:52:58.692000Z"`:   time.Date(2024, 6, 13, 19, 52, 58, 692000000, time.UTC),
+		`"2024-06-13T19:52:58.692+00:00"`: time.Date(2024, 6, 13, 19, 52, 58, 692000000, time.UTC),
+	} {
+		date, err := property(t, "birthday", `"Date"`, value).DateValue()
+		if assert.NoError(t, err, value) {
+			assert.True(t, expected.Equal(date), "expected %s, got %s", expected, date)
+		}
+	}
+}
+
+func TestProperty_UnitAccessors(t *testing.T) {
+	l, unit, err := property(t, "height", `{"type": "Long", "unit": "cm"}`, `180`).LongWithUnit()
+	require.NoError(t, err)
+	assert.Equal(t, int64(180), l)
+	assert.Equal(t, "cm", unit)
+
+	d, unit, err := property(t, "mass", `{"type": "Double", "unit": "kg"}`, `1.5`).DoubleWithUnit()
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, d)
+	assert.Equal(t, "kg", unit)
+
+	// the plain accessors ignore the unit
+	d, err = property(t, "mass", `{"type": "Double", "unit": "kg"}`, `1.5`).DoubleValue()
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, d)
+
+	_, unit, err = property(t, "id", `"Long"`, `1`).LongWithUnit()
+	require.NoError(t, err)
+	assert.Empty(t, unit)
+
+	weights, unit, err := ArrayWithUnitOf[int64](property(t, "weights", `{"type": "array", "items": {"type": "Long", "unit": "kg"}}`, `[3, 5, 7]`))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 5, 7}, weights)
+	assert.Equal(t, "kg", unit)
+}
+
+func TestArrayOf(t *testing.T) {
+	synonyms, err := ArrayOf[string](property(t, "synonyms", `{"type": "array", "items": {"type": "String", "format": null}}`, `["thingamabob", "whosit"]`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"thingamabob", "whosit"}, synonyms)
+
+	doubles, err := ArrayOf[float64](property(t, "scores", `{"type": "array", "items": {"type": "Double"}}`, `[1, 2.5]`))
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1, 2.5}, doubles)
+
+	dates, err := ArrayOf[time.Time](property(t, "visits", `{"type": "array", "items": {"type": "Date"}}`, `["2024-09-26", "2024-09-27T10:00:00"]`))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{time.Date(2024, 9, 26, 0, 0, 0, 0, time.UTC), time.Date(2024, 9, 27, 10, 0, 0, 0, time.UTC)}, dates)
+
+	empty, err := ArrayOf[bool](property(t, "flags", `{"type": "array", "items": {"type": "Boolean"}}`, `[]`))
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+	assert.NotNil(t, empty)
+}
+
+func TestProperty_NullValues(t *testing.T) {
+	for dataType, accessor := range map[string]func(p Property) error{
+		`"String"`:  func(p Property) error { _, err := p.StringValue(); return err },
+		`"Long"`:    func(p Property) error { _, err := p.LongValue(); return err },
+		`"Double"`:  func(p Property) error { _, err := p.DoubleValue(); return err },
+		`"Boolean"`: func(p Property) error { _, err := p.BoolValue(); return err },
+		`"Date"`:    func(p Property) error { _, err := p.DateValue(); return err },
+		`{"type": "array", "items": {"type": "Long"}}`: func(p Property) error { _, err := ArrayOf[int64](p); return err },
+	} {
+		p := property(t, "empty", dataType, `null`)
+		assert.True(t, p.IsNull())
+		assert.ErrorIs(t, accessor(p), ErrNullValue, dataType)
+
+		// a property the record does not have
+		missing, hasProperty := Record{}.Property("missing")
+		assert.False(t, hasProperty)
+		assert.ErrorIs(t, accessor(missing), ErrNullValue, dataType)
+	}
+
+	// ArrayValue keeps returning a typed nil slice for null values
+	longs, err := property(t, "weights", `{"type": "array", "items": {"type": "Long"}}`, `null`).ArrayValue()
+	require.NoError(t, err)
+	assert.Equal(t, []int64(nil), longs)
+}
+
+func TestProperty_TypeMismatch(t *testing.T) {
+	for scenario, tt := range map[string]struct {
+		err      error
+		expected string
+	}{
+		"wrong data type": {
+			err:      func() error { _, err := property(t, "name", `"String"`, `"stone"`).LongValue(); return err }(),
+			expected: `cannot read property name with data type "String" as Long`,
+		},
+		"fractional Long": {
+			err:      func() error { _, err := property(t, "id", `"Long"`, `1.5`).LongValue(); return err }(),
+			expected: `cannot read property id with data type "Long" as Long: 1.5 is not an integer`,
+		},
+		"bad Date": {
+			err:      func() error { _, err := property(t, "birthday", `"Date"`, `"soon"`).DateValue(); return err }(),
+			expected: `cannot parse "soon" as a date`,
+		},
+		"bad Boolean": {
+			err:      func() error { _, err := property(t, "is_solid", `"Boolean"`, `"maybe"`).BoolValue(); return err }(),
+			expected: `cannot read property is_solid with data type "Boolean" as Boolean`,
+		},
+		"array as scalar": {
+			err: func() error {
+				_, err := property(t, "weights", `{"type": "array", "items": {"type": "Long"}}`, `[1]`).LongValue()
+				return err
+			}(),
+			expected: "as Long",
+		},
+		"scalar as array": {
+			err:      func() error { _, err := ArrayOf[int64](property(t, "id", `"Long"`, `1`)); return err }(),
+			expected: "as array of int64",
+		},
+		"wrong item type": {
+			err: func() error {
+				_, err := ArrayOf[string](property(t, "weights", `{"type": "array", "items": {"type": "Long"}}`, `[1]`))
+				return err
+			}(),
+			expected: "as array of string",
+		},
+		"wrong value type": {
+			err:      func() error { _, err := property(t, "name", `"String"`, `12`).StringValue(); return err }(),
+			expected: "cannot use 12 (float64) as String",
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			var mismatch *TypeMismatchError
+			if assert.ErrorAs(t, tt.err, &mismatch) {
+				assert.ErrorContains(t, tt.err, tt.expected)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package instance
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
 )
 
@@ -26,10 +27,66 @@ type ProxyPackageContent struct {
 	UpdatedAt     time.Time `json:"updatedAt"`
 }
 
-// ProxyPackage should also have "children" and "properties" slices, but we don't
-// need them for now
+// ProxyPackage is a package linked to a record, as returned by the Pennsieve files endpoint for the record
 type ProxyPackage struct {
 	Content ProxyPackageContent `json:"content"`
+	// Children are the packages in this package if it is a Collection (folder)
+	Children   []ProxyPackage         `json:"children"`
+	Properties []PackagePropertyGroup `json:"properties"`
+	// Storage is the total size in bytes of the package's files, if Pennsieve reported it
+	Storage   *int64  `json:"storage,omitempty"`
+	Extension *string `json:"extension,omitempty"`
+	// Objects are the package's files, if Pennsieve reported them
+	Objects *PackageObjects `json:"objects,omitempty"`
+	// Ancestors are the collections containing the package, from the top level of the dataset down to the package's
+	// parent. Only present if the pre-processor was configured to add them. Empty for a package at the top level.
+	Ancestors []ProxyPackage `json:"ancestors,omitempty"`
+}
+
+// DatasetPath returns the path of the package within the dataset, for example "folder/subfolder/file.txt".
+// The path is only complete if the pre-processor added the package's Ancestors.
+func (p ProxyPackage) DatasetPath() string {
+	var path []string
+	for _, ancestor := range p.Ancestors {
+		path = append(path, ancestor.Content.Name)
+	}
+	return strings.Join(append(path, p.Content.Name), "/")
+}
+
+// PackagePropertyGroup is the package properties in one category
+type PackagePropertyGroup struct {
+	Category   string            `json:"category"`
+	Properties []PackageProperty `json:"properties"`
+}
+
+type PackageProperty struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	DataType string `json:"dataType"`
+	Display  string `json:"display,omitempty"`
+	Fixed    bool   `json:"fixed"`
+	Hidden   bool   `json:"hidden"`
+}
+
+// PackageObjects are the files of a package, grouped by type
+type PackageObjects struct {
+	Source []PackageFile `json:"source,omitempty"`
+	File   []PackageFile `json:"file,omitempty"`
+	View   []PackageFile `json:"view,omitempty"`
+}
+
+type PackageFile struct {
+	Content PackageFileContent `json:"content"`
+}
+
+type PackageFileContent struct {
+	ID         int64     `json:"id"`
+	PackageID  string    `json:"packageId"`
+	Name       string    `json:"name"`
+	FileType   string    `json:"fileType"`
+	ObjectType string    `json:"objectType"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
 }
 
 // RawFromFile represents the structure of proxy instances as they appear in the downloaded files.
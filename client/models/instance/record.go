@@ -11,3 +11,14 @@ type Record struct {
 	UpdatedBy string     `json:"updatedBy"`
 	Values    []Property `json:"values"`
 }
+
+// Property returns the record's value for the named property. If the record has no such property, the returned
+// Property has only its Name set, and its typed accessors return an error wrapping ErrNullValue.
+func (r Record) Property(name string) (property Property, hasProperty bool) {
+	for _, p := range r.Values {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{Name: name}, false
+}
@@ -0,0 +1,41 @@
+package run
+
+import "time"
+
+// Changes represents the contents of metadata/changes.json, which a run compared with a previous snapshot writes to
+// summarize how the metadata differs from that snapshot
+type Changes struct {
+	IntegrationID string `json:"integrationId"`
+	// DatasetID is the dataset node id, for example N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5
+	DatasetID string `json:"datasetId"`
+	// Previous is the completion marker of the snapshot compared with. It is nil if there was no usable snapshot, in
+	// which case everything is reported as added.
+	Previous    *Marker   `json:"previous"`
+	StartedAt   time.Time `json:"startedAt"`
+	CompletedAt time.Time `json:"completedAt"`
+	// Models, Relationships and LinkedProperties are the ids of the schema elements added, changed or deleted. A model
+	// is changed if any of its properties changed.
+	Models           ElementChanges `json:"models"`
+	Relationships    ElementChanges `json:"relationships"`
+	LinkedProperties ElementChanges `json:"linkedProperties"`
+	// Records are the ids of the records added, changed or deleted, by model id
+	Records map[string]ElementChanges `json:"records"`
+	// RelationshipInstances and LinkedPropertyInstances are the ids of the instances added, changed or deleted, by
+	// schema relationship or schema linked property id
+	RelationshipInstances   map[string]ElementChanges `json:"relationshipInstances"`
+	LinkedPropertyInstances map[string]ElementChanges `json:"linkedPropertyInstances"`
+	// Proxies are the ids of the records whose package proxies were added, changed or deleted, by model id
+	Proxies map[string]ElementChanges `json:"proxies"`
+}
+
+// ElementChanges are the ids of the elements of one kind that were added, changed, or deleted, each sorted
+type ElementChanges struct {
+	Added   []string `json:"added,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// IsEmpty returns true if no element was added, changed or deleted
+func (c ElementChanges) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Deleted) == 0
+}
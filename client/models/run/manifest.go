@@ -0,0 +1,159 @@
+package run
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Manifest represents the contents of metadata/manifest.json, which describes every file of the metadata directory
+// as it was when the pre-processor finished
+type Manifest struct {
+	IntegrationID string `json:"integrationId"`
+	// DatasetID is the dataset node id, for example N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5
+	DatasetID string `json:"datasetId"`
+	APIHost   string `json:"apiHost"`
+	API2Host  string `json:"api2Host"`
+	// ProcessorVersion is the version of the pre-processor that wrote the files
+	ProcessorVersion string         `json:"processorVersion"`
+	StartedAt        time.Time      `json:"startedAt"`
+	CompletedAt      time.Time      `json:"completedAt"`
+	Files            []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes one file of the metadata directory
+type ManifestFile struct {
+	// Path is relative to the metadata directory, with forward slashes, for example instances/records/<model-id>.json
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex encoded SHA-256 checksum of the file's contents
+	SHA256 string `json:"sha256"`
+	// Count is the number of elements if the file holds a JSON array, and 1 otherwise
+	Count int `json:"count"`
+}
+
+// DescribeFile reads the file at relativePath in metadataDirectory and returns its ManifestFile. Returns an error if
+// the file is not valid JSON.
+func DescribeFile(metadataDirectory string, relativePath string) (ManifestFile, error) {
+	filePath := filepath.Join(metadataDirectory, relativePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("error opening file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	counted := &countingWriter{}
+	tee := io.TeeReader(file, io.MultiWriter(hash, counted))
+	count, err := countElements(json.NewDecoder(tee))
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("error decoding file %s: %w", filePath, err)
+	}
+	// the decoder may stop reading before the end of the file
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return ManifestFile{}, fmt.Errorf("error reading file %s: %w", filePath, err)
+	}
+	return ManifestFile{
+		Path:   filepath.ToSlash(relativePath),
+		Size:   counted.written,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+		Count:  count,
+	}, nil
+}
+
+// DescribeDirectory returns a ManifestFile for every file in metadataDirectory, sorted by path. The completion
+// marker, the checkpoint journal, the manifest itself, and hidden files, such as the temporary files of writes in
+// progress, are left out.
+func DescribeDirectory(metadataDirectory string) ([]ManifestFile, error) {
+	var files []ManifestFile
+	err := filepath.WalkDir(metadataDirectory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		relativePath, err := filepath.Rel(metadataDirectory, path)
+		if err != nil {
+			return err
+		}
+		switch relativePath {
+		case paths.CompleteMarkerFilePath, paths.CheckpointFilePath, paths.ManifestFilePath:
+			return nil
+		}
+		file, err := DescribeFile(metadataDirectory, relativePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing metadata directory %s: %w", metadataDirectory, err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// countElements reads one JSON value from decoder and returns its number of elements if it is an array, or 1 otherwise
+func countElements(decoder *json.Decoder) (int, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, err
+	}
+	if token != json.Delim('[') {
+		return 1, skipRest(decoder, token)
+	}
+	count := 0
+	for decoder.More() {
+		var element json.RawMessage
+		if err := decoder.Decode(&element); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if _, err := decoder.Token(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// skipRest reads the rest of the value that started with first, so that it is checked to be valid JSON
+func skipRest(decoder *json.Decoder, first json.Token) error {
+	depth := 0
+	for token := first; ; {
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+		var err error
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+	}
+}
+
+type countingWriter struct {
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,61 @@
+package run
+
+import (
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDescribeFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"array.json":  `[{"id": 1, "values": [1, 2]}, {"id": 2}, "three"]`,
+		"empty.json":  `[]`,
+		"object.json": `{"name": "dataset", "tags": ["a", "b"]}`,
+		"broken.json": `[{"id": 1}, {"id":`,
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	array, err := DescribeFile(dir, "array.json")
+	require.NoError(t, err)
+	assert.Equal(t, 3, array.Count)
+	assert.Equal(t, int64(49), array.Size)
+	assert.Len(t, array.SHA256, 64)
+
+	empty, err := DescribeFile(dir, "empty.json")
+	require.NoError(t, err)
+	assert.Equal(t, ManifestFile{
+		Path:   "empty.json",
+		Size:   2,
+		SHA256: "4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945",
+		Count:  0,
+	}, empty)
+
+	object, err := DescribeFile(dir, "object.json")
+	require.NoError(t, err)
+	assert.Equal(t, 1, object.Count)
+
+	_, err = DescribeFile(dir, "broken.json")
+	assert.Error(t, err)
+}
+
+func TestDescribeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	recordsPath := paths.RecordsFilePath("model-id")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(recordsPath)), 0755))
+	for _, name := range []string{recordsPath, paths.DatasetFilePath, paths.CompleteMarkerFilePath, paths.CheckpointFilePath, paths.ManifestFilePath, ".dataset.json.123.tmp"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(`[]`), 0644))
+	}
+
+	files, err := DescribeDirectory(dir)
+	require.NoError(t, err)
+	var described []string
+	for _, file := range files {
+		described = append(described, file.Path)
+	}
+	assert.Equal(t, []string{"dataset.json", "instances/records/model-id.json"}, described)
+}
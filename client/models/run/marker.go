@@ -0,0 +1,21 @@
+package run
+
+import "time"
+
+// Marker represents the contents of metadata/_COMPLETE, which the pre-processor writes only once every other file in
+// the metadata directory has been written
+type Marker struct {
+	IntegrationID string `json:"integrationId"`
+	// DatasetID is the dataset node id, for example N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5
+	DatasetID string `json:"datasetId"`
+	// Scope is "dataset" for a full export, or "packages" for an export scoped to the integration's packages
+	Scope string `json:"scope"`
+	// Models are the names of the exported models, or empty if all models were exported
+	Models []string `json:"models,omitempty"`
+	// FetchProxies is whether the package proxies of records were exported, and ProxyAncestors whether each proxy
+	// package was written with the collections containing it
+	FetchProxies   bool      `json:"fetchProxies"`
+	ProxyAncestors bool      `json:"proxyAncestors"`
+	StartedAt      time.Time `json:"startedAt"`
+	CompletedAt    time.Time `json:"completedAt"`
+}
@@ -4,4 +4,6 @@ type Elements struct {
 	Models           []Model
 	Relationships    []Relationship
 	LinkedProperties []LinkedProperty
+	// Proxy is the special package proxy relationship, or nil if the dataset has none
+	Proxy *NullableRelationship
 }
@@ -1,13 +1,32 @@
 package schema
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"time"
+)
 
+// Property is the definition of a model property, as found in schema/properties/<model-id>.json
 type Property struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	DisplayName string `json:"displayName"`
+	Description string `json:"description"`
 	// DataType may be a string or may be a JSON object
 	DataType json.RawMessage `json:"dataType"`
 	Required bool            `json:"required"`
 	Index    int             `json:"index"`
+	// ConceptTitle is true for the property whose value is used as the title of a record
+	ConceptTitle bool `json:"conceptTitle"`
+	Default      bool `json:"default"`
+	// DefaultValue is the value given to the property of a new record, or nil if there is none
+	DefaultValue any       `json:"defaultValue"`
+	Locked       bool      `json:"locked"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// DecodeDataType returns the datatypes.DataType of the property
+func (p Property) DecodeDataType() (datatypes.DataType, error) {
+	return datatypes.Decode(p.DataType)
 }
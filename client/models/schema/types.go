@@ -31,6 +31,10 @@ func (e Element) IsModel() bool {
 	return e.isType(string(ModelType))
 }
 
+func (e Element) IsRelationship() bool {
+	return e.isType(string(RelationshipType))
+}
+
 func (e Element) IsLinkedProperty() bool {
 	return e.isType(string(LinkedPropertyType))
 }
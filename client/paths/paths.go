@@ -7,6 +7,10 @@ import (
 
 // layout relative to input directory:
 // metadata/
+// ├── _COMPLETE
+// ├── manifest.json
+// ├── changes.json
+// ├── dataset.json
 // ├── schema/
 // │   ├── graphSchema.json
 // │   ├── relationships.json
@@ -31,6 +35,26 @@ import (
 // MetadataDirectory is the directory all metadata info will be placed in relative to the input directory
 const MetadataDirectory = "metadata"
 
+// DatasetFilePath is the path to the dataset json file relative to the metadata directory. It describes the dataset itself:
+// name, description, tags, license, contributors, owner, and status.
+const DatasetFilePath = "dataset.json"
+
+// CompleteMarkerFilePath is the path to the completion marker relative to the metadata directory. It is written
+// last, once every other file of a run has been written.
+const CompleteMarkerFilePath = "_COMPLETE"
+
+// ManifestFilePath is the path to the manifest relative to the metadata directory. It lists every other file written
+// by a run with its size, checksum and element count.
+const ManifestFilePath = "manifest.json"
+
+// CheckpointFilePath is the path to the checkpoint journal relative to the metadata directory. It records the units of
+// work a run has completed, so that a failed run can be resumed, and is removed when the run completes.
+const CheckpointFilePath = "_CHECKPOINT"
+
+// ChangesFilePath is the path to the summary of changes relative to the metadata directory. Only an incremental run
+// writes it, listing what changed since the previous snapshot.
+const ChangesFilePath = "changes.json"
+
 // SchemaDirectory is the directory schema elements will be placed in relative to the metadata directory
 const SchemaDirectory = "schema"
 
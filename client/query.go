@@ -0,0 +1,506 @@
+package client
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Operator compares a record's property value with the value given to a Condition
+type Operator int
+
+const (
+	// Eq and the other comparisons never match a record whose property value is null. Their value must not be nil;
+	// use IsNull or IsNotNull instead.
+	Eq Operator = iota
+	Ne
+	Lt
+	Le
+	Gt
+	Ge
+	// Contains matches a String property containing the given substring, or an array property containing the given item
+	Contains
+	// ContainsAny matches an array property containing at least one of the items in the given slice
+	ContainsAny
+	// ContainsAll matches an array property containing every item in the given slice
+	ContainsAll
+	// IsNull matches a record whose property value is null or missing. The Condition value is ignored.
+	IsNull
+	// IsNotNull matches a record whose property value is not null. The Condition value is ignored.
+	IsNotNull
+)
+
+var operatorNames = map[Operator]string{
+	Eq:          "Eq",
+	Ne:          "Ne",
+	Lt:          "Lt",
+	Le:          "Le",
+	Gt:          "Gt",
+	Ge:          "Ge",
+	Contains:    "Contains",
+	ContainsAny: "ContainsAny",
+	ContainsAll: "ContainsAll",
+	IsNull:      "IsNull",
+	IsNotNull:   "IsNotNull",
+}
+
+func (o Operator) String() string {
+	if name, known := operatorNames[o]; known {
+		return name
+	}
+	return fmt.Sprintf("Operator(%d)", o)
+}
+
+type combinator int
+
+const (
+	noCombinator combinator = iota
+	andCombinator
+	orCombinator
+)
+
+// Condition is a predicate on the property values of a record. Build one with Cond, And, or Or.
+type Condition struct {
+	property string
+	op       Operator
+	value    any
+	// combinator is set for a combination of conditions, in which case the fields above are unused
+	combinator combinator
+	conditions []Condition
+}
+
+// Cond returns a Condition comparing the named property with value using op. The value is converted to the
+// property's data type when the query runs: Long accepts any integer, Double any number, Date a time.Time or a
+// date string, and Boolean a bool or "true"/"false". For ContainsAny and ContainsAll the value is a slice of items.
+func Cond(property string, op Operator, value any) Condition {
+	return Condition{property: property, op: op, value: value}
+}
+
+// And returns a Condition that matches if all the given conditions match. And() matches every record.
+func And(conditions ...Condition) Condition {
+	return Condition{combinator: andCombinator, conditions: conditions}
+}
+
+// Or returns a Condition that matches if any of the given conditions match. Or() matches no record.
+func Or(conditions ...Condition) Condition {
+	return Condition{combinator: orCombinator, conditions: conditions}
+}
+
+// predicate is a compiled Condition. values maps property name to the record's value.
+type predicate func(values map[string]any) bool
+
+type orderKey struct {
+	property   string
+	descending bool
+}
+
+// Query selects the records of a model. Build one with Reader.Query and chain Where, Filter, OrderBy, OrderByDesc,
+// Limit, and Offset before calling Records. Any problem with the query is returned by Records.
+type Query struct {
+	reader     *Reader
+	modelName  string
+	conditions []Condition
+	order      []orderKey
+	limit      int
+	offset     int
+}
+
+// Query returns a Query over the records of the given model
+func (r *Reader) Query(modelName string) *Query {
+	return &Query{reader: r, modelName: modelName, limit: -1}
+}
+
+// Where adds a Cond(property, op, value) condition. All conditions added to a Query must match.
+func (q *Query) Where(property string, op Operator, value any) *Query {
+	return q.Filter(Cond(property, op, value))
+}
+
+// Filter adds a condition. All conditions added to a Query must match.
+func (q *Query) Filter(condition Condition) *Query {
+	q.conditions = append(q.conditions, condition)
+	return q
+}
+
+// OrderBy sorts the records by the given property in ascending order, with null values last. Each additional
+// OrderBy or OrderByDesc breaks ties left by the previous ones. Records that are still tied keep their file order.
+func (q *Query) OrderBy(property string) *Query {
+	q.order = append(q.order, orderKey{property: property})
+	return q
+}
+
+// OrderByDesc sorts the records by the given property in descending order, with null values last
+func (q *Query) OrderByDesc(property string) *Query {
+	q.order = append(q.order, orderKey{property: property, descending: true})
+	return q
+}
+
+// Limit returns at most n records. A negative n means no limit.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset skips the first n matching records
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+// Records reads the model's records and returns those matching the query
+func (q *Query) Records() ([]instance.Record, error) {
+	if _, isModel := q.reader.Schema.ModelByName(q.modelName); !isModel {
+		return nil, fmt.Errorf("model %s not found", q.modelName)
+	}
+	dataTypes, err := q.dataTypes()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := And(q.conditions...).compile(dataTypes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query on model %s: %w", q.modelName, err)
+	}
+	for _, key := range q.order {
+		dataType, isProperty := dataTypes[key.property]
+		if !isProperty {
+			return nil, fmt.Errorf("invalid query on model %s: cannot order by unknown property %s", q.modelName, key.property)
+		}
+		if dataType.IsArray() {
+			return nil, fmt.Errorf("invalid query on model %s: cannot order by array property %s", q.modelName, key.property)
+		}
+	}
+	if q.offset < 0 {
+		return nil, fmt.Errorf("invalid query on model %s: negative offset %d", q.modelName, q.offset)
+	}
+
+	records, err := q.reader.GetRecordsForModel(q.modelName)
+	if err != nil {
+		return nil, err
+	}
+	var selected []instance.Record
+	var selectedValues []map[string]any
+	for _, record := range records {
+		values := recordValues(record)
+		if matches(values) {
+			selected = append(selected, record)
+			selectedValues = append(selectedValues, values)
+		}
+	}
+	if len(q.order) > 0 {
+		q.sort(selected, selectedValues, dataTypes)
+	}
+	if q.offset >= len(selected) {
+		return []instance.Record{}, nil
+	}
+	selected = selected[q.offset:]
+	if q.limit >= 0 && q.limit < len(selected) {
+		selected = selected[:q.limit]
+	}
+	return selected, nil
+}
+
+// dataTypes maps each property of the query's model to its decoded data type
+func (q *Query) dataTypes() (map[string]datatypes.DataType, error) {
+	properties := q.reader.Schema.PropertiesForModel(q.modelName)
+	dataTypes := make(map[string]datatypes.DataType, len(properties))
+	for _, property := range properties {
+		dataType, err := property.DecodeDataType()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding data type of %s.%s: %w", q.modelName, property.Name, err)
+		}
+		dataTypes[property.Name] = dataType
+	}
+	return dataTypes, nil
+}
+
+func recordValues(record instance.Record) map[string]any {
+	values := make(map[string]any, len(record.Values))
+	for _, value := range record.Values {
+		values[value.Name] = value.Value
+	}
+	return values
+}
+
+// sort sorts records and their values together, by the query's order keys
+func (q *Query) sort(records []instance.Record, values []map[string]any, dataTypes map[string]datatypes.DataType) {
+	indices := make([]int, len(records))
+	for i := range indices {
+		indices[i] = i
+	}
+	// keys[k][i] is the normalized value of order key k for record i, or nil if null or not convertible
+	keys := make([][]any, len(q.order))
+	for k, key := range q.order {
+		simpleType := dataTypes[key.property].BaseType()
+		keys[k] = make([]any, len(records))
+		for i := range records {
+			if normalized, err := datatypes.Normalize(simpleType, values[i][key.property]); err == nil {
+				keys[k][i] = normalized
+			}
+		}
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		for k, key := range q.order {
+			left, right := keys[k][indices[a]], keys[k][indices[b]]
+			if left == nil || right == nil {
+				if (left == nil) != (right == nil) {
+					// nulls last whatever the direction
+					return right == nil
+				}
+				continue
+			}
+			if c, err := compareScalars(left, right); err == nil && c != 0 {
+				if key.descending {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return false
+	})
+	sortedRecords := make([]instance.Record, len(records))
+	sortedValues := make([]map[string]any, len(records))
+	for i, idx := range indices {
+		sortedRecords[i] = records[idx]
+		sortedValues[i] = values[idx]
+	}
+	copy(records, sortedRecords)
+	copy(values, sortedValues)
+}
+
+// compile checks the condition against the model's property data types and returns it as a predicate
+func (c Condition) compile(dataTypes map[string]datatypes.DataType) (predicate, error) {
+	if c.combinator != noCombinator {
+		predicates := make([]predicate, len(c.conditions))
+		var errs []error
+		for i, condition := range c.conditions {
+			p, err := condition.compile(dataTypes)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			predicates[i] = p
+		}
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		if c.combinator == orCombinator {
+			return func(values map[string]any) bool {
+				for _, p := range predicates {
+					if p(values) {
+						return true
+					}
+				}
+				return false
+			}, nil
+		}
+		return func(values map[string]any) bool {
+			for _, p := range predicates {
+				if !p(values) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+
+	dataType, isProperty := dataTypes[c.property]
+	if !isProperty {
+		return nil, fmt.Errorf("unknown property %s", c.property)
+	}
+	property := c.property
+	switch c.op {
+	case IsNull:
+		return func(values map[string]any) bool { return values[property] == nil }, nil
+	case IsNotNull:
+		return func(values map[string]any) bool { return values[property] != nil }, nil
+	}
+	// formats, units and allowed values do not matter for comparisons
+	if dataType.IsArray() {
+		return c.compileArray(dataType.BaseType())
+	}
+	return c.compileScalar(dataType.BaseType())
+}
+
+func (c Condition) compileScalar(simpleType datatypes.SimpleType) (predicate, error) {
+	switch c.op {
+	case Eq, Ne, Lt, Le, Gt, Ge, Contains:
+	default:
+		return nil, fmt.Errorf("operator %s is not supported for %s property %s", c.op, simpleType, c.property)
+	}
+	if c.op == Contains && simpleType != datatypes.StringType {
+		return nil, fmt.Errorf("operator %s is not supported for %s property %s", c.op, simpleType, c.property)
+	}
+	if simpleType == datatypes.BooleanType && c.op != Eq && c.op != Ne {
+		return nil, fmt.Errorf("operator %s is not supported for %s property %s", c.op, simpleType, c.property)
+	}
+	want, err := datatypes.Normalize(simpleType, c.value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s property %s: %w", simpleType, c.property, err)
+	}
+	if want == nil {
+		return nil, fmt.Errorf("nil value for %s property %s; use IsNull or IsNotNull to match null values", simpleType, c.property)
+	}
+	property, op := c.property, c.op
+	return func(values map[string]any) bool {
+		got, err := datatypes.Normalize(simpleType, values[property])
+		if err != nil || got == nil {
+			return false
+		}
+		if op == Contains {
+			gotString, isString := got.(string)
+			return isString && strings.Contains(gotString, want.(string))
+		}
+		comparison, err := compareScalars(got, want)
+		return err == nil && compareMatches(op, comparison)
+	}, nil
+}
+
+func compareMatches(op Operator, comparison int) bool {
+	switch op {
+	case Eq:
+		return comparison == 0
+	case Ne:
+		return comparison != 0
+	case Lt:
+		return comparison < 0
+	case Le:
+		return comparison <= 0
+	case Gt:
+		return comparison > 0
+	case Ge:
+		return comparison >= 0
+	default:
+		return false
+	}
+}
+
+func (c Condition) compileArray(itemType datatypes.SimpleType) (predicate, error) {
+	var wantItems []any
+	switch c.op {
+	case Contains:
+		item, err := datatypes.Normalize(itemType, c.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item for array of %s property %s: %w", itemType, c.property, err)
+		}
+		wantItems = []any{item}
+	case ContainsAny, ContainsAll:
+		items, err := normalizeArray(itemType, c.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid items for array of %s property %s: %w", itemType, c.property, err)
+		}
+		wantItems = items
+		if wantItems == nil {
+			return nil, fmt.Errorf("nil items for array of %s property %s", itemType, c.property)
+		}
+	default:
+		return nil, fmt.Errorf("operator %s is not supported for array of %s property %s", c.op, itemType, c.property)
+	}
+	for i, want := range wantItems {
+		if want == nil {
+			return nil, fmt.Errorf("nil item %d for array of %s property %s; null items cannot be matched", i, itemType, c.property)
+		}
+	}
+	property := c.property
+	all := c.op != ContainsAny
+	return func(values map[string]any) bool {
+		gotItems, err := normalizeArray(itemType, values[property])
+		if err != nil || gotItems == nil {
+			return false
+		}
+		for _, want := range wantItems {
+			found := containsItem(gotItems, want)
+			if found && !all {
+				return true
+			}
+			if !found && all {
+				return false
+			}
+		}
+		return all
+	}, nil
+}
+
+func containsItem(items []any, want any) bool {
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		if comparison, err := compareScalars(item, want); err == nil && comparison == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeArray converts each item of a slice value with datatypes.Normalize. A nil value returns nil.
+func normalizeArray(itemType datatypes.SimpleType, value any) ([]any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	slice := reflect.ValueOf(value)
+	if slice.Kind() != reflect.Slice && slice.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a slice; got %T", value)
+	}
+	items := make([]any, slice.Len())
+	for i := range items {
+		item, err := datatypes.Normalize(itemType, slice.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// compareScalars compares two values of the same type as returned by datatypes.Normalize. It returns an error if
+// they are not of the same type, or not of a type Normalize returns.
+func compareScalars(a, b any) (int, error) {
+	switch left := a.(type) {
+	case string:
+		right, ok := b.(string)
+		if !ok {
+			return 0, mismatchError(a, b)
+		}
+		return strings.Compare(left, right), nil
+	case int64:
+		right, ok := b.(int64)
+		if !ok {
+			return 0, mismatchError(a, b)
+		}
+		return cmp.Compare(left, right), nil
+	case float64:
+		right, ok := b.(float64)
+		if !ok {
+			return 0, mismatchError(a, b)
+		}
+		return cmp.Compare(left, right), nil
+	case bool:
+		right, ok := b.(bool)
+		if !ok {
+			return 0, mismatchError(a, b)
+		}
+		if left == right {
+			return 0, nil
+		} else if !left {
+			return -1, nil
+		}
+		return 1, nil
+	case time.Time:
+		right, ok := b.(time.Time)
+		if !ok {
+			return 0, mismatchError(a, b)
+		}
+		return left.Compare(right), nil
+	default:
+		return 0, fmt.Errorf("unexpected normalized value type %T", a)
+	}
+}
+
+// mismatchError is only built on failure, since compareScalars runs for every comparison of a sort or filter
+func mismatchError(a, b any) error {
+	return fmt.Errorf("cannot compare %T with %T", a, b)
+}
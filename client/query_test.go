@@ -0,0 +1,124 @@
+package client
+
+import (
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// The object records in the testdata are
+//
+//	5b07e038: id 1, name "stone", everything else null
+//	bcf06e0c: id 2, name "book", everything else null
+//	a9b9d03b: id 57, name "whatsit", gpa 6.78, weights [3, 5, 7], synonyms ["thingamabob", "whosit", "doo-dad"],
+//	          birthday 2024-09-26T22:01:04, is_solid "true"
+func objectNames(t *testing.T, records []instance.Record) []string {
+	names := []string{}
+	for _, record := range records {
+		for _, value := range record.Values {
+			if value.Name == "name" {
+				names = append(names, value.Value.(string))
+			}
+		}
+	}
+	return names
+}
+
+func TestQuery(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	for scenario, tt := range map[string]struct {
+		query    *Query
+		expected []string
+	}{
+		"all":                 {reader.Query("object"), []string{"stone", "book", "whatsit"}},
+		"String Eq":           {reader.Query("object").Where("name", Eq, "book"), []string{"book"}},
+		"String Ne":           {reader.Query("object").Where("name", Ne, "book"), []string{"stone", "whatsit"}},
+		"String Contains":     {reader.Query("object").Where("name", Contains, "o"), []string{"stone", "book"}},
+		"Long Gt":             {reader.Query("object").Where("id", Gt, 1), []string{"book", "whatsit"}},
+		"Long Le int64":       {reader.Query("object").Where("id", Le, int64(2)), []string{"stone", "book"}},
+		"Long Eq float":       {reader.Query("object").Where("id", Eq, 57.0), []string{"whatsit"}},
+		"Double Lt":           {reader.Query("object").Where("gpa", Lt, 7), []string{"whatsit"}},
+		"Double Ge null":      {reader.Query("object").Where("gpa", Ge, 0.0), []string{"whatsit"}},
+		"Boolean from string": {reader.Query("object").Where("is_solid", Eq, true), []string{"whatsit"}},
+		"Date time.Time": {reader.Query("object").Where("birthday", Gt, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+			[]string{"whatsit"}},
+		"Date string":       {reader.Query("object").Where("birthday", Lt, "2024-09-27"), []string{"whatsit"}},
+		"array Contains":    {reader.Query("object").Where("synonyms", Contains, "whosit"), []string{"whatsit"}},
+		"array ContainsAny": {reader.Query("object").Where("weights", ContainsAny, []int{1, 2, 3}), []string{"whatsit"}},
+		"array ContainsAll": {reader.Query("object").Where("weights", ContainsAll, []int64{3, 7}), []string{"whatsit"}},
+		"array ContainsAll missing": {reader.Query("object").Where("weights", ContainsAll, []int{3, 4}),
+			[]string{}},
+		"IsNull":    {reader.Query("object").Where("gpa", IsNull, nil), []string{"stone", "book"}},
+		"IsNotNull": {reader.Query("object").Where("weights", IsNotNull, nil), []string{"whatsit"}},
+		"and": {reader.Query("object").Where("id", Lt, 50).Where("name", Ne, "stone"),
+			[]string{"book"}},
+		"or": {reader.Query("object").Filter(Or(Cond("name", Eq, "stone"), Cond("gpa", Gt, 5))),
+			[]string{"stone", "whatsit"}},
+		"nested": {reader.Query("object").Filter(And(Cond("id", Gt, 0), Or(Cond("name", Eq, "book"), Cond("name", Eq, "whatsit")))),
+			[]string{"book", "whatsit"}},
+		"empty or":          {reader.Query("object").Filter(Or()), []string{}},
+		"order by":          {reader.Query("object").OrderBy("name"), []string{"book", "stone", "whatsit"}},
+		"order by desc":     {reader.Query("object").OrderByDesc("id"), []string{"whatsit", "book", "stone"}},
+		"order nulls last":  {reader.Query("object").OrderByDesc("gpa").OrderBy("name"), []string{"whatsit", "book", "stone"}},
+		"limit":             {reader.Query("object").OrderBy("id").Limit(2), []string{"stone", "book"}},
+		"offset":            {reader.Query("object").OrderBy("id").Offset(1), []string{"book", "whatsit"}},
+		"limit and offset":  {reader.Query("object").OrderBy("id").Offset(1).Limit(1), []string{"book"}},
+		"offset past end":   {reader.Query("object").Offset(5), []string{}},
+		"other model":       {reader.Query("subject").Where("name", Eq, "Person A"), []string{"Person A"}},
+		"other model empty": {reader.Query("subject").Where("id", Gt, 1), []string{}},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			records, err := tt.query.Records()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, objectNames(t, records))
+		})
+	}
+}
+
+func TestQuery_Invalid(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	for scenario, tt := range map[string]struct {
+		query         *Query
+		expectedError string
+	}{
+		"unknown model":      {reader.Query("missing"), "model missing not found"},
+		"unknown property":   {reader.Query("object").Where("age", Gt, 30), "unknown property age"},
+		"wrong value type":   {reader.Query("object").Where("id", Eq, "one"), "invalid value for Long property id"},
+		"fractional long":    {reader.Query("object").Where("id", Eq, 1.5), "1.5 is not an integer"},
+		"bad date":           {reader.Query("object").Where("birthday", Eq, "yesterday"), `cannot parse "yesterday" as a date`},
+		"boolean ordering":   {reader.Query("object").Where("is_solid", Gt, false), "operator Gt is not supported for Boolean property is_solid"},
+		"array comparison":   {reader.Query("object").Where("weights", Eq, 3), "operator Eq is not supported for array of Long property weights"},
+		"ContainsAny scalar": {reader.Query("object").Where("weights", ContainsAny, 3), "expected a slice"},
+		"Contains on Long":   {reader.Query("object").Where("id", Contains, 3), "operator Contains is not supported for Long property id"},
+		"nested error":       {reader.Query("object").Filter(Or(Cond("name", Eq, "a"), Cond("age", Eq, 1))), "unknown property age"},
+		"order by unknown":   {reader.Query("object").OrderBy("age"), "cannot order by unknown property age"},
+		"order by array":     {reader.Query("object").OrderBy("weights"), "cannot order by array property weights"},
+		"negative offset":    {reader.Query("object").Offset(-1), "negative offset -1"},
+		"Eq nil":             {reader.Query("object").Where("name", Eq, nil), "nil value for String property name; use IsNull"},
+		"Contains nil":       {reader.Query("object").Where("name", Contains, nil), "nil value for String property name"},
+		"array Contains nil": {reader.Query("object").Where("weights", Contains, nil), "nil item 0 for array of Long property weights"},
+		"ContainsAll nil":    {reader.Query("object").Where("weights", ContainsAll, []any{1, nil}), "nil item 1 for array of Long property weights"},
+		"ContainsAny nil":    {reader.Query("object").Where("weights", ContainsAny, nil), "nil items for array of Long property weights"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := tt.query.Records()
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestCompareScalars_Mismatch(t *testing.T) {
+	_, err := compareScalars("a", int64(1))
+	assert.ErrorContains(t, err, "cannot compare string with int64")
+	_, err = compareScalars([]any{}, []any{})
+	assert.ErrorContains(t, err, "unexpected normalized value type []interface {}")
+	comparison, err := compareScalars(int64(1), int64(2))
+	require.NoError(t, err)
+	assert.Equal(t, -1, comparison)
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/dataset"
 	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
 	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
 	"github.com/pennsieve/processor-pre-metadata/client/paths"
 	"os"
@@ -13,18 +15,55 @@ import (
 	"strings"
 )
 
+// ErrIncomplete is returned, wrapped, by NewReader when the metadata directory has no completion marker, meaning the
+// pre-processor run that wrote it has not finished or was interrupted
+var ErrIncomplete = errors.New("metadata directory is incomplete")
+
 // A Reader can be used to read the metadata records once they have been downloaded by the pre-processor
 type Reader struct {
 	MetadataDirectory string
 	Schema            *Schema
+	// Marker describes the run that wrote the metadata directory. It is nil if the directory was read with
+	// AllowIncomplete and has no completion marker.
+	Marker *run.Marker
+}
+
+type readerOptions struct {
+	allowIncomplete bool
+}
+
+// ReaderOption configures NewReader
+type ReaderOption func(options *readerOptions)
+
+// AllowIncomplete lets NewReader read a metadata directory without a completion marker, such as one written by an
+// older pre-processor. Files in such a directory may be missing.
+func AllowIncomplete() ReaderOption {
+	return func(options *readerOptions) {
+		options.allowIncomplete = true
+	}
 }
 
 // NewReader returns a pointer to a new Reader instance. The rootDirectory argument should be
-// the parent directory of the metadata directory.
-func NewReader(rootDirectory string) (*Reader, error) {
+// the parent directory of the metadata directory. Unless AllowIncomplete is given, an error wrapping ErrIncomplete is
+// returned if the metadata directory has no completion marker. A model without a properties file was not exported and
+// is left out of the Schema, together with the relationships and linked properties from or to it.
+func NewReader(rootDirectory string, options ...ReaderOption) (*Reader, error) {
+	var readerOpts readerOptions
+	for _, option := range options {
+		option(&readerOpts)
+	}
 	reader := Reader{
 		MetadataDirectory: filepath.Join(rootDirectory, paths.MetadataDirectory),
 	}
+	markerFilePath := filepath.Join(reader.MetadataDirectory, paths.CompleteMarkerFilePath)
+	var marker run.Marker
+	if err := readJsonFile(markerFilePath, &marker); err == nil {
+		reader.Marker = &marker
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	} else if !readerOpts.allowIncomplete {
+		return nil, fmt.Errorf("%w: %s not found", ErrIncomplete, markerFilePath)
+	}
 	var proxy *schema.NullableRelationship
 	relationshipsFilePath := filepath.Join(reader.MetadataDirectory, paths.RelationshipSchemasFilePath)
 	var relationships []schema.NullableRelationship
@@ -36,13 +75,109 @@ func NewReader(rootDirectory string) (*Reader, error) {
 		proxy = &relationships[proxyIndex]
 	}
 	schemaFilePath := filepath.Join(reader.MetadataDirectory, paths.SchemaFilePath)
-	var elements []schema.Element
-	if err := readJsonFile(schemaFilePath, &elements); err != nil {
+	var rawElements []json.RawMessage
+	if err := readJsonFile(schemaFilePath, &rawElements); err != nil {
 		return nil, err
 	}
-	reader.Schema = NewSchema(elements, proxy)
+	elements := make([]schema.Element, len(rawElements))
+	var schemaRelationships []schema.Relationship
+	var schemaLinkedProperties []schema.LinkedProperty
+	for i, rawElement := range rawElements {
+		if err := json.Unmarshal(rawElement, &elements[i]); err != nil {
+			return nil, fmt.Errorf("error decoding schema element %s from file %s: %w", rawElement, schemaFilePath, err)
+		}
+		if elements[i].IsRelationship() {
+			var relationship schema.Relationship
+			if err := json.Unmarshal(rawElement, &relationship); err != nil {
+				return nil, fmt.Errorf("error decoding relationship %s from file %s: %w", rawElement, schemaFilePath, err)
+			}
+			schemaRelationships = append(schemaRelationships, relationship)
+		} else if elements[i].IsLinkedProperty() {
+			var linkedProperty schema.LinkedProperty
+			if err := json.Unmarshal(rawElement, &linkedProperty); err != nil {
+				return nil, fmt.Errorf("error decoding linked property %s from file %s: %w", rawElement, schemaFilePath, err)
+			}
+			schemaLinkedProperties = append(schemaLinkedProperties, linkedProperty)
+		}
+	}
+	// a model without a properties file was not exported, because it was not selected or was deleted during the run,
+	// so it is left out of the schema along with the relationships and linked properties from or to it
+	properties := make(map[string][]schema.Property)
+	for _, element := range elements {
+		if element.IsModel() {
+			modelProperties, err := reader.readPropertiesFile(element)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			properties[element.ID] = modelProperties
+		}
+	}
+	exported := func(from, to string) bool {
+		_, fromExported := properties[from]
+		_, toExported := properties[to]
+		return fromExported && toExported
+	}
+	unexportedIDs := make(map[string]bool)
+	schemaRelationships = slices.DeleteFunc(schemaRelationships, func(r schema.Relationship) bool {
+		unexportedIDs[r.ID] = !exported(r.From, r.To)
+		return unexportedIDs[r.ID]
+	})
+	schemaLinkedProperties = slices.DeleteFunc(schemaLinkedProperties, func(l schema.LinkedProperty) bool {
+		unexportedIDs[l.ID] = !exported(l.From, l.To)
+		return unexportedIDs[l.ID]
+	})
+	elements = slices.DeleteFunc(elements, func(e schema.Element) bool {
+		if e.IsModel() {
+			_, isExported := properties[e.ID]
+			return !isExported
+		}
+		return unexportedIDs[e.ID]
+	})
+	reader.Schema = newSchema(elements, schemaRelationships, schemaLinkedProperties, properties, proxy)
 	return &reader, nil
 }
+
+// GetDataset returns the dataset info in metadata/dataset.json
+func (r *Reader) GetDataset() (dataset.Dataset, error) {
+	datasetFilePath := filepath.Join(r.MetadataDirectory, paths.DatasetFilePath)
+	var ds dataset.Dataset
+	if err := readJsonFile(datasetFilePath, &ds); err != nil {
+		return dataset.Dataset{}, err
+	}
+	return ds, nil
+}
+
+// GetChanges returns the summary of changes in metadata/changes.json. Only runs compared with a previous snapshot
+// write it, so for other runs the returned error wraps os.ErrNotExist.
+func (r *Reader) GetChanges() (run.Changes, error) {
+	changesFilePath := filepath.Join(r.MetadataDirectory, paths.ChangesFilePath)
+	var changes run.Changes
+	if err := readJsonFile(changesFilePath, &changes); err != nil {
+		return run.Changes{}, err
+	}
+	return changes, nil
+}
+
+// GetPropertiesForModel returns the property definitions of the given model, in the order of its properties file.
+// The properties files are read by NewReader, so this does not touch the file system.
+func (r *Reader) GetPropertiesForModel(modelName string) ([]schema.Property, error) {
+	if _, isModel := r.Schema.ModelByName(modelName); !isModel {
+		return nil, fmt.Errorf("model %s not found", modelName)
+	}
+	return slices.Clone(r.Schema.PropertiesForModel(modelName)), nil
+}
+
+func (r *Reader) readPropertiesFile(model schema.Element) ([]schema.Property, error) {
+	propertiesFilePath := filepath.Join(r.MetadataDirectory, paths.PropertiesFilePath(model.ID))
+	var properties []schema.Property
+	if err := readJsonFile(propertiesFilePath, &properties); err != nil {
+		return nil, fmt.Errorf("error reading properties of model %s: %w", model.Name, err)
+	}
+	return properties, nil
+}
+
 func (r *Reader) GetRecordsForModel(modelName string) ([]instance.Record, error) {
 	modelElement, isModel := r.Schema.ModelByName(modelName)
 	if !isModel {
@@ -163,6 +298,36 @@ func (r *Reader) GetLinkInstancesForProperty(linkedPropertyName string) ([]insta
 
 }
 
+// GetRelationshipInstances returns the instances of the given schema relationship. If the pre-processor wrote no
+// instances file for the relationship, the result is empty.
+func (r *Reader) GetRelationshipInstances(relationshipName string) ([]instance.Relationship, error) {
+	relationshipElement, isRelationship := r.Schema.RelationshipByName(relationshipName)
+	if !isRelationship {
+		return nil, fmt.Errorf("relationship %s not found", relationshipName)
+	}
+	relationshipsFilePath := filepath.Join(r.MetadataDirectory, paths.RelationshipInstancesFilePath(relationshipElement.ID))
+	relationshipsFile, err := os.Open(relationshipsFilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error opening relationship instance file %s for %s: %w",
+			relationshipsFilePath,
+			relationshipName,
+			err)
+	}
+	defer relationshipsFile.Close()
+
+	var relationships []instance.Relationship
+	if err := json.NewDecoder(relationshipsFile).Decode(&relationships); err != nil {
+		return nil, fmt.Errorf("error decoding relationship instance file %s for %s: %w",
+			relationshipsFilePath,
+			relationshipName,
+			err)
+	}
+	return relationships, nil
+}
+
 func readJsonFile(filePath string, value any) error {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -1,11 +1,18 @@
 package client
 
 import (
+	"github.com/pennsieve/processor-pre-metadata/client/models/dataset"
 	"github.com/pennsieve/processor-pre-metadata/client/models/datatypes"
 	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNewReader(t *testing.T) {
@@ -27,6 +34,177 @@ func TestNewReader(t *testing.T) {
 
 	assert.NotNil(t, reader.Schema.Proxy())
 	assert.Equal(t, "e18a8519-8368-4062-977a-60707c9c93ec", reader.Schema.Proxy().ID)
+
+	assert.Equal(t, 2, reader.Schema.RelationshipCount())
+	hasBeenAt, exists := reader.Schema.RelationshipByName("has_been_at_9de740e0-29c1-11ef-bd79-2da515dfdab1")
+	assert.True(t, exists)
+	assert.Equal(t, "30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d", hasBeenAt.ID)
+	assert.Equal(t, "Has Been At", hasBeenAt.DisplayName)
+	assert.Equal(t, "bb04a8ce-03c9-4801-a0d9-e35cea53ac1b", hasBeenAt.From)
+	assert.Equal(t, "83964537-46d2-4fb5-9408-0b6262a42a56", hasBeenAt.To)
+	_, exists = reader.Schema.RelationshipByName("address")
+	assert.False(t, exists, "linked properties are not relationships")
+}
+
+func TestNewReader_CompleteMarker(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+	require.NotNil(t, reader.Marker)
+	assert.Equal(t, "N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5", reader.Marker.DatasetID)
+	assert.Equal(t, "dataset", reader.Marker.Scope)
+	assert.Equal(t, time.Date(2024, 9, 26, 22, 5, 14, 87000000, time.UTC), reader.Marker.CompletedAt)
+
+	incompleteDir := copyTestdata(t)
+	require.NoError(t, os.Remove(filepath.Join(incompleteDir, paths.MetadataDirectory, paths.CompleteMarkerFilePath)))
+
+	_, err = NewReader(incompleteDir)
+	assert.ErrorIs(t, err, ErrIncomplete)
+
+	reader, err = NewReader(incompleteDir, AllowIncomplete())
+	require.NoError(t, err)
+	assert.Nil(t, reader.Marker)
+	assert.Equal(t, 3, reader.Schema.ModelCount())
+}
+
+func TestNewReader_MissingPropertiesFile(t *testing.T) {
+	dir := copyTestdata(t)
+	subjectID := "7931cbe6-7494-4c0b-95f0-9f4b34edc73b"
+	require.NoError(t, os.Remove(filepath.Join(dir, paths.MetadataDirectory, paths.PropertiesFilePath(subjectID))))
+
+	reader, err := NewReader(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"location", "object"}, reader.Schema.ModelNames())
+	_, exists := reader.Schema.ModelByName("subject")
+	assert.False(t, exists)
+	_, err = reader.GetRecordsForModel("subject")
+	assert.ErrorContains(t, err, "model subject not found")
+
+	// beholds and address are from subject
+	assert.Equal(t, 1, reader.Schema.RelationshipCount())
+	_, exists = reader.Schema.RelationshipByName("has_been_at_9de740e0-29c1-11ef-bd79-2da515dfdab1")
+	assert.True(t, exists)
+	assert.Zero(t, reader.Schema.LinkedPropertyCount())
+	assert.Empty(t, reader.Schema.LinkedProperties())
+	assert.Empty(t, reader.Schema.RelationshipsTo("object"))
+
+	graph, err := NewGraph(reader)
+	require.NoError(t, err)
+	assert.NotNil(t, graph)
+}
+
+// copyTestdata copies testdata to a temporary directory, for tests that change it
+func copyTestdata(t *testing.T) string {
+	dir := t.TempDir()
+	err := filepath.WalkDir("testdata", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, path[len("testdata"):])
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0644)
+	})
+	require.NoError(t, err)
+	return dir
+}
+
+func TestSchema_RelationshipsFromTo(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	relationshipIDs := func(relationships []schema.Relationship) []string {
+		var ids []string
+		for _, r := range relationships {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	for modelName, expected := range map[string]struct{ from, to []string }{
+		"location": {to: []string{"30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d"}},
+		"object":   {from: []string{"30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d"}, to: []string{"2514a023-17fe-4743-af5f-094ed3dd339c"}},
+		"subject":  {from: []string{"2514a023-17fe-4743-af5f-094ed3dd339c"}},
+		"missing":  {},
+	} {
+		assert.Equal(t, expected.from, relationshipIDs(reader.Schema.RelationshipsFrom(modelName)), "relationships from %s", modelName)
+		assert.Equal(t, expected.to, relationshipIDs(reader.Schema.RelationshipsTo(modelName)), "relationships to %s", modelName)
+	}
+}
+
+func TestNewSchema(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+	var elements []schema.Element
+	for _, modelName := range reader.Schema.ModelNames() {
+		model, _ := reader.Schema.ModelByName(modelName)
+		elements = append(elements, model)
+	}
+	address, _ := reader.Schema.LinkedPropertyByName("address")
+	elements = append(elements, address)
+
+	s := NewSchema(elements, reader.Schema.Proxy())
+	assert.Equal(t, 3, s.ModelCount())
+	assert.Equal(t, reader.Schema.ModelIDsByName(), s.ModelIDsByName())
+	assert.Equal(t, 1, s.LinkedPropertyCount())
+	assert.Equal(t, reader.Schema.Proxy(), s.Proxy())
+	assert.Zero(t, s.RelationshipCount())
+	assert.Nil(t, s.PropertiesForModel("object"))
+}
+
+func TestReader_GetDataset(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	ds, err := reader.GetDataset()
+	require.NoError(t, err)
+	assert.Equal(t, "N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5", ds.ID)
+	assert.Equal(t, int64(5061), ds.IntID)
+	assert.Equal(t, "Metadata Test Dataset", ds.Name)
+	assert.Equal(t, []string{"test", "metadata"}, ds.Tags)
+	assert.Equal(t, "Creative Commons Attribution", ds.License)
+	assert.Equal(t, "NO_STATUS", ds.Status)
+	assert.Equal(t, "N:user:a6f827dc-46e0-487c-9e19-ffe7dda54b42", ds.OwnerID)
+	assert.Equal(t, time.Date(2024, 6, 13, 19, 40, 11, 528713000, time.UTC), ds.CreatedAt)
+
+	require.Len(t, ds.Contributors, 2)
+	userID := int64(172)
+	assert.Equal(t, dataset.Contributor{
+		ID:        301,
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Degree:    "Ph.D.",
+		Email:     "jane.doe@example.com",
+		Orcid:     "0000-0002-1825-0097",
+		UserID:    &userID,
+	}, ds.Contributors[0])
+	assert.Nil(t, ds.Contributors[1].UserID)
+	assert.Equal(t, "Q", ds.Contributors[1].MiddleInitial)
+}
+
+func TestReader_GetChanges(t *testing.T) {
+	dir := copyTestdata(t)
+	reader, err := NewReader(dir)
+	require.NoError(t, err)
+	_, err = reader.GetChanges()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	changes := `{
+  "datasetId": "N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5",
+  "previous": {"datasetId": "N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5", "scope": "dataset"},
+  "models": {},
+  "records": {"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b": {"changed": ["5b07e038-9829-46c9-b698-bf4efef81341"]}}
+}`
+	require.NoError(t, os.WriteFile(filepath.Join(reader.MetadataDirectory, paths.ChangesFilePath), []byte(changes), 0644))
+	actual, err := reader.GetChanges()
+	require.NoError(t, err)
+	require.NotNil(t, actual.Previous)
+	assert.Equal(t, "dataset", actual.Previous.Scope)
+	assert.True(t, actual.Models.IsEmpty())
+	assert.Equal(t, []string{"5b07e038-9829-46c9-b698-bf4efef81341"}, actual.Records["bb04a8ce-03c9-4801-a0d9-e35cea53ac1b"].Changed)
 }
 
 func TestReader_GetProxiesForModel(t *testing.T) {
@@ -61,6 +239,21 @@ func TestReader_GetProxiesForModel(t *testing.T) {
 
 		assert.Equal(t, "6baa77da-9760-4deb-8a19-c97c3286a259", instances1[0].ID)
 		assert.Equal(t, "N:collection:95bb7c19-0e8e-42b2-b53f-f5ce7a08e42a", instances1[0].Content.NodeID)
+		// a collection with one child
+		require.NotNil(t, instances1[0].Storage)
+		assert.Equal(t, int64(485), *instances1[0].Storage)
+		if assert.Len(t, instances1[0].Children, 1) {
+			child := instances1[0].Children[0]
+			assert.Equal(t, "log.txt", child.Content.Name)
+			require.NotNil(t, child.Extension)
+			assert.Equal(t, "txt", *child.Extension)
+			require.NotNil(t, child.Objects)
+			if assert.Len(t, child.Objects.Source, 1) {
+				assert.Equal(t, int64(485), child.Objects.Source[0].Content.Size)
+				assert.Equal(t, "source", child.Objects.Source[0].Content.ObjectType)
+			}
+		}
+		assert.Equal(t, "object", instances1[0].DatasetPath())
 
 		assert.Contains(t, instancesByRecordID, "bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c")
 		instances2 := instancesByRecordID["bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"]
@@ -68,6 +261,18 @@ func TestReader_GetProxiesForModel(t *testing.T) {
 
 		assert.Equal(t, "15bebbdc-e479-462f-b094-043a29cecfc9", instances2[0].ID)
 		assert.Equal(t, "N:package:f90ff4bc-e3e5-4a53-b545-158ea770fbd8", instances2[0].Content.NodeID)
+		assert.Equal(t, []instance.PackagePropertyGroup{{
+			Category: "Pennsieve",
+			Properties: []instance.PackageProperty{
+				{Key: "subtype", Value: "Text", DataType: "string", Display: "Text", Hidden: true},
+				{Key: "icon", Value: "Text", DataType: "string", Display: "Text", Hidden: true},
+			},
+		}}, instances2[0].Properties)
+		// a file in a collection
+		if assert.Len(t, instances2[0].Ancestors, 1) {
+			assert.Equal(t, "N:collection:95bb7c19-0e8e-42b2-b53f-f5ce7a08e42a", instances2[0].Ancestors[0].Content.NodeID)
+		}
+		assert.Equal(t, "object/log.txt", instances2[0].DatasetPath())
 	}
 
 	//subject proxy instances
@@ -255,3 +460,92 @@ func TestReader_GetLinkInstancesForProperty(t *testing.T) {
 	assert.Equal(t, "7681b4f8-7d10-4855-8c87-7fef3b408c0b", linkInstance.From)
 	assert.Equal(t, "e79e8d65-b094-4f36-94f2-1553cd84b4a2", linkInstance.To)
 }
+
+func TestReader_GetRelationshipInstances(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	relationships, err := reader.GetRelationshipInstances("beholds_6a012da0-29bb-11ef-a8a5-6d16b0d3d9a0")
+	require.NoError(t, err)
+	require.Len(t, relationships, 1)
+
+	relationship := relationships[0]
+	assert.Equal(t, "cf2a668c-0e4c-46bc-b799-c29397b22feb", relationship.ID)
+	assert.Equal(t, "beholds_6a012da0-29bb-11ef-a8a5-6d16b0d3d9a0", relationship.Name)
+	assert.Equal(t, "Beholds", relationship.DisplayName)
+	relationshipElement, relationshipElementExists := reader.Schema.RelationshipByName("beholds_6a012da0-29bb-11ef-a8a5-6d16b0d3d9a0")
+	assert.True(t, relationshipElementExists)
+	assert.Equal(t, relationshipElement.ID, relationship.SchemaRelationshipID)
+
+	assert.Equal(t, "7681b4f8-7d10-4855-8c87-7fef3b408c0b", relationship.From)
+	assert.Equal(t, "5b07e038-9829-46c9-b698-bf4efef81341", relationship.To)
+	assert.Equal(t, time.Date(2024, 6, 13, 19, 52, 58, 692000000, time.UTC), relationship.CreatedAt.UTC())
+	assert.Equal(t, "N:user:a6f827dc-46e0-487c-9e19-ffe7dda54b42", relationship.CreatedBy)
+	assert.Equal(t, relationship.CreatedAt, relationship.UpdatedAt)
+	assert.Equal(t, "N:user:a6f827dc-46e0-487c-9e19-ffe7dda54b42", relationship.UpdatedBy)
+	assert.Empty(t, relationship.Values)
+
+	_, err = reader.GetRelationshipInstances("address")
+	assert.ErrorContains(t, err, "relationship address not found")
+}
+
+func TestReader_GetPropertiesForModel(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	properties, err := reader.GetPropertiesForModel("object")
+	require.NoError(t, err)
+	var names []string
+	for _, p := range properties {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"weights", "synonyms", "gpa", "birthday", "is_solid", "id", "name"}, names)
+
+	weights := properties[0]
+	assert.Equal(t, "1063114c-458a-413f-b1b7-d92615eaa440", weights.ID)
+	assert.Equal(t, "Weights", weights.DisplayName)
+	assert.Empty(t, weights.Description)
+	assert.False(t, weights.ConceptTitle)
+	assert.False(t, weights.Required)
+	assert.Nil(t, weights.DefaultValue)
+	assert.Equal(t, time.Date(2024, 9, 26, 21, 23, 44, 832000000, time.UTC), weights.CreatedAt.UTC())
+	assert.Equal(t, weights.CreatedAt, weights.UpdatedAt)
+	weightsType, err := weights.DecodeDataType()
+	require.NoError(t, err)
+	assert.Equal(t, datatypes.ArrayDataType{
+		Type:  datatypes.ArrayType,
+		Items: datatypes.ItemsType{Type: datatypes.LongType, Unit: "kg"},
+	}, weightsType)
+
+	gpaType, err := properties[2].DecodeDataType()
+	require.NoError(t, err)
+	assert.Equal(t, datatypes.DoubleType, gpaType)
+
+	_, err = reader.GetPropertiesForModel("missing")
+	assert.ErrorContains(t, err, "model missing not found")
+}
+
+func TestSchema_ConceptTitleAndRequiredProperties(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+
+	for modelName, expectedTitle := range map[string]string{
+		"location": "coordinates",
+		"object":   "id",
+		"subject":  "name",
+	} {
+		title, hasTitle := reader.Schema.ConceptTitle(modelName)
+		if assert.True(t, hasTitle, "model %s has no concept title", modelName) {
+			assert.Equal(t, expectedTitle, title.Name)
+		}
+		required := reader.Schema.RequiredProperties(modelName)
+		if assert.Len(t, required, 1, "required properties of %s", modelName) {
+			assert.Equal(t, expectedTitle, required[0].Name)
+		}
+	}
+
+	_, hasTitle := reader.Schema.ConceptTitle("missing")
+	assert.False(t, hasTitle)
+	assert.Nil(t, reader.Schema.RequiredProperties("missing"))
+	assert.Nil(t, reader.Schema.PropertiesForModel("missing"))
+}
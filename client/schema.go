@@ -1,14 +1,33 @@
 package client
 
-import "github.com/pennsieve/processor-pre-metadata/client/models/schema"
+import (
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"sort"
+)
 
 type Schema struct {
 	modelNamesToSchemaElements      map[string]schema.Element
 	linkedPropNamesToSchemaElements map[string]schema.Element
-	proxy                           *schema.NullableRelationship
+	relationshipNamesToRelationship map[string]schema.Relationship
+	linkedPropNamesToLinkedProperty map[string]schema.LinkedProperty
+	// relationshipsFrom and relationshipsTo map a model id to the relationships from or to the model, sorted by name
+	relationshipsFrom map[string][]schema.Relationship
+	relationshipsTo   map[string][]schema.Relationship
+	// propertiesByModelID maps a model id to the model's property definitions
+	propertiesByModelID map[string][]schema.Property
+	proxy               *schema.NullableRelationship
 }
 
+// NewSchema returns a Schema indexing the given graph schema elements. Relationships, linked property ends and model
+// properties are not part of schema.Element, so they are only available in the Schema of a Reader.
 func NewSchema(schemaElements []schema.Element, proxy *schema.NullableRelationship) *Schema {
+	return newSchema(schemaElements, nil, nil, nil, proxy)
+}
+
+// newSchema returns a Schema indexing the given graph schema elements. The relationships and linked properties are the
+// graph schema elements of type schema.RelationshipType and schema.LinkedPropertyType, decoded with their from and
+// to model ids. The properties map a model id to the model's property definitions.
+func newSchema(schemaElements []schema.Element, relationships []schema.Relationship, linkedProperties []schema.LinkedProperty, properties map[string][]schema.Property, proxy *schema.NullableRelationship) *Schema {
 	modelMap := make(map[string]schema.Element)
 	linkMap := make(map[string]schema.Element)
 	for _, e := range schemaElements {
@@ -18,9 +37,33 @@ func NewSchema(schemaElements []schema.Element, proxy *schema.NullableRelationsh
 			linkMap[e.Name] = e
 		}
 	}
+	relationshipMap := make(map[string]schema.Relationship, len(relationships))
+	fromMap := make(map[string][]schema.Relationship)
+	toMap := make(map[string][]schema.Relationship)
+	for _, r := range relationships {
+		relationshipMap[r.Name] = r
+		fromMap[r.From] = append(fromMap[r.From], r)
+		toMap[r.To] = append(toMap[r.To], r)
+	}
+	for _, byModel := range []map[string][]schema.Relationship{fromMap, toMap} {
+		for _, modelRelationships := range byModel {
+			sort.Slice(modelRelationships, func(i, j int) bool {
+				return modelRelationships[i].Name < modelRelationships[j].Name
+			})
+		}
+	}
+	linkedPropertyMap := make(map[string]schema.LinkedProperty, len(linkedProperties))
+	for _, l := range linkedProperties {
+		linkedPropertyMap[l.Name] = l
+	}
 	return &Schema{
 		modelNamesToSchemaElements:      modelMap,
 		linkedPropNamesToSchemaElements: linkMap,
+		relationshipNamesToRelationship: relationshipMap,
+		linkedPropNamesToLinkedProperty: linkedPropertyMap,
+		relationshipsFrom:               fromMap,
+		relationshipsTo:                 toMap,
+		propertiesByModelID:             properties,
 		proxy:                           proxy,
 	}
 }
@@ -38,6 +81,11 @@ func (s *Schema) ModelIDsByName() map[string]string {
 	return nameToId
 }
 
+// ModelNames returns the names of all models, sorted
+func (s *Schema) ModelNames() []string {
+	return sortedKeys(s.modelNamesToSchemaElements)
+}
+
 func (s *Schema) ModelByName(modelName string) (model schema.Element, modelExists bool) {
 	model, modelExists = s.modelNamesToSchemaElements[modelName]
 	return
@@ -52,6 +100,87 @@ func (s *Schema) LinkedPropertyByName(linkName string) (linkedProperty schema.El
 	return
 }
 
+// LinkedProperties returns all linked properties, sorted by name. Unlike LinkedPropertyByName, these include the
+// from and to model ids.
+func (s *Schema) LinkedProperties() []schema.LinkedProperty {
+	linkedProperties := make([]schema.LinkedProperty, 0, len(s.linkedPropNamesToLinkedProperty))
+	for _, name := range sortedKeys(s.linkedPropNamesToLinkedProperty) {
+		linkedProperties = append(linkedProperties, s.linkedPropNamesToLinkedProperty[name])
+	}
+	return linkedProperties
+}
+
+func (s *Schema) RelationshipCount() int {
+	return len(s.relationshipNamesToRelationship)
+}
+
+func (s *Schema) RelationshipByName(relationshipName string) (relationship schema.Relationship, relationshipExists bool) {
+	relationship, relationshipExists = s.relationshipNamesToRelationship[relationshipName]
+	return
+}
+
+// Relationships returns all relationships, sorted by name
+func (s *Schema) Relationships() []schema.Relationship {
+	relationships := make([]schema.Relationship, 0, len(s.relationshipNamesToRelationship))
+	for _, name := range sortedKeys(s.relationshipNamesToRelationship) {
+		relationships = append(relationships, s.relationshipNamesToRelationship[name])
+	}
+	return relationships
+}
+
+// RelationshipsFrom returns the relationships whose from end is the given model, sorted by name.
+// Returns nil if the model does not exist or has no outgoing relationships.
+func (s *Schema) RelationshipsFrom(modelName string) []schema.Relationship {
+	model, modelExists := s.ModelByName(modelName)
+	if !modelExists {
+		return nil
+	}
+	return s.relationshipsFrom[model.ID]
+}
+
+// RelationshipsTo returns the relationships whose to end is the given model, sorted by name.
+// Returns nil if the model does not exist or has no incoming relationships.
+func (s *Schema) RelationshipsTo(modelName string) []schema.Relationship {
+	model, modelExists := s.ModelByName(modelName)
+	if !modelExists {
+		return nil
+	}
+	return s.relationshipsTo[model.ID]
+}
+
+// PropertiesForModel returns the property definitions of the given model, in the order of its properties file.
+// Returns nil if the model does not exist.
+func (s *Schema) PropertiesForModel(modelName string) []schema.Property {
+	model, modelExists := s.ModelByName(modelName)
+	if !modelExists {
+		return nil
+	}
+	return s.propertiesByModelID[model.ID]
+}
+
+// ConceptTitle returns the property whose value is the title of the given model's records. Returns false if the
+// model does not exist or has no concept title property.
+func (s *Schema) ConceptTitle(modelName string) (property schema.Property, hasConceptTitle bool) {
+	for _, p := range s.PropertiesForModel(modelName) {
+		if p.ConceptTitle {
+			return p, true
+		}
+	}
+	return schema.Property{}, false
+}
+
+// RequiredProperties returns the required properties of the given model, in the order of its properties file.
+// Returns nil if the model does not exist or has no required properties.
+func (s *Schema) RequiredProperties(modelName string) []schema.Property {
+	var required []schema.Property
+	for _, p := range s.PropertiesForModel(modelName) {
+		if p.Required {
+			required = append(required, p)
+		}
+	}
+	return required
+}
+
 func (s *Schema) Proxy() *schema.NullableRelationship {
 	return s.proxy
 }
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"path/filepath"
+)
+
+// ErrManifestMismatch is returned, wrapped, by Reader.Verify when the metadata directory does not match its manifest
+var ErrManifestMismatch = errors.New("metadata directory does not match manifest")
+
+// GetManifest returns the manifest in metadata/manifest.json
+func (r *Reader) GetManifest() (run.Manifest, error) {
+	manifestFilePath := filepath.Join(r.MetadataDirectory, paths.ManifestFilePath)
+	var manifest run.Manifest
+	if err := readJsonFile(manifestFilePath, &manifest); err != nil {
+		return run.Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// Verify rereads every file of the metadata directory and checks it against the manifest. The returned error wraps
+// ErrManifestMismatch and lists every file that is missing, was added, or has a different size, checksum or element
+// count than the manifest records.
+func (r *Reader) Verify() error {
+	manifest, err := r.GetManifest()
+	if err != nil {
+		return err
+	}
+	actualFiles, err := run.DescribeDirectory(r.MetadataDirectory)
+	if err != nil {
+		return err
+	}
+	actualByPath := make(map[string]run.ManifestFile, len(actualFiles))
+	for _, actual := range actualFiles {
+		actualByPath[actual.Path] = actual
+	}
+	var errs []error
+	for _, expected := range manifest.Files {
+		actual, exists := actualByPath[expected.Path]
+		if !exists {
+			errs = append(errs, fmt.Errorf("%s is missing", expected.Path))
+			continue
+		}
+		delete(actualByPath, expected.Path)
+		switch {
+		case actual.Size != expected.Size:
+			errs = append(errs, fmt.Errorf("%s has size %d; expected %d", expected.Path, actual.Size, expected.Size))
+		case actual.SHA256 != expected.SHA256:
+			errs = append(errs, fmt.Errorf("%s has SHA-256 %s; expected %s", expected.Path, actual.SHA256, expected.SHA256))
+		case actual.Count != expected.Count:
+			errs = append(errs, fmt.Errorf("%s has %d elements; expected %d", expected.Path, actual.Count, expected.Count))
+		}
+	}
+	for _, added := range actualFiles {
+		if _, notInManifest := actualByPath[added.Path]; notInManifest {
+			errs = append(errs, fmt.Errorf("%s is not in the manifest", added.Path))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrManifestMismatch, errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestManifest writes a manifest describing the current contents of the metadata directory under dir
+func writeTestManifest(t *testing.T, dir string) run.Manifest {
+	metadataDirectory := filepath.Join(dir, paths.MetadataDirectory)
+	files, err := run.DescribeDirectory(metadataDirectory)
+	require.NoError(t, err)
+	manifest := run.Manifest{DatasetID: "N:dataset:e323328c-13c3-44f3-aaff-4fd5a941ded5", Files: files}
+	manifestBytes, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(metadataDirectory, paths.ManifestFilePath), manifestBytes, 0644))
+	return manifest
+}
+
+func TestReader_Verify(t *testing.T) {
+	dir := copyTestdata(t)
+	expected := writeTestManifest(t, dir)
+	reader, err := NewReader(dir)
+	require.NoError(t, err)
+
+	manifest, err := reader.GetManifest()
+	require.NoError(t, err)
+	assert.Equal(t, expected, manifest)
+	assert.NoError(t, reader.Verify())
+
+	metadataDirectory := reader.MetadataDirectory
+	objectRecordsPath := paths.RecordsFilePath("bb04a8ce-03c9-4801-a0d9-e35cea53ac1b")
+	require.NoError(t, os.WriteFile(filepath.Join(metadataDirectory, objectRecordsPath), []byte(`[]`), 0644))
+	require.NoError(t, os.Remove(filepath.Join(metadataDirectory, paths.DatasetFilePath)))
+	require.NoError(t, os.WriteFile(filepath.Join(metadataDirectory, "extra.json"), []byte(`{}`), 0644))
+
+	err = reader.Verify()
+	assert.ErrorIs(t, err, ErrManifestMismatch)
+	assert.ErrorContains(t, err, filepath.ToSlash(objectRecordsPath)+" has size 2")
+	assert.ErrorContains(t, err, "dataset.json is missing")
+	assert.ErrorContains(t, err, "extra.json is not in the manifest")
+}
+
+func TestReader_Verify_NoManifest(t *testing.T) {
+	reader, err := NewReader("testdata")
+	require.NoError(t, err)
+	assert.ErrorIs(t, reader.Verify(), os.ErrNotExist)
+}
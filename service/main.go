@@ -1,20 +1,49 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/pennsieve/processor-pre-metadata/service/logging"
 	"github.com/pennsieve/processor-pre-metadata/service/preprocessor"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 var logger = logging.PackageLogger("main")
 
+// Exit codes so that the orchestrator can tell a failed run from one that was stopped
+const (
+	exitCodeError       = 1
+	exitCodeInterrupted = 2
+	exitCodeTimedOut    = 3
+)
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	// First SIGTERM or SIGINT cancels the run. After that, default signal handling is restored so that a
+	// second signal terminates the process immediately.
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go func() {
+		<-signalCtx.Done()
+		stop()
+	}()
 
 	m, err := preprocessor.FromEnv()
 	if err != nil {
 		logger.Error("error creating preprocessor", slog.Any("error", err))
-		os.Exit(1)
+		return exitCodeError
+	}
+
+	runTimeout, err := preprocessor.LookupDurationEnvVar("RUN_TIMEOUT", 0)
+	if err != nil {
+		logger.Error("error reading run timeout", slog.Any("error", err))
+		return exitCodeError
 	}
 
 	logger.Info("created MetadataPreProcessor",
@@ -23,10 +52,30 @@ func main() {
 		slog.String("outputDirectory", m.OutputDirectory),
 		slog.String("APIHost", m.Pennsieve.APIHost),
 		slog.String("API2Host", m.Pennsieve.API2Host),
+		slog.Duration("runTimeout", runTimeout),
 	)
 
-	if err := m.Run(); err != nil {
-		logger.Error("error running preprocessor", slog.Any("error", err))
-		os.Exit(1)
+	ctx := signalCtx
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(signalCtx, runTimeout)
+		defer cancel()
+	}
+
+	if err := m.Run(ctx); err != nil {
+		switch {
+		case signalCtx.Err() != nil:
+			logger.Error("preprocessor interrupted by signal", slog.Any("error", err))
+			return exitCodeInterrupted
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			logger.Error("preprocessor exceeded RUN_TIMEOUT",
+				slog.Duration("runTimeout", runTimeout),
+				slog.Any("error", err))
+			return exitCodeTimedOut
+		default:
+			logger.Error("error running preprocessor", slog.Any("error", err))
+			return exitCodeError
+		}
 	}
+	return 0
 }
@@ -0,0 +1,194 @@
+package pennsieve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/service/util"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// tokenRefreshLeeway is how long before its expiry a token is considered stale and is refreshed
+const tokenRefreshLeeway = 5 * time.Minute
+
+// Token is a Pennsieve session token. A zero Expiry means the expiry is unknown and the
+// token will only be refreshed if Pennsieve rejects it.
+type Token struct {
+	Value  string
+	Expiry time.Time
+}
+
+func (t Token) stale(now time.Time) bool {
+	return len(t.Value) == 0 || (!t.Expiry.IsZero() && !now.Add(tokenRefreshLeeway).Before(t.Expiry))
+}
+
+// A TokenProvider supplies the session tokens a Session sends to Pennsieve. Session calls Token
+// when it needs its first token, when its current token is about to expire, and when Pennsieve
+// responds with 401 Unauthorized. Calls are serialized by Session.
+type TokenProvider interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// StaticTokenProvider always returns the same pre-minted session token. It cannot refresh.
+type StaticTokenProvider string
+
+func (p StaticTokenProvider) Token(_ context.Context) (Token, error) {
+	return Token{Value: string(p)}, nil
+}
+
+// APIKeyTokenProvider exchanges a Pennsieve API key and secret for a session token using the Cognito
+// configuration published by the Pennsieve API.
+type APIKeyTokenProvider struct {
+	APIHost   string
+	APIKey    string
+	APISecret string
+	// HTTPClient is used for both the Pennsieve and Cognito requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// CognitoEndpoint overrides the Cognito endpoint derived from the token pool region. Used by tests.
+	CognitoEndpoint string
+}
+
+// CognitoConfig is the subset of the response to GET /authentication/cognito-config needed for API key authentication.
+type CognitoConfig struct {
+	Region    string `json:"region"`
+	TokenPool struct {
+		Region      string `json:"region"`
+		AppClientID string `json:"appClientId"`
+	} `json:"tokenPool"`
+}
+
+type initiateAuthRequest struct {
+	AuthFlow       string            `json:"AuthFlow"`
+	ClientID       string            `json:"ClientId"`
+	AuthParameters map[string]string `json:"AuthParameters"`
+}
+
+type initiateAuthResponse struct {
+	AuthenticationResult struct {
+		AccessToken string `json:"AccessToken"`
+		ExpiresIn   int64  `json:"ExpiresIn"`
+	} `json:"AuthenticationResult"`
+}
+
+func (p *APIKeyTokenProvider) Token(ctx context.Context) (Token, error) {
+	config, err := p.getCognitoConfig(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+	endpoint := p.CognitoEndpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/", config.TokenPool.Region)
+	}
+	requestBody, err := json.Marshal(initiateAuthRequest{
+		AuthFlow: "USER_PASSWORD_AUTH",
+		ClientID: config.TokenPool.AppClientID,
+		AuthParameters: map[string]string{
+			"USERNAME": p.APIKey,
+			"PASSWORD": p.APISecret,
+		},
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("error marshalling Cognito InitiateAuth request: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return Token{}, fmt.Errorf("error creating Cognito InitiateAuth request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", "AWSCognitoIdentityProviderService.InitiateAuth")
+
+	requestTime := time.Now()
+	response, err := p.client().Do(request)
+	if err != nil {
+		return Token{}, fmt.Errorf("error invoking Cognito InitiateAuth: %w", err)
+	}
+	defer util.CloseAndWarn(response)
+	if err := checkHTTPStatus(response); err != nil {
+		return Token{}, fmt.Errorf("error authenticating with API key: %w", err)
+	}
+	var authResponse initiateAuthResponse
+	if err := json.NewDecoder(response.Body).Decode(&authResponse); err != nil {
+		return Token{}, fmt.Errorf("error decoding Cognito InitiateAuth response: %w", err)
+	}
+	if len(authResponse.AuthenticationResult.AccessToken) == 0 {
+		return Token{}, fmt.Errorf("no access token in Cognito InitiateAuth response")
+	}
+	token := Token{Value: authResponse.AuthenticationResult.AccessToken}
+	if expiresIn := authResponse.AuthenticationResult.ExpiresIn; expiresIn > 0 {
+		token.Expiry = requestTime.Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+func (p *APIKeyTokenProvider) getCognitoConfig(ctx context.Context) (CognitoConfig, error) {
+	url := fmt.Sprintf("%s/authentication/cognito-config", p.APIHost)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return CognitoConfig{}, fmt.Errorf("error creating GET %s request: %w", url, err)
+	}
+	request.Header.Add("accept", "application/json")
+	response, err := p.client().Do(request)
+	if err != nil {
+		return CognitoConfig{}, fmt.Errorf("error invoking GET %s: %w", url, err)
+	}
+	defer util.CloseAndWarn(response)
+	if err := checkHTTPStatus(response); err != nil {
+		return CognitoConfig{}, err
+	}
+	var config CognitoConfig
+	if err := json.NewDecoder(response.Body).Decode(&config); err != nil {
+		return CognitoConfig{}, fmt.Errorf("error decoding response from GET %s: %w", url, err)
+	}
+	return config, nil
+}
+
+func (p *APIKeyTokenProvider) client() *http.Client {
+	if p.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return p.HTTPClient
+}
+
+// currentToken returns the cached token, first getting a new one from the TokenProvider if there is none or it is about to expire.
+func (s *Session) currentToken(ctx context.Context) (string, error) {
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	if s.token.stale(time.Now()) {
+		if err := s.fetchToken(ctx); err != nil {
+			return "", err
+		}
+	}
+	return s.token.Value, nil
+}
+
+// refreshToken replaces rejectedToken with a new one from the TokenProvider. If another request has already
+// replaced rejectedToken, the replacement is returned without another call to the TokenProvider.
+func (s *Session) refreshToken(ctx context.Context, rejectedToken string) (string, error) {
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	if s.token.Value == rejectedToken {
+		if err := s.fetchToken(ctx); err != nil {
+			return "", err
+		}
+	}
+	return s.token.Value, nil
+}
+
+// fetchToken must be called with s.tokenMutex held
+func (s *Session) fetchToken(ctx context.Context) error {
+	token, err := s.tokenProvider.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting Pennsieve session token: %w", err)
+	}
+	if len(token.Value) == 0 {
+		return fmt.Errorf("empty Pennsieve session token from %T", s.tokenProvider)
+	}
+	if len(s.token.Value) > 0 && token.Value != s.token.Value {
+		logger.Info("refreshed Pennsieve session token", slog.Time("expiry", token.Expiry))
+	}
+	s.token = token
+	return nil
+}
@@ -0,0 +1,148 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sequenceTokenProvider returns token-1, token-2, ... on successive calls, each expiring after expiresIn if non-zero
+type sequenceTokenProvider struct {
+	mu        sync.Mutex
+	calls     int
+	expiresIn time.Duration
+}
+
+func (p *sequenceTokenProvider) Token(_ context.Context) (Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	token := Token{Value: fmt.Sprintf("token-%d", p.calls)}
+	if p.expiresIn != 0 {
+		token.Expiry = time.Now().Add(p.expiresIn)
+	}
+	return token, nil
+}
+
+// newAuthServer only accepts requests bearing acceptedToken
+func newAuthServer(t *testing.T, acceptedToken string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer "+acceptedToken {
+			writer.WriteHeader(http.StatusUnauthorized)
+			_, err := writer.Write([]byte(`{"message": "token expired"}`))
+			require.NoError(t, err)
+			return
+		}
+		_, err := writer.Write([]byte(`{}`))
+		require.NoError(t, err)
+	}))
+}
+
+func TestInvokePennsieve_RefreshesOnUnauthorized(t *testing.T) {
+	server := newAuthServer(t, "token-2")
+	defer server.Close()
+
+	provider := &sequenceTokenProvider{}
+	session := newTestSession(t, server.URL, WithTokenProvider(provider), WithRetryPolicy(NoRetries()))
+
+	res, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, 2, provider.calls)
+
+	// refreshed token is reused
+	res, err = session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, 2, provider.calls)
+}
+
+func TestInvokePennsieve_RefreshesOnlyOnce(t *testing.T) {
+	server := newAuthServer(t, "token-3")
+	defer server.Close()
+
+	provider := &sequenceTokenProvider{}
+	session := newTestSession(t, server.URL, WithTokenProvider(provider), WithRetryPolicy(NoRetries()))
+
+	_, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, 2, provider.calls)
+}
+
+func TestInvokePennsieve_StaticTokenNotRetried(t *testing.T) {
+	server := newAuthServer(t, "other-token")
+	defer server.Close()
+
+	session := newTestSession(t, server.URL)
+
+	_, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestInvokePennsieve_RefreshesBeforeExpiry(t *testing.T) {
+	server := newAuthServer(t, "token-2")
+	defer server.Close()
+
+	// tokens expire within tokenRefreshLeeway, so every request needs a new one
+	provider := &sequenceTokenProvider{expiresIn: time.Minute}
+	session := newTestSession(t, server.URL, WithTokenProvider(provider), WithRetryPolicy(NoRetries()))
+
+	_, err := session.currentToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, provider.calls)
+
+	res, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, 2, provider.calls)
+}
+
+func TestAPIKeyTokenProvider(t *testing.T) {
+	apiKey, apiSecret, appClientID := "my-key", "my-secret", "my-client-id"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authentication/cognito-config", func(writer http.ResponseWriter, request *http.Request) {
+		require.Equal(t, http.MethodGet, request.Method)
+		_, err := writer.Write([]byte(fmt.Sprintf(`{"region": "us-east-1", "tokenPool": {"region": "us-east-1", "appClientId": %q}}`, appClientID)))
+		require.NoError(t, err)
+	})
+	mux.HandleFunc("/cognito/", func(writer http.ResponseWriter, request *http.Request) {
+		require.Equal(t, http.MethodPost, request.Method)
+		require.Equal(t, "AWSCognitoIdentityProviderService.InitiateAuth", request.Header.Get("X-Amz-Target"))
+		var authRequest initiateAuthRequest
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&authRequest))
+		assert.Equal(t, "USER_PASSWORD_AUTH", authRequest.AuthFlow)
+		assert.Equal(t, appClientID, authRequest.ClientID)
+		if authRequest.AuthParameters["USERNAME"] != apiKey || authRequest.AuthParameters["PASSWORD"] != apiSecret {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, err := writer.Write([]byte(`{"__type": "NotAuthorizedException", "message": "Incorrect username or password."}`))
+			require.NoError(t, err)
+			return
+		}
+		_, err := writer.Write([]byte(`{"AuthenticationResult": {"AccessToken": "access-token", "ExpiresIn": 3600}}`))
+		require.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider := &APIKeyTokenProvider{
+		APIHost:         server.URL,
+		APIKey:          apiKey,
+		APISecret:       apiSecret,
+		CognitoEndpoint: server.URL + "/cognito/",
+	}
+	token, err := provider.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "access-token", token.Value)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.Expiry, time.Minute)
+
+	provider.APISecret = "wrong"
+	_, err = provider.Token(context.Background())
+	assert.ErrorContains(t, err, "Incorrect username or password.")
+}
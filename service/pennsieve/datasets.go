@@ -0,0 +1,68 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/service/util"
+	"net/http"
+	"time"
+)
+
+// Dataset is the subset of the response to GET /datasets/{id} that is written to the dataset file
+type Dataset struct {
+	Content struct {
+		ID          string    `json:"id"`
+		IntID       int64     `json:"intId"`
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		Tags        []string  `json:"tags"`
+		License     *string   `json:"license"`
+		Status      string    `json:"status"`
+		CreatedAt   time.Time `json:"createdAt"`
+		UpdatedAt   time.Time `json:"updatedAt"`
+	} `json:"content"`
+	// Owner is the node id of the dataset owner
+	Owner string `json:"owner"`
+}
+
+type Contributor struct {
+	ID            int64   `json:"id"`
+	FirstName     string  `json:"firstName"`
+	MiddleInitial *string `json:"middleInitial"`
+	LastName      string  `json:"lastName"`
+	Degree        *string `json:"degree"`
+	Email         string  `json:"email"`
+	Orcid         *string `json:"orcid"`
+	UserID        *int64  `json:"userId"`
+}
+
+func (s *Session) GetDataset(ctx context.Context, datasetID string) (Dataset, error) {
+	url := fmt.Sprintf("%s/datasets/%s", s.APIHost, datasetID)
+	res, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Dataset{}, err
+	}
+	defer util.CloseAndWarn(res)
+
+	var dataset Dataset
+	if err := json.NewDecoder(res.Body).Decode(&dataset); err != nil {
+		return Dataset{}, fmt.Errorf("error decoding response from GET %s: %w", url, err)
+	}
+	return dataset, nil
+}
+
+func (s *Session) GetDatasetContributors(ctx context.Context, datasetID string) ([]Contributor, error) {
+	url := fmt.Sprintf("%s/datasets/%s/contributors", s.APIHost, datasetID)
+	res, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer util.CloseAndWarn(res)
+
+	var contributors []Contributor
+	if err := json.NewDecoder(res.Body).Decode(&contributors); err != nil {
+		return nil, fmt.Errorf("error decoding response from GET %s: %w", url, err)
+	}
+	return contributors, nil
+}
@@ -0,0 +1,110 @@
+package pennsieve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Sentinel errors that an *APIError matches with errors.Is according to its StatusCode
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+)
+
+// maxErrorBodyLength is the most bytes of an error response body kept in APIError.Body
+const maxErrorBodyLength = 2048
+
+// APIError is returned by Session methods when Pennsieve responds with a 4xx or 5xx status.
+type APIError struct {
+	StatusCode int
+	// Status is the status line text, for example "404 Not Found"
+	Status string
+	Method string
+	URL    string
+	// Body is the response body, truncated to maxErrorBodyLength bytes
+	Body string
+	// Message is the error message Pennsieve included in a JSON response body, if any
+	Message string
+}
+
+func (e *APIError) Error() string {
+	errorType := "client"
+	if e.StatusCode >= http.StatusInternalServerError {
+		errorType = "server"
+	}
+	if len(e.Message) > 0 {
+		return fmt.Sprintf("%s error %s calling %s %s: %s", errorType, e.Status, e.Method, e.URL, e.Message)
+	}
+	return fmt.Sprintf("%s error %s calling %s %s; response body: %s", errorType, e.Status, e.Method, e.URL, e.Body)
+}
+
+// Is lets errors.Is match an *APIError against ErrNotFound, ErrUnauthorized, ErrForbidden, and ErrRateLimited.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	default:
+		return false
+	}
+}
+
+// IsClientError returns true if the status code is 4xx
+func (e *APIError) IsClientError() bool {
+	return http.StatusBadRequest <= e.StatusCode && e.StatusCode < http.StatusInternalServerError
+}
+
+// IsServerError returns true if the status code is 5xx
+func (e *APIError) IsServerError() bool {
+	return http.StatusInternalServerError <= e.StatusCode && e.StatusCode < 600
+}
+
+// newAPIError builds an *APIError from response, consuming its body.
+func newAPIError(response *http.Response) *APIError {
+	apiError := &APIError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Method:     response.Request.Method,
+		URL:        response.Request.URL.String(),
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxErrorBodyLength+1))
+	if err != nil {
+		apiError.Body = fmt.Sprintf("<unable to read body: %s>", err.Error())
+		return apiError
+	}
+	apiError.Message = parseErrorMessage(body)
+	if len(body) > maxErrorBodyLength {
+		apiError.Body = string(body[:maxErrorBodyLength]) + "...<truncated>"
+	} else {
+		apiError.Body = string(body)
+	}
+	return apiError
+}
+
+// pennsieveErrorBody covers the JSON error bodies returned by the Pennsieve APIs.
+// The Scala API uses "message" and the Go services use "message" or "error".
+type pennsieveErrorBody struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+func parseErrorMessage(body []byte) string {
+	var errorBody pennsieveErrorBody
+	if err := json.Unmarshal(body, &errorBody); err != nil {
+		return ""
+	}
+	if len(errorBody.Message) > 0 {
+		return errorBody.Message
+	}
+	return errorBody.Error
+}
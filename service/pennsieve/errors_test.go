@@ -0,0 +1,68 @@
+package pennsieve
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInvokePennsieve_APIError(t *testing.T) {
+	for status, expectedSentinel := range map[int]error{
+		http.StatusNotFound:        ErrNotFound,
+		http.StatusUnauthorized:    ErrUnauthorized,
+		http.StatusForbidden:       ErrForbidden,
+		http.StatusTooManyRequests: ErrRateLimited,
+	} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.WriteHeader(status)
+				_, err := writer.Write([]byte(`{"type": "Error", "message": "something went wrong", "code": 1}`))
+				require.NoError(t, err)
+			}))
+			defer server.Close()
+
+			session := newTestSession(t, server.URL, WithRetryPolicy(NoRetries()))
+			_, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL+"/some/path", nil)
+
+			var apiError *APIError
+			require.ErrorAs(t, err, &apiError)
+			assert.Equal(t, status, apiError.StatusCode)
+			assert.Equal(t, http.MethodGet, apiError.Method)
+			assert.Equal(t, server.URL+"/some/path", apiError.URL)
+			assert.Equal(t, "something went wrong", apiError.Message)
+			assert.True(t, apiError.IsClientError())
+
+			assert.ErrorIs(t, err, expectedSentinel)
+			for _, otherSentinel := range []error{ErrNotFound, ErrUnauthorized, ErrForbidden, ErrRateLimited} {
+				if otherSentinel != expectedSentinel {
+					assert.False(t, errors.Is(err, otherSentinel))
+				}
+			}
+		})
+	}
+}
+
+func TestInvokePennsieve_APIErrorTruncatesBody(t *testing.T) {
+	longBody := strings.Repeat("x", 3*maxErrorBodyLength)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+		_, err := writer.Write([]byte(longBody))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	session := newTestSession(t, server.URL, WithRetryPolicy(NoRetries()))
+	_, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+
+	var apiError *APIError
+	require.ErrorAs(t, err, &apiError)
+	assert.True(t, apiError.IsServerError())
+	assert.Empty(t, apiError.Message)
+	assert.True(t, strings.HasPrefix(apiError.Body, longBody[:maxErrorBodyLength]))
+	assert.Less(t, len(apiError.Body), len(longBody))
+}
@@ -1,6 +1,7 @@
 package pennsieve
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pennsieve/processor-pre-metadata/service/util"
@@ -8,10 +9,10 @@ import (
 	"net/http"
 )
 
-func (s *Session) GetIntegration(integrationID string) (Integration, error) {
+func (s *Session) GetIntegration(ctx context.Context, integrationID string) (Integration, error) {
 	url := fmt.Sprintf("%s/integrations/%s", s.API2Host, integrationID)
 
-	res, err := s.InvokePennsieve(http.MethodGet, url, nil)
+	res, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return Integration{}, err
 	}
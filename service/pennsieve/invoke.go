@@ -1,48 +1,179 @@
 package pennsieve
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/pennsieve/processor-pre-metadata/service/logging"
 	"io"
 	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 )
 
 var logger = logging.PackageLogger("pennsieve")
 
 type Session struct {
-	Token    string
-	APIHost  string
-	API2Host string
+	APIHost     string
+	API2Host    string
+	RetryPolicy RetryPolicy
+	// RateLimiter is shared by every request made with this Session. If nil, requests are not rate limited.
+	RateLimiter *RateLimiter
+
+	client         *http.Client
+	userAgent      string
+	overallTimeout time.Duration
+
+	tokenProvider TokenProvider
+	tokenMutex    sync.Mutex
+	token         Token
 }
 
-func NewSession(sessionToken, apiHost, api2Host string) *Session {
-	return &Session{
-		Token:    sessionToken,
-		APIHost:  apiHost,
-		API2Host: api2Host}
+// NewSession returns a Session configured by the given options. Without options, the Session authenticates
+// with sessionToken and uses DefaultRetryPolicy, a RateLimiter allowing DefaultRequestsPerSecond, and an http.Client with no timeout.
+func NewSession(sessionToken, apiHost, api2Host string, options ...SessionOption) (*Session, error) {
+	config := sessionConfig{}
+	for _, option := range options {
+		if err := option(&config); err != nil {
+			return nil, err
+		}
+	}
+	client, err := config.buildClient()
+	if err != nil {
+		return nil, err
+	}
+	tokenProvider, err := config.buildTokenProvider(sessionToken, apiHost, client)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		APIHost:        apiHost,
+		API2Host:       api2Host,
+		RetryPolicy:    DefaultRetryPolicy(),
+		RateLimiter:    NewRateLimiter(DefaultRequestsPerSecond, DefaultBurst),
+		client:         client,
+		userAgent:      config.userAgent,
+		overallTimeout: config.overallTimeout,
+		tokenProvider:  tokenProvider,
+	}
+	if config.retryPolicy != nil {
+		session.RetryPolicy = *config.retryPolicy
+	}
+	if config.rateLimiter != nil || config.noRateLimit {
+		session.RateLimiter = config.rateLimiter
+	}
+	return session, nil
+}
+
+// RateLimitWait returns the total time requests made with this Session have spent waiting on the RateLimiter.
+func (s *Session) RateLimitWait() time.Duration {
+	if s.RateLimiter == nil {
+		return 0
+	}
+	return s.RateLimiter.Waited()
 }
 
-func (s *Session) newPennsieveRequest(method string, url string, body io.Reader) (*http.Request, error) {
-	request, err := http.NewRequest(method, url, body)
+func (s *Session) newPennsieveRequest(ctx context.Context, token string, method string, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("error creating GET %s request: %w", url, err)
 	}
 	request.Header.Add("accept", "application/json")
-	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.Token))
+	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	if len(s.userAgent) > 0 {
+		request.Header.Set("User-Agent", s.userAgent)
+	}
 	return request, nil
 }
 
-func (s *Session) InvokePennsieve(method string, url string, body io.Reader) (*http.Response, error) {
+// InvokePennsieve sends the request and returns the response if it has a non-error status. Idempotent requests
+// that fail with a retryable status or connection error are retried according to s.RetryPolicy. A request without a body
+// that fails with 401 Unauthorized is sent once more if the Session's TokenProvider supplies a new token.
+func (s *Session) InvokePennsieve(ctx context.Context, method string, url string, body io.Reader) (*http.Response, error) {
+	if s.overallTimeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(ctx, s.overallTimeout)
+		res, err := s.invokeWithRetries(timeoutCtx, method, url, body)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// the caller has yet to read the body, so the timeout can only be released once they close it
+		res.Body = cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+		return res, nil
+	}
+	return s.invokeWithRetries(ctx, method, url, body)
+}
 
-	req, err := s.newPennsieveRequest(method, url, body)
+func (s *Session) invokeWithRetries(ctx context.Context, method string, url string, body io.Reader) (*http.Response, error) {
+	maxAttempts := s.RetryPolicy.MaxAttempts
+	if maxAttempts < 1 || !isIdempotent(method) {
+		maxAttempts = 1
+	}
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		token, err := s.currentToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.invokeOnce(ctx, token, method, url, body, attempt)
+		if err == nil {
+			return res, nil
+		}
+		if !refreshed && body == nil && errors.Is(err, ErrUnauthorized) {
+			refreshed = true
+			newToken, refreshErr := s.refreshToken(ctx, token)
+			if refreshErr != nil {
+				return nil, fmt.Errorf("%w; %w", err, refreshErr)
+			}
+			if newToken != token {
+				logger.Info("retrying Pennsieve request with refreshed token",
+					slog.String("method", method),
+					slog.String("url", url),
+					slog.Int("attempt", attempt))
+				// the re-send with a new token does not count against the RetryPolicy
+				attempt--
+				continue
+			}
+		}
+		if attempt >= maxAttempts || !shouldRetry(ctx, res, err) {
+			return nil, err
+		}
+		delay := s.RetryPolicy.delay(attempt, res)
+		logger.Warn("retrying Pennsieve request",
+			slog.String("method", method),
+			slog.String("url", url),
+			slog.Int("attempt", attempt),
+			slog.Int("maxAttempts", maxAttempts),
+			slog.Duration("delay", delay),
+			slog.Any("error", err))
+		if err := sleep(ctx, delay); err != nil {
+			return nil, fmt.Errorf("%s %s canceled before attempt %d: %w", method, url, attempt+1, err)
+		}
+	}
+}
+
+// invokeOnce makes a single attempt. If the response has an error status, both the response and an error are
+// returned so that the caller can decide whether to retry. In that case the response body has already been
+// consumed and closed.
+func (s *Session) invokeOnce(ctx context.Context, token string, method string, url string, body io.Reader, attempt int) (*http.Response, error) {
+	req, err := s.newPennsieveRequest(ctx, token, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("error creating %s %s request: %w", method, url, err)
 	}
+	if s.RateLimiter != nil {
+		if err := s.RateLimiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("%s %s canceled while waiting on rate limiter: %w", method, url, err)
+		}
+	}
+	logger.Debug("invoking Pennsieve",
+		slog.String("method", method),
+		slog.String("url", url),
+		slog.Int("attempt", attempt))
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error invoking %s %s: %w", method, url, err)
+		return nil, fmt.Errorf("error invoking %s %s (attempt %d): %w", method, url, attempt, err)
 	}
 	if err := checkHTTPStatus(res); err != nil {
 		// if there was an error, checkHTTPStatus read the body
@@ -52,34 +183,39 @@ func (s *Session) InvokePennsieve(method string, url string, body io.Reader) (*h
 				slog.String("url", url),
 				slog.Any("error", closeError))
 		}
-		return nil, err
+		return res, err
 	}
 	return res, nil
 }
 
-// checkHTTPStatus returns an error if 400 <= response status code < 600. Otherwise, returns nil.
+// sleep waits for the given duration or until ctx is done, whichever comes first. Returns ctx.Err() in the latter case.
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func shouldRetry(ctx context.Context, response *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if response != nil {
+		return isRetryableStatus(response.StatusCode)
+	}
+	return isRetryableError(err)
+}
+
+// checkHTTPStatus returns an *APIError if 400 <= response status code < 600. Otherwise, returns nil.
 // If an error is being returned, this function will consume response.Body so it should be
 // called before the caller has read the body.
 func checkHTTPStatus(response *http.Response) error {
-	readBody := func() []byte {
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			return []byte(fmt.Sprintf("<unable to read body: %s>", err.Error()))
-		}
-		return body
-	}
 	if http.StatusBadRequest <= response.StatusCode && response.StatusCode < 600 {
-		responseBody := readBody()
-		errorType := "client"
-		if response.StatusCode >= http.StatusInternalServerError {
-			errorType = "server"
-		}
-		return fmt.Errorf("%s error %s calling %s %s; response body: %s",
-			errorType,
-			response.Status,
-			response.Request.Method,
-			response.Request.URL,
-			string(responseBody))
+		return newAPIError(response)
 	}
 	return nil
 }
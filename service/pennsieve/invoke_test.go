@@ -0,0 +1,211 @@
+package pennsieve
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFlakyServer(t *testing.T, failures int, failureStatus int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		call := calls.Add(1)
+		if int(call) <= failures {
+			for k, v := range header {
+				writer.Header()[k] = v
+			}
+			writer.WriteHeader(failureStatus)
+			_, err := writer.Write([]byte(`{"message": "try again"}`))
+			require.NoError(t, err)
+			return
+		}
+		_, err := writer.Write([]byte(`{"ok": true}`))
+		require.NoError(t, err)
+	}))
+	return server, &calls
+}
+
+func newTestSession(t *testing.T, host string, options ...SessionOption) *Session {
+	session, err := NewSession("token", host, host, options...)
+	require.NoError(t, err)
+	return session
+}
+
+func fastRetryPolicy(maxAttempts int) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		MaxRetryAfter:  time.Minute,
+	}
+}
+
+func TestInvokePennsieve_RetriesThenSucceeds(t *testing.T) {
+	for _, status := range []int{
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server, calls := newFlakyServer(t, 2, status, nil)
+			defer server.Close()
+
+			session := newTestSession(t, server.URL, WithRetryPolicy(fastRetryPolicy(3)))
+
+			res, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"ok": true}`, string(body))
+			assert.Equal(t, int32(3), calls.Load())
+		})
+	}
+}
+
+func TestInvokePennsieve_ExhaustsAttempts(t *testing.T) {
+	server, calls := newFlakyServer(t, 5, http.StatusServiceUnavailable, nil)
+	defer server.Close()
+
+	session := newTestSession(t, server.URL, WithRetryPolicy(fastRetryPolicy(3)))
+
+	_, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	assert.ErrorContains(t, err, "503")
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestInvokePennsieve_NoRetry(t *testing.T) {
+	for name, params := range map[string]struct {
+		method string
+		status int
+	}{
+		"non-retryable status": {http.MethodGet, http.StatusNotFound},
+		"non-idempotent":       {http.MethodPost, http.StatusServiceUnavailable},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, calls := newFlakyServer(t, 1, params.status, nil)
+			defer server.Close()
+
+			session := newTestSession(t, server.URL, WithRetryPolicy(fastRetryPolicy(3)))
+
+			_, err := session.InvokePennsieve(context.Background(), params.method, server.URL, nil)
+			assert.Error(t, err)
+			assert.Equal(t, int32(1), calls.Load())
+		})
+	}
+}
+
+func TestInvokePennsieve_RetryAfter(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	defer server.Close()
+
+	session := newTestSession(t, server.URL, WithRetryPolicy(fastRetryPolicy(2)))
+
+	start := time.Now()
+	res, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	delay, ok := parseRetryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, delay)
+
+	delay, ok = parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, delay)
+
+	_, ok = parseRetryAfter("", now)
+	assert.False(t, ok)
+
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestRetryPolicy_DelayCapsRetryAfter(t *testing.T) {
+	now := time.Now()
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Second, MaxRetryAfter: time.Minute}
+	for scenario, tt := range map[string]struct {
+		policy     RetryPolicy
+		retryAfter string
+		expected   time.Duration
+	}{
+		"below cap":           {policy: policy, retryAfter: "30", expected: 30 * time.Second},
+		"seconds above cap":   {policy: policy, retryAfter: "86400", expected: time.Minute},
+		"HTTP-date above cap": {policy: policy, retryAfter: now.Add(48 * time.Hour).Format(http.TimeFormat), expected: time.Minute},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {tt.retryAfter}}}
+			assert.Equal(t, tt.expected, tt.policy.delay(1, response))
+		})
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, expectedMax := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	} {
+		delay := policy.backoff(retry)
+		assert.GreaterOrEqual(t, delay, expectedMax/2, "retry %d", retry)
+		assert.LessOrEqual(t, delay, expectedMax, "retry %d", retry)
+	}
+}
+
+func TestRetryPolicy_ZeroCaps(t *testing.T) {
+	for scenario, tt := range map[string]struct {
+		policy      RetryPolicy
+		retry       int
+		retryAfter  string
+		expectedMin time.Duration
+		expectedMax time.Duration
+	}{
+		"zero policy":                    {policy: RetryPolicy{}, retry: 3},
+		"zero policy honors Retry-After": {policy: RetryPolicy{}, retry: 1, retryAfter: "30", expectedMin: 30 * time.Second, expectedMax: 30 * time.Second},
+		"uncapped backoff doubles":       {policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond}, retry: 4, expectedMin: 400 * time.Millisecond, expectedMax: 800 * time.Millisecond},
+		"uncapped backoff saturates":     {policy: RetryPolicy{InitialBackoff: time.Second}, retry: 100, expectedMin: math.MaxInt64 / 2, expectedMax: math.MaxInt64},
+		"uncapped Retry-After":           {policy: RetryPolicy{MaxBackoff: time.Second}, retry: 1, retryAfter: "86400", expectedMin: 24 * time.Hour, expectedMax: 24 * time.Hour},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+			if len(tt.retryAfter) > 0 {
+				response.Header.Set("Retry-After", tt.retryAfter)
+			}
+			delay := tt.policy.delay(tt.retry, response)
+			assert.GreaterOrEqual(t, delay, tt.expectedMin)
+			assert.LessOrEqual(t, delay, tt.expectedMax)
+		})
+	}
+}
+
+func TestInvokePennsieve_CanceledDuringBackoff(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusServiceUnavailable, http.Header{"Retry-After": {"60"}})
+	defer server.Close()
+
+	session := newTestSession(t, server.URL, WithRetryPolicy(fastRetryPolicy(2)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := session.InvokePennsieve(ctx, http.MethodGet, server.URL, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -1,17 +1,18 @@
 package pennsieve
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 )
 
-func (s *Session) GetGraphSchema(datasetID string) (*http.Response, error) {
+func (s *Session) GetGraphSchema(ctx context.Context, datasetID string) (*http.Response, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/schema/graph", s.APIHost, datasetID)
 
-	return s.InvokePennsieve(http.MethodGet, url, nil)
+	return s.InvokePennsieve(ctx, http.MethodGet, url, nil)
 }
 
-func (s *Session) GetProperties(datasetID, modelID string) (*http.Response, error) {
+func (s *Session) GetProperties(ctx context.Context, datasetID, modelID string) (*http.Response, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/properties", s.APIHost, datasetID, modelID)
-	return s.InvokePennsieve(http.MethodGet, url, nil)
+	return s.InvokePennsieve(ctx, http.MethodGet, url, nil)
 }
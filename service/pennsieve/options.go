@@ -0,0 +1,233 @@
+package pennsieve
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// A SessionOption configures a Session created by NewSession.
+type SessionOption func(*sessionConfig) error
+
+type sessionConfig struct {
+	httpClient     *http.Client
+	transport      http.RoundTripper
+	requestTimeout time.Duration
+	overallTimeout time.Duration
+	proxyURL       *url.URL
+	caBundlePath   string
+	userAgent      string
+	retryPolicy    *RetryPolicy
+	rateLimiter    *RateLimiter
+	noRateLimit    bool
+	tokenProvider  TokenProvider
+	apiKey         string
+	apiSecret      string
+}
+
+// WithHTTPClient makes the Session send requests with the given client instead of one built by NewSession.
+// WithTransport, WithProxy, and WithCABundle cannot be combined with this option.
+func WithHTTPClient(client *http.Client) SessionOption {
+	return func(c *sessionConfig) error {
+		c.httpClient = client
+		return nil
+	}
+}
+
+// WithTransport makes the Session send requests with the given http.RoundTripper. If the RoundTripper is an
+// *http.Transport, WithProxy and WithCABundle will be applied to a clone of it. Otherwise, they cannot be combined with this option.
+func WithTransport(transport http.RoundTripper) SessionOption {
+	return func(c *sessionConfig) error {
+		c.transport = transport
+		return nil
+	}
+}
+
+// WithRequestTimeout limits each attempt of a request, including reading the response body. Zero means no limit.
+func WithRequestTimeout(timeout time.Duration) SessionOption {
+	return func(c *sessionConfig) error {
+		c.requestTimeout = timeout
+		return nil
+	}
+}
+
+// WithOverallTimeout limits a call to InvokePennsieve across all attempts, including time spent in retry backoff
+// and on reading the response body. Zero means no limit.
+func WithOverallTimeout(timeout time.Duration) SessionOption {
+	return func(c *sessionConfig) error {
+		c.overallTimeout = timeout
+		return nil
+	}
+}
+
+// WithProxy sends all requests through the proxy at the given URL instead of the proxy, if any,
+// configured by the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables.
+func WithProxy(proxyURL string) SessionOption {
+	return func(c *sessionConfig) error {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("error parsing proxy URL %s: %w", proxyURL, err)
+		}
+		c.proxyURL = parsed
+		return nil
+	}
+}
+
+// WithCABundle adds the PEM encoded certificates in the given file to the system root CAs trusted by the Session.
+func WithCABundle(caBundlePath string) SessionOption {
+	return func(c *sessionConfig) error {
+		c.caBundlePath = caBundlePath
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) SessionOption {
+	return func(c *sessionConfig) error {
+		c.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) SessionOption {
+	return func(c *sessionConfig) error {
+		c.retryPolicy = &policy
+		return nil
+	}
+}
+
+// WithRateLimiter replaces the default RateLimiter. A nil limiter disables rate limiting.
+func WithRateLimiter(limiter *RateLimiter) SessionOption {
+	return func(c *sessionConfig) error {
+		c.rateLimiter = limiter
+		c.noRateLimit = limiter == nil
+		return nil
+	}
+}
+
+// WithTokenProvider makes the Session get its session tokens from the given TokenProvider
+// instead of using the sessionToken passed to NewSession.
+func WithTokenProvider(provider TokenProvider) SessionOption {
+	return func(c *sessionConfig) error {
+		c.tokenProvider = provider
+		return nil
+	}
+}
+
+// WithAPIKey makes the Session authenticate with an API key and secret, using an APIKeyTokenProvider that shares the
+// Session's http.Client. Session tokens are refreshed before they expire, or if Pennsieve rejects one.
+func WithAPIKey(apiKey, apiSecret string) SessionOption {
+	return func(c *sessionConfig) error {
+		if len(apiKey) == 0 || len(apiSecret) == 0 {
+			return fmt.Errorf("both API key and API secret are required")
+		}
+		c.apiKey = apiKey
+		c.apiSecret = apiSecret
+		return nil
+	}
+}
+
+func (c *sessionConfig) buildTokenProvider(sessionToken string, apiHost string, client *http.Client) (TokenProvider, error) {
+	if c.tokenProvider != nil && len(c.apiKey) > 0 {
+		return nil, fmt.Errorf("token provider and API key options cannot be combined")
+	}
+	if c.tokenProvider != nil {
+		return c.tokenProvider, nil
+	}
+	if len(c.apiKey) > 0 {
+		return &APIKeyTokenProvider{
+			APIHost:    apiHost,
+			APIKey:     c.apiKey,
+			APISecret:  c.apiSecret,
+			HTTPClient: client,
+		}, nil
+	}
+	return StaticTokenProvider(sessionToken), nil
+}
+
+func (c *sessionConfig) buildClient() (*http.Client, error) {
+	if c.httpClient != nil {
+		if c.transport != nil || c.proxyURL != nil || len(c.caBundlePath) > 0 {
+			return nil, fmt.Errorf("custom http.Client cannot be combined with transport, proxy, or CA bundle options")
+		}
+		client := *c.httpClient
+		if c.requestTimeout > 0 {
+			client.Timeout = c.requestTimeout
+		}
+		return &client, nil
+	}
+	transport, err := c.buildTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: c.requestTimeout}, nil
+}
+
+func (c *sessionConfig) buildTransport() (http.RoundTripper, error) {
+	if c.proxyURL == nil && len(c.caBundlePath) == 0 {
+		if c.transport != nil {
+			return c.transport, nil
+		}
+		return http.DefaultTransport.(*http.Transport).Clone(), nil
+	}
+
+	var transport *http.Transport
+	if c.transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	} else if t, isTransport := c.transport.(*http.Transport); isTransport {
+		transport = t.Clone()
+	} else {
+		return nil, fmt.Errorf("proxy and CA bundle options require an *http.Transport; got %T", c.transport)
+	}
+
+	if c.proxyURL != nil {
+		transport.Proxy = http.ProxyURL(c.proxyURL)
+	}
+	if len(c.caBundlePath) > 0 {
+		rootCAs, err := loadCABundle(c.caBundlePath)
+		if err != nil {
+			return nil, err
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = rootCAs
+	}
+	return transport, nil
+}
+
+// loadCABundle returns the system cert pool with the certificates from the given PEM file appended
+func loadCABundle(caBundlePath string) (*x509.CertPool, error) {
+	pemBytes, err := os.ReadFile(caBundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle %s: %w", caBundlePath, err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		logger.Warn("unable to load system cert pool; trusting only CA bundle", slog.Any("error", err))
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", caBundlePath)
+	}
+	return pool, nil
+}
+
+// cancelOnClose releases the context of a response returned by InvokePennsieve when the caller closes its body.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
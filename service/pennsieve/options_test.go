@@ -0,0 +1,110 @@
+package pennsieve
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestNewSession_WithTransport(t *testing.T) {
+	var captured *http.Request
+	transport := roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		captured = request
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(strings.NewReader(`{"datasetId": "N:dataset:1234"}`)),
+			Request:    request,
+		}, nil
+	})
+	session := newTestSession(t, "https://api.example.com",
+		WithTransport(transport),
+		WithUserAgent("processor-pre-metadata/1.2.3 (integration abc)"))
+
+	integration, err := session.GetIntegration(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "N:dataset:1234", integration.DatasetNodeID)
+
+	require.NotNil(t, captured)
+	assert.Equal(t, "https://api.example.com/integrations/abc", captured.URL.String())
+	assert.Equal(t, "processor-pre-metadata/1.2.3 (integration abc)", captured.Header.Get("User-Agent"))
+	assert.Equal(t, "Bearer token", captured.Header.Get("Authorization"))
+}
+
+func TestNewSession_WithCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, err := writer.Write([]byte(`{}`))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	// Without the bundle, the server's self-signed cert is not trusted
+	withoutBundle := newTestSession(t, server.URL, WithRetryPolicy(NoRetries()))
+	_, err := withoutBundle.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	assert.Error(t, err)
+
+	caBundlePath := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caBundlePath, pemBytes, 0644))
+
+	withBundle := newTestSession(t, server.URL, WithCABundle(caBundlePath))
+	res, err := withBundle.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+}
+
+func TestNewSession_InvalidCABundle(t *testing.T) {
+	caBundlePath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundlePath, []byte("not a cert"), 0644))
+
+	_, err := NewSession("token", "", "", WithCABundle(caBundlePath))
+	assert.ErrorContains(t, err, "no PEM certificates")
+}
+
+func TestNewSession_IncompatibleOptions(t *testing.T) {
+	_, err := NewSession("token", "", "", WithHTTPClient(&http.Client{}), WithProxy("http://proxy.example.com:3128"))
+	assert.Error(t, err)
+
+	_, err = NewSession("token", "", "", WithTransport(roundTripperFunc(nil)), WithProxy("http://proxy.example.com:3128"))
+	assert.Error(t, err)
+}
+
+func TestNewSession_Timeouts(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-unblock:
+		case <-request.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	for name, option := range map[string]SessionOption{
+		"request": WithRequestTimeout(20 * time.Millisecond),
+		"overall": WithOverallTimeout(20 * time.Millisecond),
+	} {
+		t.Run(name, func(t *testing.T) {
+			session := newTestSession(t, server.URL, option, WithRetryPolicy(NoRetries()))
+			start := time.Now()
+			_, err := session.InvokePennsieve(context.Background(), http.MethodGet, server.URL, nil)
+			assert.Error(t, err)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
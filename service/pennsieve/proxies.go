@@ -1,6 +1,7 @@
 package pennsieve
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pennsieve/processor-pre-metadata/service/util"
@@ -9,9 +10,9 @@ import (
 
 // GetProxyInstancesForRecord returns an []any because we are only dumping result to a file if there are any
 // proxies. So all we care about here is if the slice is empty or not.
-func (s *Session) GetProxyInstancesForRecord(datasetID, modelID, recordID string) ([]any, error) {
+func (s *Session) GetProxyInstancesForRecord(ctx context.Context, datasetID, modelID, recordID string) ([]any, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances/%s/files", s.APIHost, datasetID, modelID, recordID)
-	res, err := s.InvokePennsieve(http.MethodGet, url, nil)
+	res, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -23,3 +24,47 @@ func (s *Session) GetProxyInstancesForRecord(datasetID, modelID, recordID string
 	}
 	return proxies, nil
 }
+
+// ProxyLink is an instance of the special belongs_to package proxy relationship. It links a record to a package.
+type ProxyLink struct {
+	// ID is the proxy instance id, the same id GetProxyInstancesForRecord returns for the link
+	ID            string `json:"id"`
+	RecordID      string `json:"from"`
+	PackageNodeID string `json:"to"`
+}
+
+// GetProxyLinks returns every package proxy link in the dataset. proxyRelationshipID is the id of the belongs_to relationship
+// in the dataset's relationship schemas.
+func (s *Session) GetProxyLinks(ctx context.Context, datasetID, proxyRelationshipID string) ([]ProxyLink, error) {
+	res, err := s.GetRelationshipInstances(ctx, datasetID, proxyRelationshipID)
+	if err != nil {
+		return nil, err
+	}
+	defer util.CloseAndWarn(res)
+
+	var links []ProxyLink
+	if err := json.NewDecoder(res.Body).Decode(&links); err != nil {
+		return nil, fmt.Errorf("error decoding package proxy links: %w", err)
+	}
+	return links, nil
+}
+
+// GetPackage returns the package in the same format as the package half of each proxy returned by GetProxyInstancesForRecord.
+// If includeAncestors is true, the package also has an "ancestors" list of the collections containing it, from the top level down.
+func (s *Session) GetPackage(ctx context.Context, packageNodeID string, includeAncestors bool) (map[string]any, error) {
+	url := fmt.Sprintf("%s/packages/%s", s.APIHost, packageNodeID)
+	if includeAncestors {
+		url += "?includeAncestors=true"
+	}
+	res, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer util.CloseAndWarn(res)
+
+	var pkg map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&pkg); err != nil {
+		return nil, fmt.Errorf("error decoding package %s: %w", packageNodeID, err)
+	}
+	return pkg, nil
+}
@@ -0,0 +1,91 @@
+package pennsieve
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultRequestsPerSecond = 20.0
+const DefaultBurst = 20
+
+// RateLimiter is a token bucket limiting the rate of requests a Session sends to Pennsieve.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	mu                sync.Mutex
+	requestsPerSecond float64
+	burst             float64
+	tokens            float64
+	last              time.Time
+	// waited is the total time, in nanoseconds, callers have spent blocked in Wait
+	waited atomic.Int64
+}
+
+// NewRateLimiter returns a RateLimiter that allows requestsPerSecond on average, with bursts of up to burst requests.
+// The bucket starts full. Returns nil if requestsPerSecond <= 0, which Session treats as unlimited.
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		requestsPerSecond: requestsPerSecond,
+		burst:             float64(burst),
+		tokens:            float64(burst),
+		last:              time.Now(),
+	}
+}
+
+// Wait blocks until a request is allowed or ctx is done. In the latter case the reservation is released
+// and ctx.Err() is returned.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	start := time.Now()
+	err := sleep(ctx, delay)
+	l.waited.Add(int64(time.Since(start)))
+	if err != nil {
+		l.release()
+		return err
+	}
+	return nil
+}
+
+// Waited returns the total time callers have spent blocked in Wait.
+func (l *RateLimiter) Waited() time.Duration {
+	return time.Duration(l.waited.Load())
+}
+
+// reserve takes a token, which may leave the bucket in debt, and returns how long the caller must wait
+// before the token is actually available.
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	elapsed := now.Sub(l.last)
+	if elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.requestsPerSecond
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+	}
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.requestsPerSecond * float64(time.Second))
+}
+
+func (l *RateLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
@@ -0,0 +1,48 @@
+package pennsieve
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter(100, 1)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 11; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, limiter.Wait(context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	// first request uses the burst token, the remaining 10 are spaced 10ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 95*time.Millisecond)
+	assert.Greater(t, limiter.Waited(), time.Duration(0))
+}
+
+func TestRateLimiter_WaitCanceled(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	require.NoError(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+
+	// the canceled reservation was released, so the next token is available after ~1s, not ~2s
+	assert.LessOrEqual(t, limiter.reserve(time.Now()), time.Second)
+}
+
+func TestNewRateLimiter_Unlimited(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(0, 10))
+
+	session := newTestSession(t, "", WithRateLimiter(nil))
+	assert.Equal(t, time.Duration(0), session.RateLimitWait())
+}
@@ -1,15 +1,17 @@
 package pennsieve
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pennsieve/processor-pre-metadata/service/util"
 	"net/http"
 )
 
-func (s *Session) GetRecordsPage(datasetID string, modelID string, limit int, offset int) ([]map[string]any, error) {
+// GetRecordsPage returns one page of the model's records. Each record is left undecoded so that it can be written out as-is.
+func (s *Session) GetRecordsPage(ctx context.Context, datasetID string, modelID string, limit int, offset int) ([]json.RawMessage, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/concepts/%s/instances?limit=%d&offset=%d", s.APIHost, datasetID, modelID, limit, offset)
-	res, err := s.InvokePennsieve(http.MethodGet, url, nil)
+	res, err := s.InvokePennsieve(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -18,24 +20,27 @@ func (s *Session) GetRecordsPage(datasetID string, modelID string, limit int, of
 
 	decoder := json.NewDecoder(res.Body)
 
-	var batch []map[string]any
+	var batch []json.RawMessage
 	if err = decoder.Decode(&batch); err != nil {
 		return nil, fmt.Errorf("error decoding records for model %s: %w", modelID, err)
 	}
 	return batch, nil
 }
 
-func (s *Session) GetAllRecords(datasetID string, modelID string, batchSize int) ([]map[string]any, error) {
+// ForEachRecordsPage pages through all the model's records, batchSize at a time, and calls handlePage with each page in order.
+// Only one page is held in memory at a time. Stops at the first error returned by handlePage.
+func (s *Session) ForEachRecordsPage(ctx context.Context, datasetID string, modelID string, batchSize int, handlePage func(page []json.RawMessage) error) error {
 	if batchSize <= 0 {
-		return nil, fmt.Errorf("illegal batchSize; must be > 0: %d", batchSize)
+		return fmt.Errorf("illegal batchSize; must be > 0: %d", batchSize)
 	}
 
-	var records []map[string]any
 	for offset := 0; true; {
-		if batch, err := s.GetRecordsPage(datasetID, modelID, batchSize, offset); err != nil {
-			return nil, err
+		if batch, err := s.GetRecordsPage(ctx, datasetID, modelID, batchSize, offset); err != nil {
+			return err
 		} else {
-			records = append(records, batch...)
+			if err := handlePage(batch); err != nil {
+				return err
+			}
 			if len(batch) < batchSize {
 				// this endpoint does not tell us when it's returned the final page, so we have to call it until it returns empty
 				// but also, if it has returned less than batchSize, then there should not be any more records.
@@ -45,5 +50,5 @@ func (s *Session) GetAllRecords(datasetID string, modelID string, batchSize int)
 			}
 		}
 	}
-	return records, nil
+	return nil
 }
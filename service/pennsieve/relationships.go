@@ -1,16 +1,17 @@
 package pennsieve
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 )
 
-func (s *Session) GetRelationshipInstances(datasetID, schemaRelationshipID string) (*http.Response, error) {
+func (s *Session) GetRelationshipInstances(ctx context.Context, datasetID, schemaRelationshipID string) (*http.Response, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/relationships/%s/instances", s.APIHost, datasetID, schemaRelationshipID)
-	return s.InvokePennsieve(http.MethodGet, url, nil)
+	return s.InvokePennsieve(ctx, http.MethodGet, url, nil)
 }
 
-func (s *Session) GetRelationshipSchemas(datasetID string) (*http.Response, error) {
+func (s *Session) GetRelationshipSchemas(ctx context.Context, datasetID string) (*http.Response, error) {
 	url := fmt.Sprintf("%s/models/datasets/%s/relationships", s.APIHost, datasetID)
-	return s.InvokePennsieve(http.MethodGet, url, nil)
+	return s.InvokePennsieve(ctx, http.MethodGet, url, nil)
 }
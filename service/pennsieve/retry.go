@@ -0,0 +1,132 @@
+package pennsieve
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const defaultMaxAttempts = 5
+const defaultInitialBackoff = 500 * time.Millisecond
+const defaultMaxBackoff = 30 * time.Second
+const defaultMaxRetryAfter = 5 * time.Minute
+
+// RetryPolicy controls how Session.InvokePennsieve retries a failed request. Only idempotent requests are retried,
+// and only if they failed with a retryable status code or a transient connection error.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values < 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the base delay before the first retry. It doubles with each subsequent retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the computed delay between attempts. It does not cap a delay requested by a Retry-After header.
+	// Values <= 0 leave the computed delay uncapped.
+	MaxBackoff time.Duration
+	// MaxRetryAfter caps a delay requested by a Retry-After header, so that a server cannot park the run indefinitely.
+	// Values <= 0 leave the requested delay uncapped.
+	MaxRetryAfter time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    defaultMaxAttempts,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		MaxRetryAfter:  defaultMaxRetryAfter,
+	}
+}
+
+// NoRetries is a RetryPolicy that makes a single attempt.
+func NoRetries() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// backoff returns the delay before the given retry (retry 1 is the second attempt).
+// Uses exponential backoff with "equal jitter": half the computed delay is fixed and half is random.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		if delay > math.MaxInt64/2 {
+			delay = math.MaxInt64
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// delay returns how long to wait before retrying after the given failed attempt. If the response has a
+// usable Retry-After header and the status is one where Pennsieve sends it, that value, capped at MaxRetryAfter, is
+// used instead of the backoff.
+func (p RetryPolicy) delay(attempt int, response *http.Response) time.Duration {
+	if response != nil && (response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable) {
+		if retryAfter, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+			if p.MaxRetryAfter > 0 && retryAfter > p.MaxRetryAfter {
+				return p.MaxRetryAfter
+			}
+			return retryAfter
+		}
+	}
+	return p.backoff(attempt)
+}
+
+// parseRetryAfter handles both forms of the Retry-After header: delay-seconds and HTTP-date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableError reports whether err returned by http.Client.Do looks like a transient connection problem.
+func isRetryableError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,77 @@
+package preprocessor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// atomicFile is written to a temporary file in the directory of its final path, and only renamed into place by
+// finish if everything was written, so that a reader never sees a partially written file at the final path.
+type atomicFile struct {
+	*os.File
+	path string
+	// keepExisting is set when the file turned out to hold the same content as the file already at path, which finish
+	// then leaves untouched
+	keepExisting bool
+}
+
+// createAtomic creates the temporary file for filePath. The caller must defer finish.
+func createAtomic(filePath string) (*atomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), fmt.Sprintf(".%s.*.tmp", filepath.Base(filePath)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating file %s: %w", filePath, err)
+	}
+	// CreateTemp uses 0600, but the output is read by other processors
+	if err := file.Chmod(0644); err != nil {
+		closeAndRemoveTemp(file)
+		return nil, fmt.Errorf("error creating file %s: %w", filePath, err)
+	}
+	return &atomicFile{File: file, path: filePath}, nil
+}
+
+// finish syncs and closes the temporary file and renames it to the final path. If *errPtr is non-nil, or if any of
+// those steps fail, or if keepExisting is set, the temporary file is removed instead, leaving the final path as it was. An error from finish is
+// assigned to *errPtr if there was no earlier error. Meant to be deferred by a function with a named error return.
+func (f *atomicFile) finish(errPtr *error) {
+	if *errPtr == nil && f.keepExisting {
+		_ = f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			*errPtr = fmt.Errorf("error removing unneeded file %s: %w", f.Name(), err)
+		}
+		return
+	}
+	if *errPtr == nil {
+		*errPtr = f.commit()
+	}
+	if *errPtr != nil {
+		closeAndRemoveTemp(f.File)
+	}
+}
+
+func (f *atomicFile) commit() error {
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing file %s: %w", f.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing file %s: %w", f.path, err)
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return fmt.Errorf("error renaming %s to %s: %w", f.Name(), f.path, err)
+	}
+	return nil
+}
+
+// closeAndRemoveTemp closes and removes a partially written temporary file. The close may fail if the file was
+// already closed by commit, so its error is ignored.
+func closeAndRemoveTemp(file *os.File) {
+	_ = file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		logger.Warn("error removing partially written file",
+			slog.String("path", file.Name()),
+			slog.Any("error", err))
+	} else {
+		logger.Info("removed partially written file", slog.String("path", file.Name()))
+	}
+}
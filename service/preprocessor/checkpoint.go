@@ -0,0 +1,310 @@
+package preprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpointVersion changes whenever the meaning of checkpoint units or fingerprints changes, so that a journal
+// written by another version is not trusted
+const checkpointVersion = 2
+
+// checkpointEntry is one line of the checkpoint journal. The first line is a header with Version and DatasetID set.
+// Every other line records a completed unit of work.
+type checkpointEntry struct {
+	Version     int                `json:"version,omitempty"`
+	DatasetID   string             `json:"datasetId,omitempty"`
+	Unit        string             `json:"unit,omitempty"`
+	Fingerprint string             `json:"fingerprint,omitempty"`
+	Files       []run.ManifestFile `json:"files,omitempty"`
+}
+
+// checkpoint is the journal of the units of work completed by a run, kept in the metadata directory so that a failed
+// run can be resumed. A unit is the records of a model, the instances of a relationship or linked property, or the
+// package proxies of the records of a model. Each is recorded with a fingerprint of the schema and settings it was fetched with, and with the
+// checksums of its files, so that a resumed run only skips a unit if neither has changed since.
+// The schema files are not units: they are fetched by every run, since they are needed to detect schema changes.
+// A nil *checkpoint records nothing and skips nothing.
+type checkpoint struct {
+	metadataDirectory string
+	mutex             sync.Mutex
+	journal           *os.File
+	// previous are the units completed by earlier runs, by unit name
+	previous map[string]checkpointEntry
+	// written collects the files of skipped units, which the run keeps
+	written *writtenFiles
+}
+
+// openCheckpoint starts the checkpoint journal of a run. If resume is true, the units recorded by an earlier run for
+// the same dataset are loaded and the journal is appended to. Otherwise, any earlier journal is discarded. The files of
+// the units skipped are added to written.
+func openCheckpoint(metadataDirectory string, datasetID string, resume bool, written *writtenFiles) (*checkpoint, error) {
+	journalPath := filepath.Join(metadataDirectory, paths.CheckpointFilePath)
+	c := &checkpoint{metadataDirectory: metadataDirectory, previous: map[string]checkpointEntry{}, written: written}
+	if resume {
+		previous, err := loadCheckpoint(journalPath, datasetID)
+		if err != nil {
+			return nil, err
+		}
+		c.previous = previous
+		logger.Info("resuming from checkpoint", slog.String("path", journalPath), slog.Int("completedUnits", len(previous)))
+	}
+	if len(c.previous) > 0 {
+		journal, err := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening checkpoint journal %s: %w", journalPath, err)
+		}
+		c.journal = journal
+		return c, nil
+	}
+	journal, err := os.Create(journalPath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating checkpoint journal %s: %w", journalPath, err)
+	}
+	c.journal = journal
+	if err := c.append(checkpointEntry{Version: checkpointVersion, DatasetID: datasetID}); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadCheckpoint returns the units recorded in the journal at journalPath, or none if there is no journal or it was
+// written by another checkpoint version or for another dataset. Reading stops at the first line that cannot be
+// decoded, such as one cut short when the earlier run was killed.
+func loadCheckpoint(journalPath string, datasetID string) (map[string]checkpointEntry, error) {
+	previous := map[string]checkpointEntry{}
+	file, err := os.Open(journalPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Info("no checkpoint journal to resume from", slog.String("path", journalPath))
+			return previous, nil
+		}
+		return nil, fmt.Errorf("error opening checkpoint journal %s: %w", journalPath, err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry checkpointEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				logger.Warn("ignoring the rest of the checkpoint journal after an undecodable line",
+					slog.String("path", journalPath),
+					slog.Int("line", lineNumber),
+					slog.Any("error", err))
+				return previous, nil
+			}
+			if lineNumber == 1 && (entry.Version != checkpointVersion || entry.DatasetID != datasetID) {
+				logger.Warn("checkpoint journal is for another dataset or version; ignoring it",
+					slog.String("path", journalPath),
+					slog.String("journalDatasetID", entry.DatasetID),
+					slog.Int("journalVersion", entry.Version))
+				return map[string]checkpointEntry{}, nil
+			}
+			if lineNumber > 1 {
+				// a unit redone after being invalidated appears again, and its last entry is the one that counts
+				previous[entry.Unit] = entry
+			}
+		}
+		if readErr == io.EOF {
+			return previous, nil
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("error reading checkpoint journal %s: %w", journalPath, readErr)
+		}
+	}
+}
+
+// skip returns true if an earlier run completed the unit with the same fingerprint and its files are unchanged
+func (c *checkpoint) skip(unit string, fingerprint string) bool {
+	if c == nil {
+		return false
+	}
+	entry, completed := c.previous[unit]
+	if !completed {
+		return false
+	}
+	unitLogger := logger.With(slog.String("unit", unit))
+	if entry.Fingerprint != fingerprint {
+		unitLogger.Info("schema or settings changed since unit was completed; redoing it")
+		return false
+	}
+	for _, recorded := range entry.Files {
+		actual, err := run.DescribeFile(c.metadataDirectory, filepath.FromSlash(recorded.Path))
+		if err != nil {
+			unitLogger.Info("file of completed unit cannot be read; redoing unit", slog.String("path", recorded.Path), slog.Any("error", err))
+			return false
+		}
+		if actual.Size != recorded.Size || actual.SHA256 != recorded.SHA256 {
+			unitLogger.Info("file of completed unit was modified; redoing unit", slog.String("path", recorded.Path))
+			return false
+		}
+	}
+	unitLogger.Info("unit completed by an earlier run; skipping it", slog.Int("files", len(entry.Files)))
+	for _, recorded := range entry.Files {
+		c.written.add(filepath.FromSlash(recorded.Path))
+	}
+	return true
+}
+
+// complete records that the unit is done, with the given files relative to the metadata directory. If a file does not
+// exist, because its schema element was deleted during the run, the unit is not recorded, so that it is redone.
+func (c *checkpoint) complete(unit string, fingerprint string, relativePaths ...string) error {
+	if c == nil {
+		return nil
+	}
+	entry := checkpointEntry{Unit: unit, Fingerprint: fingerprint, Files: []run.ManifestFile{}}
+	for _, relativePath := range relativePaths {
+		file, err := run.DescribeFile(c.metadataDirectory, relativePath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				logger.Warn("file of completed unit does not exist; not checkpointing the unit, so that it is redone",
+					slog.String("unit", unit),
+					slog.String("path", relativePath))
+				return nil
+			}
+			return fmt.Errorf("error checkpointing unit %s: %w", unit, err)
+		}
+		entry.Files = append(entry.Files, file)
+	}
+	return c.append(entry)
+}
+
+// completeDirectory is complete with every file under relativeDirectory
+func (c *checkpoint) completeDirectory(unit string, fingerprint string, relativeDirectory string) error {
+	if c == nil {
+		return nil
+	}
+	var relativePaths []string
+	err := filepath.WalkDir(filepath.Join(c.metadataDirectory, relativeDirectory), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) == ".tmp" {
+			return nil
+		}
+		relativePath, err := filepath.Rel(c.metadataDirectory, path)
+		relativePaths = append(relativePaths, relativePath)
+		return err
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error checkpointing unit %s: %w", unit, err)
+	}
+	return c.complete(unit, fingerprint, relativePaths...)
+}
+
+// removeStale removes the files of the units completed by an earlier run that are not among currentUnits, because
+// their schema element was deleted or is no longer exported
+func (c *checkpoint) removeStale(currentUnits map[string]bool) error {
+	if c == nil {
+		return nil
+	}
+	for unit, entry := range c.previous {
+		if currentUnits[unit] {
+			continue
+		}
+		logger.Info("unit of an earlier run is no longer in the schema; removing its files", slog.String("unit", unit))
+		for _, file := range entry.Files {
+			if err := removeIfExists(filepath.Join(c.metadataDirectory, filepath.FromSlash(file.Path))); err != nil {
+				return err
+			}
+		}
+		delete(c.previous, unit)
+	}
+	return nil
+}
+
+// append writes entry as a line of the journal and syncs it, so that a recorded unit survives a crash
+func (c *checkpoint) append(entry checkpointEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint entry: %w", err)
+	}
+	line = append(line, '\n')
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, err := c.journal.Write(line); err != nil {
+		return fmt.Errorf("error writing checkpoint journal %s: %w", c.journal.Name(), err)
+	}
+	if err := c.journal.Sync(); err != nil {
+		return fmt.Errorf("error syncing checkpoint journal %s: %w", c.journal.Name(), err)
+	}
+	return nil
+}
+
+// close closes the journal, leaving it in place for a resumed run
+func (c *checkpoint) close() {
+	if c == nil {
+		return
+	}
+	if err := c.journal.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		logger.Warn("error closing checkpoint journal", slog.String("path", c.journal.Name()), slog.Any("error", err))
+	}
+}
+
+// remove closes and removes the journal once the run is complete, since there is nothing left to resume
+func (c *checkpoint) remove() {
+	if c == nil {
+		return
+	}
+	c.close()
+	if err := os.Remove(c.journal.Name()); err != nil {
+		logger.Warn("error removing checkpoint journal", slog.String("path", c.journal.Name()), slog.Any("error", err))
+	}
+}
+
+// fingerprint returns the hex encoded SHA-256 checksum of the JSON encoding of values
+func fingerprint(values ...any) (string, error) {
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	for _, value := range values {
+		if err := encoder.Encode(value); err != nil {
+			return "", fmt.Errorf("error computing checkpoint fingerprint: %w", err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func recordsUnit(model schema.Model) string {
+	return "records/" + model.ID
+}
+
+func relationshipUnit(relationship schema.Relationship) string {
+	return "relationships/" + relationship.ID
+}
+
+func linkedPropertyUnit(linkedProperty schema.LinkedProperty) string {
+	return "linkedProperties/" + linkedProperty.ID
+}
+
+func proxiesUnit(model schema.Model) string {
+	return "proxies/" + model.ID
+}
+
+// readRecordIDs returns the ids of the records in a records file, for a records unit that is skipped
+func readRecordIDs(filePath string) ([]string, error) {
+	recordIDs := []string{}
+	err := forEachArrayElement(filePath, func(recordID string, _ json.RawMessage) error {
+		recordIDs = append(recordIDs, recordID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recordIDs, nil
+}
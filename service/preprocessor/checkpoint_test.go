@@ -0,0 +1,99 @@
+package preprocessor
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	metadataDirectory := t.TempDir()
+	datasetID := uuid.NewString()
+	require.NoError(t, os.WriteFile(filepath.Join(metadataDirectory, "a.json"), []byte(`[1, 2]`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(metadataDirectory, "b.json"), []byte(`[3]`), 0644))
+
+	c, err := openCheckpoint(metadataDirectory, datasetID, false, nil)
+	require.NoError(t, err)
+	require.NoError(t, c.complete("a", "fingerprint-a", "a.json"))
+	require.NoError(t, c.complete("b", "fingerprint-b", "b.json"))
+	// a unit whose file was never written is not recorded
+	require.NoError(t, c.complete("missing", "fingerprint-missing", "missing.json"))
+	c.close()
+
+	resumed, err := openCheckpoint(metadataDirectory, datasetID, true, nil)
+	require.NoError(t, err)
+	defer resumed.close()
+	assert.True(t, resumed.skip("a", "fingerprint-a"))
+	assert.False(t, resumed.skip("a", "changed-fingerprint"))
+	assert.False(t, resumed.skip("missing", "fingerprint-missing"))
+	assert.False(t, resumed.skip("unknown", "fingerprint-a"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(metadataDirectory, "b.json"), []byte(`[4]`), 0644))
+	assert.False(t, resumed.skip("b", "fingerprint-b"))
+
+	require.NoError(t, resumed.removeStale(map[string]bool{"b": true}))
+	assert.NoFileExists(t, filepath.Join(metadataDirectory, "a.json"))
+	assert.FileExists(t, filepath.Join(metadataDirectory, "b.json"))
+	assert.False(t, resumed.skip("a", "fingerprint-a"))
+}
+
+func TestCheckpoint_NotResumed(t *testing.T) {
+	datasetID := uuid.NewString()
+	for scenario, tt := range map[string]struct {
+		datasetID string
+		resume    bool
+	}{
+		"resume off":      {datasetID: datasetID, resume: false},
+		"another dataset": {datasetID: uuid.NewString(), resume: true},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			metadataDirectory := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(metadataDirectory, "a.json"), []byte(`[1, 2]`), 0644))
+			c, err := openCheckpoint(metadataDirectory, datasetID, false, nil)
+			require.NoError(t, err)
+			require.NoError(t, c.complete("a", "fingerprint-a", "a.json"))
+			c.close()
+
+			c, err = openCheckpoint(metadataDirectory, tt.datasetID, tt.resume, nil)
+			require.NoError(t, err)
+			defer c.close()
+			assert.False(t, c.skip("a", "fingerprint-a"))
+		})
+	}
+}
+
+func TestLoadCheckpoint_TruncatedLine(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), paths.CheckpointFilePath)
+	datasetID := uuid.NewString()
+	journal := fmt.Sprintf(`{"version": %d, "datasetId": "%s"}`, checkpointVersion, datasetID) + `
+{"unit": "a", "fingerprint": "fingerprint-a", "files": []}
+{"unit": "b", "fingerprint": "fingerprint-b", "fil`
+	require.NoError(t, os.WriteFile(journalPath, []byte(journal), 0644))
+
+	previous, err := loadCheckpoint(journalPath, datasetID)
+	require.NoError(t, err)
+	assert.Contains(t, previous, "a")
+	assert.NotContains(t, previous, "b")
+}
+
+func TestLoadCheckpoint_NoJournal(t *testing.T) {
+	previous, err := loadCheckpoint(filepath.Join(t.TempDir(), paths.CheckpointFilePath), uuid.NewString())
+	require.NoError(t, err)
+	assert.Empty(t, previous)
+}
+
+func TestReadRecordIDs(t *testing.T) {
+	modelID := "bb04a8ce-03c9-4801-a0d9-e35cea53ac1b"
+	recordIDs, err := readRecordIDs(filepath.Join("testdata", paths.RecordsFilePath(modelID)))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"a9b9d03b-19b3-4a43-b40e-5673ec955e49",
+		"bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c",
+		"5b07e038-9829-46c9-b698-bf4efef81341",
+	}, recordIDs)
+}
@@ -0,0 +1,75 @@
+package preprocessor
+
+import (
+	"context"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/dataset"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"github.com/pennsieve/processor-pre-metadata/service/pennsieve"
+	"log/slog"
+	"path/filepath"
+)
+
+// WriteDataset writes the dataset's name, description, tags, license, contributors, owner, and status to the dataset file.
+func (m *MetadataPreProcessor) WriteDataset(ctx context.Context, metadataDirectory string, datasetID string) error {
+	pennsieveDataset, err := m.Pennsieve.GetDataset(ctx, datasetID)
+	if err != nil {
+		return fmt.Errorf("error getting dataset %s: %w", datasetID, err)
+	}
+	contributors, err := m.Pennsieve.GetDatasetContributors(ctx, datasetID)
+	if err != nil {
+		return fmt.Errorf("error getting dataset %s contributors: %w", datasetID, err)
+	}
+	ds := toDataset(pennsieveDataset, contributors)
+	datasetFilePath := filepath.Join(metadataDirectory, paths.DatasetFilePath)
+	sz, err := WriteJSON(datasetFilePath, ds)
+	if err != nil {
+		return fmt.Errorf("error writing dataset %s to %s: %w", datasetID, datasetFilePath, err)
+	}
+	logger.Info("wrote dataset",
+		slog.String("path", datasetFilePath),
+		slog.String("name", ds.Name),
+		slog.Int("contributors", len(ds.Contributors)),
+		slog.Int64("size", sz))
+	return nil
+}
+
+func toDataset(pennsieveDataset pennsieve.Dataset, contributors []pennsieve.Contributor) dataset.Dataset {
+	content := pennsieveDataset.Content
+	ds := dataset.Dataset{
+		ID:           content.ID,
+		IntID:        content.IntID,
+		Name:         content.Name,
+		Description:  content.Description,
+		Tags:         content.Tags,
+		License:      valueOrEmpty(content.License),
+		Status:       content.Status,
+		OwnerID:      pennsieveDataset.Owner,
+		Contributors: make([]dataset.Contributor, 0, len(contributors)),
+		CreatedAt:    content.CreatedAt,
+		UpdatedAt:    content.UpdatedAt,
+	}
+	if ds.Tags == nil {
+		ds.Tags = []string{}
+	}
+	for _, contributor := range contributors {
+		ds.Contributors = append(ds.Contributors, dataset.Contributor{
+			ID:            contributor.ID,
+			FirstName:     contributor.FirstName,
+			MiddleInitial: valueOrEmpty(contributor.MiddleInitial),
+			LastName:      contributor.LastName,
+			Degree:        valueOrEmpty(contributor.Degree),
+			Email:         contributor.Email,
+			Orcid:         valueOrEmpty(contributor.Orcid),
+			UserID:        contributor.UserID,
+		})
+	}
+	return ds
+}
+
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,70 @@
+package preprocessor
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultFetchConcurrency = 4
+
+// fetchGroup runs fetch tasks on at most concurrency goroutines at a time. The first task to return an error
+// cancels the context passed to the other tasks, and any tasks not yet started are skipped. Wait returns that first error.
+type fetchGroup struct {
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	slots   chan struct{}
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+func newFetchGroup(ctx context.Context, concurrency int) *fetchGroup {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	groupCtx, cancel := context.WithCancel(ctx)
+	return &fetchGroup{
+		parent: ctx,
+		ctx:    groupCtx,
+		cancel: cancel,
+		slots:  make(chan struct{}, concurrency),
+	}
+}
+
+// Go blocks until a slot is free and then runs task on a new goroutine. If the group has already been
+// canceled, task is not run.
+func (g *fetchGroup) Go(task func(ctx context.Context) error) {
+	select {
+	case g.slots <- struct{}{}:
+	case <-g.ctx.Done():
+		return
+	}
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			<-g.slots
+			g.wg.Done()
+		}()
+		if g.ctx.Err() != nil {
+			return
+		}
+		if err := task(g.ctx); err != nil {
+			g.errOnce.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait waits for all started tasks to finish and returns the first error returned by a task. If no task failed but the
+// parent context was canceled, the parent context's error is returned, since some tasks may have been skipped.
+func (g *fetchGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	if g.err != nil {
+		return g.err
+	}
+	return g.parent.Err()
+}
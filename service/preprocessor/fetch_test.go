@@ -0,0 +1,76 @@
+package preprocessor
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchGroup_BoundsConcurrency(t *testing.T) {
+	concurrency := 3
+	group := newFetchGroup(context.Background(), concurrency)
+	var running, maxRunning, completed atomic.Int32
+	for i := 0; i < 20; i++ {
+		group.Go(func(ctx context.Context) error {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				previousMax := maxRunning.Load()
+				if current <= previousMax || maxRunning.CompareAndSwap(previousMax, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			completed.Add(1)
+			return nil
+		})
+	}
+	require.NoError(t, group.Wait())
+	assert.Equal(t, int32(20), completed.Load())
+	assert.LessOrEqual(t, maxRunning.Load(), int32(concurrency))
+}
+
+func TestFetchGroup_FirstErrorCancels(t *testing.T) {
+	group := newFetchGroup(context.Background(), 2)
+	fatal := errors.New("fatal")
+	var canceled, started atomic.Int32
+	running := make(chan struct{})
+	group.Go(func(ctx context.Context) error {
+		close(running)
+		<-ctx.Done()
+		canceled.Add(1)
+		return ctx.Err()
+	})
+	group.Go(func(ctx context.Context) error {
+		<-running
+		return fatal
+	})
+	for i := 0; i < 10; i++ {
+		group.Go(func(ctx context.Context) error {
+			started.Add(1)
+			return nil
+		})
+	}
+	assert.ErrorIs(t, group.Wait(), fatal)
+	assert.Equal(t, int32(1), canceled.Load())
+	assert.Zero(t, started.Load())
+}
+
+func TestFetchGroup_ParentCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	group := newFetchGroup(ctx, 2)
+	var started atomic.Int32
+	for i := 0; i < 5; i++ {
+		group.Go(func(ctx context.Context) error {
+			started.Add(1)
+			return nil
+		})
+	}
+	assert.ErrorIs(t, group.Wait(), context.Canceled)
+	assert.Zero(t, started.Load())
+}
@@ -0,0 +1,513 @@
+package preprocessor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// changeKind selects the map of run.Changes that the changes to the elements of one file are recorded in
+type changeKind int
+
+const (
+	recordChanges changeKind = iota
+	relationshipInstanceChanges
+	linkedPropertyInstanceChanges
+	proxyChanges
+)
+
+// changeTracker compares what an incremental run downloads with the previous snapshot, which is in the metadata
+// directory when the run starts, and collects the differences for changes.json. A file whose elements are unchanged
+// is left as it was instead of being rewritten. Files of the snapshot that the run neither writes nor keeps are
+// removed at the end, as for every run, and the elements in them are recorded as deleted.
+// A nil *changeTracker compares nothing and records nothing.
+type changeTracker struct {
+	metadataDirectory string
+	// baseline is false if there was no usable snapshot. Existing files are then not compared with, and everything
+	// downloaded is reported as added.
+	baseline bool
+	// previousSchema are the exported schema elements of the snapshot, with the properties of each model
+	previousSchema schema.Elements
+
+	mutex   sync.Mutex
+	changes run.Changes
+	// unchangedRecords holds, by model id, the ids of the records whose JSON is the same as in the snapshot
+	unchangedRecords map[string]map[string]bool
+}
+
+// startIncremental prepares an incremental run against the snapshot in m.PreviousMetadataDirectory. The snapshot is
+// only used as a baseline if a dataset scoped run of the same dataset and models completed it. If it is not the
+// metadata directory itself, its files are first copied into the metadata directory.
+// Must be called before the completion marker of the metadata directory is removed.
+func (m *MetadataPreProcessor) startIncremental(metadataDirectory string) (*changeTracker, error) {
+	c := &changeTracker{
+		metadataDirectory: metadataDirectory,
+		changes: run.Changes{
+			IntegrationID:           m.IntegrationID,
+			DatasetID:               m.DatasetID,
+			Records:                 map[string]run.ElementChanges{},
+			RelationshipInstances:   map[string]run.ElementChanges{},
+			LinkedPropertyInstances: map[string]run.ElementChanges{},
+			Proxies:                 map[string]run.ElementChanges{},
+		},
+	}
+	previous := m.usableSnapshot(m.PreviousMetadataDirectory)
+	if previous == nil {
+		logger.Warn("no usable snapshot to compare with; downloading everything and reporting it as added",
+			slog.String("previousMetadataDirectory", m.PreviousMetadataDirectory))
+		return c, nil
+	}
+	same, err := sameDirectory(m.PreviousMetadataDirectory, metadataDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		if err := copySnapshot(m.PreviousMetadataDirectory, metadataDirectory); err != nil {
+			return nil, err
+		}
+	}
+	previousSchema, err := m.readSnapshotSchema(metadataDirectory)
+	if err != nil {
+		return nil, err
+	}
+	c.baseline = true
+	c.previousSchema = previousSchema
+	c.changes.Previous = previous
+	logger.Info("comparing with snapshot",
+		slog.String("previousMetadataDirectory", m.PreviousMetadataDirectory),
+		slog.Time("previousCompletedAt", previous.CompletedAt))
+	return c, nil
+}
+
+// usableSnapshot returns the completion marker of the snapshot in previousDirectory, or nil if there is none or it
+// was not written by a dataset scoped run of m.DatasetID with the same selected models and proxy settings. With other
+// proxy settings, every proxies file would differ from the snapshot's, or be missing from it.
+func (m *MetadataPreProcessor) usableSnapshot(previousDirectory string) *run.Marker {
+	markerFilePath := filepath.Join(previousDirectory, paths.CompleteMarkerFilePath)
+	markerBytes, err := os.ReadFile(markerFilePath)
+	if err != nil {
+		logger.Warn("cannot read completion marker of snapshot", slog.String("path", markerFilePath), slog.Any("error", err))
+		return nil
+	}
+	var marker run.Marker
+	if err := json.Unmarshal(markerBytes, &marker); err != nil {
+		logger.Warn("cannot decode completion marker of snapshot", slog.String("path", markerFilePath), slog.Any("error", err))
+		return nil
+	}
+	previousModels := slices.Clone(marker.Models)
+	currentModels := slices.Clone(m.Models)
+	sort.Strings(previousModels)
+	sort.Strings(currentModels)
+	if marker.DatasetID != m.DatasetID || marker.Scope != string(DatasetScope) || !slices.Equal(previousModels, currentModels) {
+		logger.Warn("snapshot was written for another dataset, scope or model selection",
+			slog.String("path", markerFilePath),
+			slog.String("snapshotDatasetID", marker.DatasetID),
+			slog.String("snapshotScope", marker.Scope),
+			slog.Any("snapshotModels", marker.Models))
+		return nil
+	}
+	if marker.FetchProxies != m.FetchProxies || marker.ProxyAncestors != m.ProxyAncestors {
+		logger.Warn("snapshot was written with other proxy settings",
+			slog.String("path", markerFilePath),
+			slog.Bool("snapshotFetchProxies", marker.FetchProxies),
+			slog.Bool("snapshotProxyAncestors", marker.ProxyAncestors))
+		return nil
+	}
+	return &marker
+}
+
+// sameDirectory returns true if the two paths are the same directory
+func sameDirectory(directory1, directory2 string) (bool, error) {
+	info1, err := os.Stat(directory1)
+	if err != nil {
+		return false, fmt.Errorf("error reading directory %s: %w", directory1, err)
+	}
+	info2, err := os.Stat(directory2)
+	if err != nil {
+		return false, fmt.Errorf("error reading directory %s: %w", directory2, err)
+	}
+	return os.SameFile(info1, info2), nil
+}
+
+// copySnapshot copies the files of the snapshot in previousDirectory into metadataDirectory, except for the files
+// that describe the snapshot's run rather than the metadata
+func copySnapshot(previousDirectory string, metadataDirectory string) error {
+	logger.Info("copying snapshot", slog.String("from", previousDirectory), slog.String("to", metadataDirectory))
+	return filepath.WalkDir(previousDirectory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(previousDirectory, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(metadataDirectory, relativePath), 0755)
+		}
+		switch relativePath {
+		case paths.CompleteMarkerFilePath, paths.ManifestFilePath, paths.CheckpointFilePath, paths.ChangesFilePath:
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		return copyFile(path, filepath.Join(metadataDirectory, relativePath))
+	})
+}
+
+func copyFile(fromPath string, toPath string) (err error) {
+	from, err := os.Open(fromPath)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", fromPath, err)
+	}
+	defer from.Close()
+	to, err := createAtomic(toPath)
+	if err != nil {
+		return err
+	}
+	defer to.finish(&err)
+	if _, err := io.Copy(to, from); err != nil {
+		return fmt.Errorf("error copying %s to %s: %w", fromPath, toPath, err)
+	}
+	return nil
+}
+
+// readSnapshotSchema returns the schema elements of the snapshot in metadataDirectory that the run exports, the same
+// way WriteGraphSchema selects them
+func (m *MetadataPreProcessor) readSnapshotSchema(metadataDirectory string) (schema.Elements, error) {
+	graphSchemaFilePath := filepath.Join(metadataDirectory, paths.SchemaFilePath)
+	graphSchemaBytes, err := os.ReadFile(graphSchemaFilePath)
+	if err != nil {
+		return schema.Elements{}, fmt.Errorf("error reading graph schema of snapshot: %w", err)
+	}
+	var graphSchema []map[string]any
+	if err := json.Unmarshal(graphSchemaBytes, &graphSchema); err != nil {
+		return schema.Elements{}, fmt.Errorf("error decoding graph schema of snapshot %s: %w", graphSchemaFilePath, err)
+	}
+	var previous schema.Elements
+	modelIDs := map[string]bool{}
+	for _, schemaElementAsMap := range graphSchema {
+		schemaElement, err := schema.FromMap(schemaElementAsMap)
+		if err != nil {
+			return schema.Elements{}, err
+		}
+		switch e := schemaElement.(type) {
+		case *schema.Model:
+			if !m.exportsModel(e.Name) {
+				continue
+			}
+			propertiesFilePath := filepath.Join(metadataDirectory, paths.PropertiesFilePath(e.ID))
+			propertiesBytes, err := os.ReadFile(propertiesFilePath)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					// the model was deleted during the snapshot's run, so the snapshot has none of its files
+					continue
+				}
+				return schema.Elements{}, fmt.Errorf("error reading properties of snapshot: %w", err)
+			}
+			if err := json.Unmarshal(propertiesBytes, &e.Properties); err != nil {
+				return schema.Elements{}, fmt.Errorf("error decoding properties of snapshot %s: %w", propertiesFilePath, err)
+			}
+			previous.Models = append(previous.Models, *e)
+			modelIDs[e.ID] = true
+		case *schema.Relationship:
+			previous.Relationships = append(previous.Relationships, *e)
+		case *schema.LinkedProperty:
+			previous.LinkedProperties = append(previous.LinkedProperties, *e)
+		default:
+			return schema.Elements{}, fmt.Errorf("unknown schema element type: %T", e)
+		}
+	}
+	if len(m.Models) > 0 {
+		previous.Relationships = slices.DeleteFunc(previous.Relationships, func(relationship schema.Relationship) bool {
+			return !modelIDs[relationship.From] || !modelIDs[relationship.To]
+		})
+		previous.LinkedProperties = slices.DeleteFunc(previous.LinkedProperties, func(linkedProperty schema.LinkedProperty) bool {
+			return !modelIDs[linkedProperty.From] || !modelIDs[linkedProperty.To]
+		})
+	}
+	return previous, nil
+}
+
+// compareSchema records the models, relationships and linked properties added, changed or deleted since the snapshot
+func (c *changeTracker) compareSchema(current schema.Elements) error {
+	if c == nil {
+		return nil
+	}
+	var err error
+	if c.changes.Models, err = diffSchemaElements(c.previousSchema.Models, current.Models, func(model schema.Model) string {
+		return model.ID
+	}); err != nil {
+		return err
+	}
+	if c.changes.Relationships, err = diffSchemaElements(c.previousSchema.Relationships, current.Relationships, func(relationship schema.Relationship) string {
+		return relationship.ID
+	}); err != nil {
+		return err
+	}
+	if c.changes.LinkedProperties, err = diffSchemaElements(c.previousSchema.LinkedProperties, current.LinkedProperties, func(linkedProperty schema.LinkedProperty) string {
+		return linkedProperty.ID
+	}); err != nil {
+		return err
+	}
+	logger.Info("compared schema with snapshot",
+		slog.Any("models", c.changes.Models),
+		slog.Any("relationships", c.changes.Relationships),
+		slog.Any("linkedProperties", c.changes.LinkedProperties))
+	return nil
+}
+
+// diffSchemaElements compares the fingerprints of the previous and current elements with the same id
+func diffSchemaElements[E any](previous []E, current []E, id func(E) string) (run.ElementChanges, error) {
+	versions := func(elements []E) (map[string]string, error) {
+		byID := make(map[string]string, len(elements))
+		for _, element := range elements {
+			elementFingerprint, err := fingerprint(element)
+			if err != nil {
+				return nil, err
+			}
+			byID[id(element)] = elementFingerprint
+		}
+		return byID, nil
+	}
+	previousVersions, err := versions(previous)
+	if err != nil {
+		return run.ElementChanges{}, err
+	}
+	currentVersions, err := versions(current)
+	if err != nil {
+		return run.ElementChanges{}, err
+	}
+	return diffVersions(previousVersions, currentVersions), nil
+}
+
+// compareArray records the elements of the JSON array written to file that were added, changed or deleted since
+// the snapshot's version of the file at relativePath, under kind and id. If there are none, file is marked to keep
+// the existing file. Meant to be called once file is fully written, before it is finished.
+func (c *changeTracker) compareArray(file *atomicFile, relativePath string, kind changeKind, id string) error {
+	if c == nil {
+		return nil
+	}
+	current, err := readVersions(file.Name())
+	if err != nil {
+		return err
+	}
+	previous := map[string]string{}
+	existed := false
+	if c.baseline {
+		if previous, err = readVersions(filepath.Join(c.metadataDirectory, relativePath)); err == nil {
+			existed = true
+		} else if errors.Is(err, os.ErrNotExist) {
+			previous = map[string]string{}
+		} else {
+			return err
+		}
+	}
+	diff := diffVersions(previous, current)
+	if existed && diff.IsEmpty() {
+		file.keepExisting = true
+	}
+	c.record(kind, id, diff)
+	if kind == recordChanges {
+		unchanged := map[string]bool{}
+		for recordID, version := range current {
+			if previous[recordID] == version {
+				unchanged[recordID] = true
+			}
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.unchangedRecords == nil {
+			c.unchangedRecords = map[string]map[string]bool{}
+		}
+		c.unchangedRecords[id] = unchanged
+	}
+	return nil
+}
+
+// proxiesToFetch splits the given records of a model into those whose proxies must be looked up, and those whose
+// proxies are kept from the snapshot: the records whose JSON is unchanged and that have a proxies file in the snapshot.
+// Without a snapshot, every record's proxies are looked up.
+func (c *changeTracker) proxiesToFetch(modelID string, recordIDs []string) (fetch []string, kept []string, err error) {
+	if c == nil || !c.baseline {
+		return recordIDs, nil, nil
+	}
+	c.mutex.Lock()
+	unchanged := c.unchangedRecords[modelID]
+	c.mutex.Unlock()
+	for _, recordID := range recordIDs {
+		if unchanged[recordID] {
+			_, err := os.Stat(filepath.Join(c.metadataDirectory, paths.ProxyInstancesFilePath(modelID, recordID)))
+			if err == nil {
+				kept = append(kept, recordID)
+				continue
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, nil, fmt.Errorf("error reading proxy instances of snapshot: %w", err)
+			}
+		}
+		fetch = append(fetch, recordID)
+	}
+	return fetch, kept, nil
+}
+
+// compareProxies records whether the package proxies of the record were added or changed since the snapshot, and
+// returns true if they are unchanged, in which case the existing proxies file is kept
+func (c *changeTracker) compareProxies(modelID string, recordID string, proxies []any) (unchanged bool, err error) {
+	if c == nil {
+		return false, nil
+	}
+	relativePath := paths.ProxyInstancesFilePath(modelID, recordID)
+	diff := run.ElementChanges{Added: []string{recordID}}
+	if c.baseline {
+		existing, err := os.ReadFile(filepath.Join(c.metadataDirectory, relativePath))
+		if err == nil {
+			// the same encoding as WriteJSON
+			encoded, err := json.Marshal(proxies)
+			if err != nil {
+				return false, fmt.Errorf("error encoding proxy instances for %s: %w", recordID, err)
+			}
+			if bytes.Equal(existing, encoded) {
+				return true, nil
+			}
+			diff = run.ElementChanges{Changed: []string{recordID}}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("error reading proxy instances of snapshot: %w", err)
+		}
+	}
+	c.record(proxyChanges, modelID, diff)
+	return false, nil
+}
+
+// record adds diff to the changes recorded under kind and id
+func (c *changeTracker) record(kind changeKind, id string, diff run.ElementChanges) {
+	if diff.IsEmpty() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var changesByID map[string]run.ElementChanges
+	switch kind {
+	case recordChanges:
+		changesByID = c.changes.Records
+	case relationshipInstanceChanges:
+		changesByID = c.changes.RelationshipInstances
+	case linkedPropertyInstanceChanges:
+		changesByID = c.changes.LinkedPropertyInstances
+	case proxyChanges:
+		changesByID = c.changes.Proxies
+	}
+	recorded := changesByID[id]
+	recorded.Added = append(recorded.Added, diff.Added...)
+	recorded.Changed = append(recorded.Changed, diff.Changed...)
+	recorded.Deleted = append(recorded.Deleted, diff.Deleted...)
+	changesByID[id] = recorded
+}
+
+// recordRemoved records the elements of the file at relativePath as deleted before it is removed. Nothing is recorded
+// without a baseline, since the file is not from the snapshot.
+func (c *changeTracker) recordRemoved(relativePath string) {
+	if c == nil || !c.baseline {
+		return
+	}
+	directory, name := filepath.Split(relativePath)
+	directory = filepath.Clean(directory)
+	id := strings.TrimSuffix(name, filepath.Ext(name))
+	elementsKind := map[string]changeKind{
+		filepath.Join(paths.InstancesDirectory, paths.RecordsDirectory):          recordChanges,
+		filepath.Join(paths.InstancesDirectory, paths.RelationshipsDirectory):    relationshipInstanceChanges,
+		filepath.Join(paths.InstancesDirectory, paths.LinkedPropertiesDirectory): linkedPropertyInstanceChanges,
+	}
+	if kind, isElementsFile := elementsKind[directory]; isElementsFile {
+		elementIDs, err := readRecordIDs(filepath.Join(c.metadataDirectory, relativePath))
+		if err != nil {
+			logger.Warn("cannot read ids of deleted elements", slog.String("path", relativePath), slog.Any("error", err))
+			return
+		}
+		c.record(kind, id, run.ElementChanges{Deleted: elementIDs})
+	} else if filepath.Dir(directory) == filepath.Join(paths.InstancesDirectory, paths.ProxiesDirectory) {
+		c.record(proxyChanges, filepath.Base(directory), run.ElementChanges{Deleted: []string{id}})
+	}
+}
+
+// writeChanges writes the recorded changes to changes.json, with every list of ids sorted
+func (c *changeTracker) writeChanges(startedAt time.Time) error {
+	for _, changesByID := range []map[string]run.ElementChanges{c.changes.Records, c.changes.RelationshipInstances, c.changes.LinkedPropertyInstances, c.changes.Proxies} {
+		for id, changes := range changesByID {
+			changesByID[id] = sortedChanges(changes)
+		}
+	}
+	c.changes.StartedAt = startedAt.UTC()
+	c.changes.CompletedAt = time.Now().UTC()
+	changesFilePath := filepath.Join(c.metadataDirectory, paths.ChangesFilePath)
+	if _, err := WriteJSON(changesFilePath, c.changes); err != nil {
+		return fmt.Errorf("error writing changes: %w", err)
+	}
+	logger.Info("wrote changes",
+		slog.String("path", changesFilePath),
+		slog.Int("changedModels", len(c.changes.Records)),
+		slog.Int("changedRelationships", len(c.changes.RelationshipInstances)+len(c.changes.LinkedPropertyInstances)),
+		slog.Int("changedProxyModels", len(c.changes.Proxies)))
+	return nil
+}
+
+func sortedChanges(changes run.ElementChanges) run.ElementChanges {
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Deleted)
+	return changes
+}
+
+// diffVersions compares two maps from element id to a version of the element, such as a checksum
+func diffVersions(previous map[string]string, current map[string]string) run.ElementChanges {
+	var diff run.ElementChanges
+	for id, currentVersion := range current {
+		previousVersion, existed := previous[id]
+		if !existed {
+			diff.Added = append(diff.Added, id)
+		} else if previousVersion != currentVersion {
+			diff.Changed = append(diff.Changed, id)
+		}
+	}
+	for id := range previous {
+		if _, exists := current[id]; !exists {
+			diff.Deleted = append(diff.Deleted, id)
+		}
+	}
+	return sortedChanges(diff)
+}
+
+// readVersions returns the hex encoded SHA-256 checksum of each element of the JSON array in the file at filePath,
+// by element id. Elements are compacted first, so that only a change of content changes the checksum.
+func readVersions(filePath string) (map[string]string, error) {
+	versions := map[string]string{}
+	var compacted bytes.Buffer
+	err := forEachArrayElement(filePath, func(id string, element json.RawMessage) error {
+		compacted.Reset()
+		if err := json.Compact(&compacted, element); err != nil {
+			return fmt.Errorf("error compacting element %s of %s: %w", id, filePath, err)
+		}
+		checksum := sha256.Sum256(compacted.Bytes())
+		versions[id] = hex.EncodeToString(checksum[:])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
@@ -0,0 +1,56 @@
+package preprocessor
+
+import (
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffVersions(t *testing.T) {
+	previous := map[string]string{"kept": "1", "changed": "1", "deleted": "1"}
+	current := map[string]string{"kept": "1", "changed": "2", "added-2": "1", "added-1": "1"}
+	assert.Equal(t, run.ElementChanges{
+		Added:   []string{"added-1", "added-2"},
+		Changed: []string{"changed"},
+		Deleted: []string{"deleted"},
+	}, diffVersions(previous, current))
+	assert.True(t, diffVersions(previous, previous).IsEmpty())
+}
+
+func TestReadVersions_IgnoresFormatting(t *testing.T) {
+	directory := t.TempDir()
+	compactPath := filepath.Join(directory, "compact.json")
+	indentedPath := filepath.Join(directory, "indented.json")
+	require.NoError(t, os.WriteFile(compactPath, []byte(`[{"id":"a","value":1},{"id":"b","value":2}]`), 0644))
+	require.NoError(t, os.WriteFile(indentedPath, []byte("[\n  {\"id\": \"a\", \"value\": 1},\n  {\"id\": \"b\", \"value\": 3}\n]\n"), 0644))
+
+	compact, err := readVersions(compactPath)
+	require.NoError(t, err)
+	indented, err := readVersions(indentedPath)
+	require.NoError(t, err)
+	assert.Equal(t, compact["a"], indented["a"])
+	assert.NotEqual(t, compact["b"], indented["b"])
+}
+
+func TestChangeTracker_CompareSchema(t *testing.T) {
+	unchanged := schema.Model{Element: schema.Element{ID: "unchanged", Type: string(schema.ModelType), Name: "unchanged"}}
+	changed := schema.Model{Element: schema.Element{ID: "changed", Type: string(schema.ModelType), Name: "changed"}}
+	changedAfter := changed
+	changedAfter.Properties = []schema.Property{{ID: "property", Name: "added"}}
+	deleted := schema.Model{Element: schema.Element{ID: "deleted", Type: string(schema.ModelType), Name: "deleted"}}
+	added := schema.Model{Element: schema.Element{ID: "added", Type: string(schema.ModelType), Name: "added"}}
+	relationship := schema.Relationship{Element: schema.Element{ID: "relationship", Type: string(schema.RelationshipType)}, From: "unchanged", To: "changed"}
+
+	c := &changeTracker{baseline: true, previousSchema: schema.Elements{
+		Models:        []schema.Model{unchanged, changed, deleted},
+		Relationships: []schema.Relationship{relationship},
+	}}
+	require.NoError(t, c.compareSchema(schema.Elements{Models: []schema.Model{unchanged, changedAfter, added}}))
+	assert.Equal(t, run.ElementChanges{Added: []string{"added"}, Changed: []string{"changed"}, Deleted: []string{"deleted"}}, c.changes.Models)
+	assert.Equal(t, run.ElementChanges{Deleted: []string{"relationship"}}, c.changes.Relationships)
+	assert.True(t, c.changes.LinkedProperties.IsEmpty())
+}
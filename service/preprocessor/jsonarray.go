@@ -0,0 +1,90 @@
+package preprocessor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// JSONArrayWriter writes a JSON array to an underlying io.Writer one element at a time,
+// so that the whole array never needs to be held in memory.
+type JSONArrayWriter struct {
+	w       io.Writer
+	count   int
+	written int64
+}
+
+func NewJSONArrayWriter(w io.Writer) *JSONArrayWriter {
+	return &JSONArrayWriter{w: w}
+}
+
+// Append writes element as the next item in the array. element must be valid JSON.
+func (a *JSONArrayWriter) Append(element json.RawMessage) error {
+	separator := ","
+	if a.count == 0 {
+		separator = "["
+	}
+	if err := a.write([]byte(separator)); err != nil {
+		return err
+	}
+	if err := a.write(element); err != nil {
+		return err
+	}
+	a.count++
+	return nil
+}
+
+// Close writes the end of the array. It does not close the underlying io.Writer.
+func (a *JSONArrayWriter) Close() error {
+	if a.count == 0 {
+		return a.write([]byte("[]"))
+	}
+	return a.write([]byte("]"))
+}
+
+// Count returns the number of elements appended so far
+func (a *JSONArrayWriter) Count() int {
+	return a.count
+}
+
+// Written returns the number of bytes written so far
+func (a *JSONArrayWriter) Written() int64 {
+	return a.written
+}
+
+func (a *JSONArrayWriter) write(p []byte) error {
+	n, err := a.w.Write(p)
+	a.written += int64(n)
+	return err
+}
+
+// forEachArrayElement decodes the JSON array in the file at filePath one element at a time and calls handleElement
+// with each element and its id, so that the whole array never needs to be held in memory. Stops at the first error
+// returned by handleElement.
+func forEachArrayElement(filePath string, handleElement func(id string, element json.RawMessage) error) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", filePath, err)
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("error decoding %s: %w", filePath, err)
+	}
+	for decoder.More() {
+		var element json.RawMessage
+		if err := decoder.Decode(&element); err != nil {
+			return fmt.Errorf("error decoding %s: %w", filePath, err)
+		}
+		id, err := GetRawID(element)
+		if err != nil {
+			return fmt.Errorf("error getting element id in %s: %w", filePath, err)
+		}
+		if err := handleElement(id, element); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package preprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ParamsKey is the key in Integration.Params under which the pre-processor looks for its Params. Other keys
+// belong to other processors in the workflow and are ignored.
+const ParamsKey = "metadata"
+
+// ParamsVersion is the only version of Params this pre-processor understands
+const ParamsVersion = 1
+
+// JSONOutputFormat is the only supported output format
+const JSONOutputFormat = "json"
+
+// Params are the run options a workflow can set in Integration.Params under ParamsKey, for example
+//
+//	{"metadata": {"version": 1, "recordsBatchSize": 500, "models": ["subject", "sample"], "fetchProxies": false, "outputFormat": "json"}}
+//
+// A field that is not set keeps the value configured by the environment, or the default if the environment does not set it either.
+type Params struct {
+	Version int `json:"version"`
+	// RecordsBatchSize is the number of records requested per page
+	RecordsBatchSize *int `json:"recordsBatchSize,omitempty"`
+	// Models are the names of the models whose records are exported. Empty means all models.
+	Models []string `json:"models,omitempty"`
+	// FetchProxies is whether the package proxies of records are exported
+	FetchProxies *bool `json:"fetchProxies,omitempty"`
+	// OutputFormat must be JSONOutputFormat
+	OutputFormat *string `json:"outputFormat,omitempty"`
+	// Scope is "dataset" or "packages"
+	Scope *Scope `json:"scope,omitempty"`
+	// Hops is how far from the records linked to the integration's packages a "packages" scope export reaches
+	Hops *int `json:"hops,omitempty"`
+	// ProxyAncestors is whether each proxy package is written with the collections containing it
+	ProxyAncestors *bool `json:"proxyAncestors,omitempty"`
+}
+
+// InvalidParamsError lists every problem found in the params of an integration
+type InvalidParamsError struct {
+	// Problems has one entry per invalid field, in the form "<field>: <problem>"
+	Problems []string
+}
+
+func (e *InvalidParamsError) Error() string {
+	return fmt.Sprintf("invalid integration params under %q: %s", ParamsKey, strings.Join(e.Problems, "; "))
+}
+
+func (e *InvalidParamsError) add(field string, format string, args ...any) {
+	e.Problems = append(e.Problems, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+// ParseParams returns the Params found under ParamsKey in the given Integration.Params value, or nil if there are none.
+// If any field is invalid, the error is an *InvalidParamsError listing all of them.
+func ParseParams(integrationParams any) (*Params, error) {
+	if integrationParams == nil {
+		return nil, nil
+	}
+	paramsBytes, err := json.Marshal(integrationParams)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling integration params: %w", err)
+	}
+	var allParams map[string]json.RawMessage
+	if err := json.Unmarshal(paramsBytes, &allParams); err != nil {
+		return nil, &InvalidParamsError{Problems: []string{fmt.Sprintf("params: not a JSON object: %s", err)}}
+	}
+	rawParams, hasParams := allParams[ParamsKey]
+	if !hasParams || string(rawParams) == "null" {
+		return nil, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rawParams, &fields); err != nil {
+		return nil, &InvalidParamsError{Problems: []string{fmt.Sprintf("%s: not a JSON object: %s", ParamsKey, err)}}
+	}
+
+	// Decode field by field so that every invalid field is reported, not just the first
+	params := &Params{}
+	invalid := &InvalidParamsError{}
+	var unknownFields []string
+	undecodable := map[string]bool{}
+	for name, value := range fields {
+		var target any
+		switch name {
+		case "version":
+			target = &params.Version
+		case "recordsBatchSize":
+			target = &params.RecordsBatchSize
+		case "models":
+			target = &params.Models
+		case "fetchProxies":
+			target = &params.FetchProxies
+		case "outputFormat":
+			target = &params.OutputFormat
+		case "scope":
+			target = &params.Scope
+		case "hops":
+			target = &params.Hops
+		case "proxyAncestors":
+			target = &params.ProxyAncestors
+		default:
+			unknownFields = append(unknownFields, name)
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			invalid.add(name, "cannot decode %s: %s", value, err)
+			undecodable[name] = true
+		}
+	}
+	// map iteration order is random
+	sort.Strings(invalid.Problems)
+	sort.Strings(unknownFields)
+	for _, name := range unknownFields {
+		invalid.add(name, "unknown field")
+	}
+	_, hasVersion := fields["version"]
+	params.validate(hasVersion, undecodable, invalid)
+	if len(invalid.Problems) > 0 {
+		return nil, invalid
+	}
+	return params, nil
+}
+
+// validate adds the problems with the decoded fields to invalid. Fields in undecodable have already been reported.
+func (p *Params) validate(hasVersion bool, undecodable map[string]bool, invalid *InvalidParamsError) {
+	if !hasVersion {
+		invalid.add("version", "required; the current version is %d", ParamsVersion)
+	} else if !undecodable["version"] && p.Version != ParamsVersion {
+		invalid.add("version", "unsupported version %d; the current version is %d", p.Version, ParamsVersion)
+	}
+	if p.RecordsBatchSize != nil && *p.RecordsBatchSize < 1 {
+		invalid.add("recordsBatchSize", "must be at least 1; got %d", *p.RecordsBatchSize)
+	}
+	seenModels := map[string]bool{}
+	for i, model := range p.Models {
+		if len(strings.TrimSpace(model)) == 0 {
+			invalid.add(fmt.Sprintf("models[%d]", i), "model name is empty")
+		} else if seenModels[model] {
+			invalid.add(fmt.Sprintf("models[%d]", i), "duplicate model name %q", model)
+		}
+		seenModels[model] = true
+	}
+	if p.OutputFormat != nil && *p.OutputFormat != JSONOutputFormat {
+		invalid.add("outputFormat", "unsupported format %q; only %q is supported", *p.OutputFormat, JSONOutputFormat)
+	}
+	if p.Scope != nil && *p.Scope != DatasetScope && *p.Scope != PackagesScope {
+		invalid.add("scope", "unsupported scope %q; must be %q or %q", *p.Scope, DatasetScope, PackagesScope)
+	}
+	if p.Hops != nil && *p.Hops < 0 {
+		invalid.add("hops", "must be at least 0; got %d", *p.Hops)
+	}
+}
+
+// ApplyEnv overrides the defaults set by NewMetadataPreProcessor with the settings configured by the optional
+// RECORDS_BATCH_SIZE, FETCH_CONCURRENCY, PROXY_MODE, MODELS, FETCH_PROXIES, OUTPUT_FORMAT, SCOPE, SCOPE_HOPS, PROXY_ANCESTORS, RESUME, and PREVIOUS_METADATA_DIR environment variables.
+// Params applied later with ApplyParams take precedence over these.
+func (m *MetadataPreProcessor) ApplyEnv() error {
+	recordsBatchSize, err := LookupIntEnvVar("RECORDS_BATCH_SIZE", m.RecordsBatchSize)
+	if err != nil {
+		return err
+	}
+	if recordsBatchSize < 1 {
+		return fmt.Errorf("RECORDS_BATCH_SIZE must be at least 1; got %d", recordsBatchSize)
+	}
+	fetchConcurrency, err := LookupIntEnvVar("FETCH_CONCURRENCY", m.FetchConcurrency)
+	if err != nil {
+		return err
+	}
+	if fetchConcurrency < 1 {
+		return fmt.Errorf("FETCH_CONCURRENCY must be at least 1; got %d", fetchConcurrency)
+	}
+	proxyMode, err := ProxyModeFromEnv()
+	if err != nil {
+		return err
+	}
+	fetchProxies, err := LookupBoolEnvVar("FETCH_PROXIES", m.FetchProxies)
+	if err != nil {
+		return err
+	}
+	outputFormat := m.OutputFormat
+	if value := os.Getenv("OUTPUT_FORMAT"); len(value) > 0 {
+		if value != JSONOutputFormat {
+			return fmt.Errorf("OUTPUT_FORMAT value %q is not supported; only %q is supported", value, JSONOutputFormat)
+		}
+		outputFormat = value
+	}
+	scope, err := ScopeFromEnv()
+	if err != nil {
+		return err
+	}
+	scopeHops, err := LookupIntEnvVar("SCOPE_HOPS", m.ScopeHops)
+	if err != nil {
+		return err
+	}
+	if scopeHops < 0 {
+		return fmt.Errorf("SCOPE_HOPS must be at least 0; got %d", scopeHops)
+	}
+	proxyAncestors, err := LookupBoolEnvVar("PROXY_ANCESTORS", m.ProxyAncestors)
+	if err != nil {
+		return err
+	}
+	resume, err := LookupBoolEnvVar("RESUME", m.Resume)
+	if err != nil {
+		return err
+	}
+	previousMetadataDirectory := m.PreviousMetadataDirectory
+	if value := os.Getenv("PREVIOUS_METADATA_DIR"); len(value) > 0 {
+		previousMetadataDirectory = value
+	}
+	var models []string
+	for _, model := range strings.Split(os.Getenv("MODELS"), ",") {
+		if model = strings.TrimSpace(model); len(model) > 0 {
+			models = append(models, model)
+		}
+	}
+	m.RecordsBatchSize = recordsBatchSize
+	m.FetchConcurrency = fetchConcurrency
+	m.ProxyMode = proxyMode
+	m.FetchProxies = fetchProxies
+	m.OutputFormat = outputFormat
+	m.Scope = scope
+	m.ScopeHops = scopeHops
+	m.ProxyAncestors = proxyAncestors
+	m.Resume = resume
+	m.PreviousMetadataDirectory = previousMetadataDirectory
+	if len(models) > 0 {
+		m.Models = models
+	}
+	return nil
+}
+
+// ApplyParams overrides the settings configured by the environment with those set in params. A nil params changes nothing.
+func (m *MetadataPreProcessor) ApplyParams(params *Params) {
+	if params == nil {
+		return
+	}
+	if params.RecordsBatchSize != nil {
+		m.RecordsBatchSize = *params.RecordsBatchSize
+	}
+	if len(params.Models) > 0 {
+		m.Models = params.Models
+	}
+	if params.FetchProxies != nil {
+		m.FetchProxies = *params.FetchProxies
+	}
+	if params.OutputFormat != nil {
+		m.OutputFormat = *params.OutputFormat
+	}
+	if params.Scope != nil {
+		m.Scope = *params.Scope
+	}
+	if params.Hops != nil {
+		m.ScopeHops = *params.Hops
+	}
+	if params.ProxyAncestors != nil {
+		m.ProxyAncestors = *params.ProxyAncestors
+	}
+	logger.Info("applied integration params",
+		slog.Int("recordsBatchSize", m.RecordsBatchSize),
+		slog.Any("models", m.Models),
+		slog.Bool("fetchProxies", m.FetchProxies),
+		slog.String("outputFormat", m.OutputFormat),
+		slog.String("scope", string(m.Scope)),
+		slog.Int("scopeHops", m.ScopeHops),
+		slog.Bool("proxyAncestors", m.ProxyAncestors))
+}
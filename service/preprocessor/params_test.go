@@ -0,0 +1,135 @@
+package preprocessor
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestParseParams(t *testing.T) {
+	batchSize := 500
+	fetchProxies := false
+	jsonFormat := JSONOutputFormat
+	for scenario, tt := range map[string]struct {
+		integrationParams any
+		expected          *Params
+	}{
+		"nil params":           {integrationParams: nil, expected: nil},
+		"no metadata params":   {integrationParams: map[string]any{"other": 1}, expected: nil},
+		"null metadata params": {integrationParams: map[string]any{ParamsKey: nil}, expected: nil},
+		"version only": {
+			integrationParams: map[string]any{ParamsKey: map[string]any{"version": 1}},
+			expected:          &Params{Version: 1},
+		},
+		"all fields": {
+			integrationParams: map[string]any{ParamsKey: map[string]any{
+				"version":          1,
+				"recordsBatchSize": 500,
+				"models":           []any{"subject", "sample"},
+				"fetchProxies":     false,
+				"outputFormat":     "json",
+			}},
+			expected: &Params{
+				Version:          1,
+				RecordsBatchSize: &batchSize,
+				Models:           []string{"subject", "sample"},
+				FetchProxies:     &fetchProxies,
+				OutputFormat:     &jsonFormat,
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			params, err := ParseParams(tt.integrationParams)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, params)
+		})
+	}
+}
+
+func TestParseParams_Invalid(t *testing.T) {
+	for scenario, tt := range map[string]struct {
+		integrationParams any
+		// expectedProblems are prefixes of the expected problems, since json error messages vary between Go versions
+		expectedProblems []string
+	}{
+		"not an object": {
+			integrationParams: []any{1},
+			expectedProblems:  []string{"params: not a JSON object: "},
+		},
+		"missing version": {
+			integrationParams: map[string]any{ParamsKey: map[string]any{}},
+			expectedProblems:  []string{"version: required; the current version is 1"},
+		},
+		"every field invalid": {
+			integrationParams: map[string]any{ParamsKey: map[string]any{
+				"version":          2,
+				"recordsBatchSize": -1,
+				"models":           []any{"subject", "", "subject"},
+				"fetchProxies":     "yes",
+				"outputFormat":     "csv",
+				"recordBatchSize":  10,
+			}},
+			expectedProblems: []string{
+				`fetchProxies: cannot decode "yes": `,
+				"recordBatchSize: unknown field",
+				"version: unsupported version 2; the current version is 1",
+				"recordsBatchSize: must be at least 1; got -1",
+				"models[1]: model name is empty",
+				`models[2]: duplicate model name "subject"`,
+				`outputFormat: unsupported format "csv"; only "json" is supported`,
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := ParseParams(tt.integrationParams)
+			var invalidParams *InvalidParamsError
+			require.ErrorAs(t, err, &invalidParams)
+			if assert.Len(t, invalidParams.Problems, len(tt.expectedProblems)) {
+				for i, expectedProblem := range tt.expectedProblems {
+					assert.True(t, strings.HasPrefix(invalidParams.Problems[i], expectedProblem), "expected problem %q to start with %q", invalidParams.Problems[i], expectedProblem)
+					assert.Contains(t, err.Error(), expectedProblem)
+				}
+			}
+		})
+	}
+}
+
+// TestApplyParams_Precedence checks that integration params take precedence over env vars, which take precedence over defaults
+func TestApplyParams_Precedence(t *testing.T) {
+	t.Setenv("RECORDS_BATCH_SIZE", "200")
+	t.Setenv("FETCH_PROXIES", "false")
+	t.Setenv("MODELS", "subject, sample")
+	t.Setenv("FETCH_CONCURRENCY", "")
+	t.Setenv("PROXY_MODE", "")
+	t.Setenv("OUTPUT_FORMAT", "")
+	t.Setenv("RESUME", "true")
+	t.Setenv("PREVIOUS_METADATA_DIR", "/previous/metadata")
+
+	metadataPP, err := NewMetadataPreProcessor(uuid.NewString(), t.TempDir(), t.TempDir(), uuid.NewString(), "http://localhost", "http://localhost", 0)
+	require.NoError(t, err)
+	require.NoError(t, metadataPP.ApplyEnv())
+	assert.Equal(t, 200, metadataPP.RecordsBatchSize)
+	assert.False(t, metadataPP.FetchProxies)
+	assert.Equal(t, []string{"subject", "sample"}, metadataPP.Models)
+	assert.Equal(t, defaultFetchConcurrency, metadataPP.FetchConcurrency)
+	assert.Equal(t, JSONOutputFormat, metadataPP.OutputFormat)
+	assert.True(t, metadataPP.Resume)
+	assert.Equal(t, "/previous/metadata", metadataPP.PreviousMetadataDirectory)
+
+	params, err := ParseParams(map[string]any{ParamsKey: map[string]any{"version": 1, "recordsBatchSize": 50, "models": []string{"subject"}}})
+	require.NoError(t, err)
+	metadataPP.ApplyParams(params)
+	assert.Equal(t, 50, metadataPP.RecordsBatchSize)
+	assert.Equal(t, []string{"subject"}, metadataPP.Models)
+	// not set in params, so the env value is kept
+	assert.False(t, metadataPP.FetchProxies)
+}
+
+func TestApplyEnv_Invalid(t *testing.T) {
+	t.Setenv("RECORDS_BATCH_SIZE", "0")
+	metadataPP, err := NewMetadataPreProcessor(uuid.NewString(), t.TempDir(), t.TempDir(), uuid.NewString(), "http://localhost", "http://localhost", 0)
+	require.NoError(t, err)
+	assert.ErrorContains(t, metadataPP.ApplyEnv(), "RECORDS_BATCH_SIZE must be at least 1")
+}
@@ -1,9 +1,13 @@
 package preprocessor
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
 	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
 	"github.com/pennsieve/processor-pre-metadata/client/paths"
 	"github.com/pennsieve/processor-pre-metadata/service/logging"
@@ -14,6 +18,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var logger = logging.PackageLogger("preprocessor")
@@ -27,6 +36,34 @@ type MetadataPreProcessor struct {
 	OutputDirectory  string
 	Pennsieve        *pennsieve.Session
 	RecordsBatchSize int
+	// FetchConcurrency is the most downloads WriteInstances runs at once
+	FetchConcurrency int
+	ProxyMode        ProxyMode
+	// Models are the names of the models to export. Empty means all models.
+	Models       []string
+	FetchProxies bool
+	OutputFormat string
+	Scope        Scope
+	// ScopeHops is how far from the records linked to PackageIDs a PackagesScope export reaches
+	ScopeHops int
+	// PackageIDs are the node ids of the integration's packages
+	PackageIDs []string
+	// ProxyAncestors is whether each proxy package is written with the collections containing it
+	ProxyAncestors bool
+	// Resume is whether Run skips the work an earlier, failed run recorded as complete in the checkpoint journal
+	Resume bool
+	// PreviousMetadataDirectory is the metadata directory of an earlier run that Run compares with, only rewriting the
+	// files that changed since and summarizing the changes in changes.json. Empty means a full run.
+	PreviousMetadataDirectory string
+
+	ancestorsMutex     sync.Mutex
+	ancestorsByPackage map[string]any
+	// checkpoint is the journal of the current run, or nil when running outside of Run or with PackagesScope
+	checkpoint *checkpoint
+	// changes compares the current run with PreviousMetadataDirectory, or is nil if the run is not incremental
+	changes *changeTracker
+	// written are the property and instance files written or kept by the current run, or nil when running outside of Run
+	written *writtenFiles
 }
 
 func NewMetadataPreProcessor(integrationID string,
@@ -35,18 +72,29 @@ func NewMetadataPreProcessor(integrationID string,
 	sessionToken string,
 	apiHost string,
 	api2Host string,
-	recordsBatchSize int) *MetadataPreProcessor {
+	recordsBatchSize int,
+	sessionOptions ...pennsieve.SessionOption) (*MetadataPreProcessor, error) {
 	recordsBatch := recordsBatchSize
 	if recordsBatch == 0 {
 		recordsBatch = defaultRecordsBatchSize
 	}
+	session, err := pennsieve.NewSession(sessionToken, apiHost, api2Host, sessionOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Pennsieve session: %w", err)
+	}
 	return &MetadataPreProcessor{
 		IntegrationID:    integrationID,
 		InputDirectory:   inputDirectory,
 		OutputDirectory:  outputDirectory,
-		Pennsieve:        pennsieve.NewSession(sessionToken, apiHost, api2Host),
+		Pennsieve:        session,
 		RecordsBatchSize: recordsBatch,
-	}
+		FetchConcurrency: defaultFetchConcurrency,
+		ProxyMode:        BulkProxyMode,
+		FetchProxies:     true,
+		OutputFormat:     JSONOutputFormat,
+		Scope:            DatasetScope,
+		ScopeHops:        defaultScopeHops,
+	}, nil
 }
 
 func FromEnv() (*MetadataPreProcessor, error) {
@@ -62,61 +110,220 @@ func FromEnv() (*MetadataPreProcessor, error) {
 	if err != nil {
 		return nil, err
 	}
-	sessionToken, err := LookupRequiredEnvVar("SESSION_TOKEN")
+	apiHost, err := LookupRequiredEnvVar("PENNSIEVE_API_HOST")
 	if err != nil {
 		return nil, err
 	}
-	apiHost, err := LookupRequiredEnvVar("PENNSIEVE_API_HOST")
+	api2Host, err := LookupRequiredEnvVar("PENNSIEVE_API_HOST2")
 	if err != nil {
 		return nil, err
 	}
-	api2Host, err := LookupRequiredEnvVar("PENNSIEVE_API_HOST2")
+	sessionToken, authOptions, err := AuthFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	sessionOptions, err := SessionOptionsFromEnv(integrationID)
+	if err != nil {
+		return nil, err
+	}
+	sessionOptions = append(sessionOptions, authOptions...)
+	processor, err := NewMetadataPreProcessor(integrationID, inputDirectory, outputDirectory, sessionToken, apiHost, api2Host, 0, sessionOptions...)
 	if err != nil {
 		return nil, err
 	}
-	return NewMetadataPreProcessor(integrationID, inputDirectory, outputDirectory, sessionToken, apiHost, api2Host, 0), nil
+	if err := processor.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return processor, nil
 }
 
+// WithDatasetID makes Run export the given dataset instead of the integration's. Run still looks up the integration,
+// and applies its params and package ids.
 func (m *MetadataPreProcessor) WithDatasetID(datasetID string) *MetadataPreProcessor {
 	m.DatasetID = datasetID
 	return m
 }
 
-func (m *MetadataPreProcessor) Run() error {
+// Run downloads the dataset's metadata into the metadata directory. If ctx is canceled or times out, in-flight
+// requests are abandoned and any file that was only partially written is removed.
+func (m *MetadataPreProcessor) Run(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		logger.Info("run summary",
+			slog.String("datasetID", m.DatasetID),
+			slog.Duration("elapsed", time.Since(start)),
+			slog.Duration("rateLimitWait", m.Pennsieve.RateLimitWait()))
+	}()
+	// get integration info. Its params and package ids apply even if the dataset id was given.
+	logger.Info("looking up integration", slog.String("integrationID", m.IntegrationID))
+	integration, err := m.Pennsieve.GetIntegration(ctx, m.IntegrationID)
+	if err != nil {
+		return err
+	}
+	params, err := ParseParams(integration.Params)
+	if err != nil {
+		return err
+	}
+	m.ApplyParams(params)
+	m.PackageIDs = integration.PackageIDs
 	if len(m.DatasetID) == 0 {
-		// get integration info
-		logger.Info("looking up integration", slog.String("integrationID", m.IntegrationID))
-
-		integration, err := m.Pennsieve.GetIntegration(m.IntegrationID)
-		if err != nil {
-			return err
-		}
-		datasetID := integration.DatasetNodeID
-		m.DatasetID = datasetID
+		m.DatasetID = integration.DatasetNodeID
+	} else if m.DatasetID != integration.DatasetNodeID {
+		logger.Warn("dataset id given is not the integration's; using the one given",
+			slog.String("datasetID", m.DatasetID),
+			slog.String("integrationDatasetID", integration.DatasetNodeID))
+	}
+	if m.Scope == PackagesScope && len(m.PackageIDs) == 0 {
+		return fmt.Errorf("scope %q requires package ids, but the integration has none", PackagesScope)
 	}
 	logger.Info("getting metadata for dataset", slog.String("datasetID", m.DatasetID))
 	if err := m.MkDirectories(); err != nil {
 		return err
 	}
 	metadataPath := m.MetadataPath()
-	schemaElements, err := m.WriteGraphSchema(metadataPath, m.DatasetID)
+	m.written = newWrittenFiles()
+	defer func() {
+		m.written = nil
+	}()
+	if len(m.PreviousMetadataDirectory) > 0 {
+		if m.Scope == PackagesScope {
+			logger.Warn("incremental runs are not supported for scoped exports; running in full", slog.String("scope", string(m.Scope)))
+		} else {
+			// before the marker is removed, since it may be the snapshot's
+			changes, err := m.startIncremental(metadataPath)
+			if err != nil {
+				return err
+			}
+			m.changes = changes
+			defer func() {
+				m.changes = nil
+			}()
+		}
+	}
+	// a marker left by an earlier run must not vouch for the files this run is about to replace, and changes left by
+	// an earlier incremental run do not describe them
+	if err := removeIfExists(filepath.Join(metadataPath, paths.CompleteMarkerFilePath)); err != nil {
+		return err
+	}
+	if err := removeIfExists(filepath.Join(metadataPath, paths.ChangesFilePath)); err != nil {
+		return err
+	}
+	if m.Scope == PackagesScope {
+		if m.Resume {
+			logger.Warn("resuming is not supported for scoped exports; running from scratch", slog.String("scope", string(m.Scope)))
+		}
+		if err := removeIfExists(filepath.Join(metadataPath, paths.CheckpointFilePath)); err != nil {
+			return err
+		}
+	} else {
+		resume := m.Resume
+		if resume && m.changes != nil {
+			// a resumed unit would be skipped without being compared with the snapshot
+			logger.Warn("resuming is not supported for incremental runs; running from scratch")
+			resume = false
+		}
+		checkpoint, err := openCheckpoint(metadataPath, m.DatasetID, resume, m.written)
+		if err != nil {
+			return err
+		}
+		m.checkpoint = checkpoint
+		defer func() {
+			m.checkpoint.close()
+			m.checkpoint = nil
+		}()
+	}
+	if err := m.WriteDataset(ctx, metadataPath, m.DatasetID); err != nil {
+		return err
+	}
+	schemaElements, err := m.WriteGraphSchema(ctx, metadataPath, m.DatasetID)
 	if err != nil {
 		return err
 	}
-	if err := m.WriteInstances(metadataPath, m.DatasetID, schemaElements); err != nil {
+	if err := m.changes.compareSchema(schemaElements); err != nil {
+		return err
+	}
+	if m.Scope == PackagesScope {
+		if err := m.WriteScopedInstances(ctx, metadataPath, m.DatasetID, schemaElements); err != nil {
+			return err
+		}
+	} else if err := m.WriteInstances(ctx, metadataPath, m.DatasetID, schemaElements); err != nil {
+		return err
+	}
+	if err := m.written.removeOthers(metadataPath, m.changes.recordRemoved); err != nil {
 		return err
 	}
+	if m.changes != nil {
+		if err := m.changes.writeChanges(start); err != nil {
+			return err
+		}
+	}
+	if err := m.WriteManifest(metadataPath, start); err != nil {
+		return err
+	}
+	if err := m.WriteCompleteMarker(metadataPath, start); err != nil {
+		return err
+	}
+	m.checkpoint.remove()
 	return nil
 }
 
-func (m *MetadataPreProcessor) WriteGraphSchema(metadataDirectory string, datasetID string) (schema.Elements, error) {
-	// These don't need to be returned in the schema elements. Most will also appear
-	// in the graph schema below and they will be returned from there. Only one that doesn't is the special package proxy
-	// relationship which does not need to be included.
-	if err := m.WriteRelationshipSchemas(metadataDirectory, datasetID); err != nil {
+// WriteManifest describes every file in the metadata directory in its manifest. Meant to be called once every other
+// file has been written.
+func (m *MetadataPreProcessor) WriteManifest(metadataDirectory string, startedAt time.Time) error {
+	files, err := run.DescribeDirectory(metadataDirectory)
+	if err != nil {
+		return err
+	}
+	manifest := run.Manifest{
+		IntegrationID:    m.IntegrationID,
+		DatasetID:        m.DatasetID,
+		APIHost:          m.Pennsieve.APIHost,
+		API2Host:         m.Pennsieve.API2Host,
+		ProcessorVersion: Version,
+		StartedAt:        startedAt.UTC(),
+		CompletedAt:      time.Now().UTC(),
+		Files:            files,
+	}
+	manifestFilePath := filepath.Join(metadataDirectory, paths.ManifestFilePath)
+	if _, err := WriteJSON(manifestFilePath, manifest); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	logger.Info("wrote manifest", slog.String("path", manifestFilePath), slog.Int("files", len(files)))
+	return nil
+}
+
+// WriteCompleteMarker writes the completion marker, which tells readers that every other file of the run was written.
+// It must be the last file a run writes.
+func (m *MetadataPreProcessor) WriteCompleteMarker(metadataDirectory string, startedAt time.Time) error {
+	markerFilePath := filepath.Join(metadataDirectory, paths.CompleteMarkerFilePath)
+	marker := run.Marker{
+		IntegrationID:  m.IntegrationID,
+		DatasetID:      m.DatasetID,
+		Scope:          string(m.Scope),
+		Models:         m.Models,
+		FetchProxies:   m.FetchProxies,
+		ProxyAncestors: m.ProxyAncestors,
+		StartedAt:      startedAt.UTC(),
+		CompletedAt:    time.Now().UTC(),
+	}
+	if _, err := WriteJSON(markerFilePath, marker); err != nil {
+		return fmt.Errorf("error writing completion marker: %w", err)
+	}
+	logger.Info("wrote completion marker", slog.String("path", markerFilePath))
+	return nil
+}
+
+// WriteGraphSchema writes the relationship and graph schemas and the properties of every exported model, and returns the
+// exported schema elements. The schema files leave out the models that are not selected or were deleted during the
+// run, together with the relationships and linked properties from or to them.
+func (m *MetadataPreProcessor) WriteGraphSchema(ctx context.Context, metadataDirectory string, datasetID string) (schema.Elements, error) {
+	// Most of the relationship schemas will also appear in the graph schema below and they will be returned from there.
+	// Only one that doesn't is the special package proxy relationship which is returned as the Proxy element.
+	proxy, err := m.WriteRelationshipSchemas(ctx, metadataDirectory, datasetID)
+	if err != nil {
 		return schema.Elements{}, err
 	}
-	res, err := m.Pennsieve.GetGraphSchema(datasetID)
+	res, err := m.Pennsieve.GetGraphSchema(ctx, datasetID)
 	if err != nil {
 		return schema.Elements{}, err
 	}
@@ -129,7 +336,8 @@ func (m *MetadataPreProcessor) WriteGraphSchema(metadataDirectory string, datase
 			slog.String("path", graphSchemaFilePath))
 	}
 
-	schemaElements := schema.Elements{}
+	schemaElements := schema.Elements{Proxy: proxy}
+	schemaModelNames := map[string]bool{}
 	for _, schemaElementAsMap := range graphSchema {
 		schemaElement, err := schema.FromMap(schemaElementAsMap)
 		if err != nil {
@@ -137,7 +345,17 @@ func (m *MetadataPreProcessor) WriteGraphSchema(metadataDirectory string, datase
 		}
 		switch e := schemaElement.(type) {
 		case *schema.Model:
-			if err := m.WriteProperties(metadataDirectory, datasetID, e); err != nil {
+			schemaModelNames[e.Name] = true
+			if !m.exportsModel(e.Name) {
+				e.Logger(logger).Info("model not selected for export. Skipping it")
+				continue
+			}
+			if err := m.WriteProperties(ctx, metadataDirectory, datasetID, e); err != nil {
+				if errors.Is(err, pennsieve.ErrNotFound) {
+					e.Logger(logger).Warn("model not found; it may have been deleted during the run. Skipping it",
+						slog.Any("error", err))
+					continue
+				}
 				return schema.Elements{}, err
 			}
 			schemaElements.Models = append(schemaElements.Models, *e)
@@ -149,30 +367,75 @@ func (m *MetadataPreProcessor) WriteGraphSchema(metadataDirectory string, datase
 			return schema.Elements{}, fmt.Errorf("unknown schema element type: %T", e)
 		}
 	}
+	var missingModels []string
+	for _, modelName := range m.Models {
+		if !schemaModelNames[modelName] {
+			missingModels = append(missingModels, modelName)
+		}
+	}
+	if len(missingModels) > 0 {
+		return schema.Elements{}, fmt.Errorf("selected models not found in dataset %s: %s", m.DatasetID, strings.Join(missingModels, ", "))
+	}
+	if len(schemaElements.Models) < len(schemaModelNames) {
+		// readers must not see the models that were not exported, or the relationships and linked properties from or to them
+		schemaElements = exportedElements(schemaElements)
+		if err := rewriteSchema(metadataDirectory, schemaElements); err != nil {
+			return schema.Elements{}, err
+		}
+	}
 	return schemaElements, nil
 }
 
+func (m *MetadataPreProcessor) exportsModel(modelName string) bool {
+	return len(m.Models) == 0 || slices.Contains(m.Models, modelName)
+}
+
+// exportedElements removes the relationships and linked properties of schemaElements that do not have both ends in
+// its models
+func exportedElements(schemaElements schema.Elements) schema.Elements {
+	exportedModelIDs := map[string]bool{}
+	for _, model := range schemaElements.Models {
+		exportedModelIDs[model.ID] = true
+	}
+	exported := schema.Elements{Models: schemaElements.Models, Proxy: schemaElements.Proxy}
+	for _, relationship := range schemaElements.Relationships {
+		if exportedModelIDs[relationship.From] && exportedModelIDs[relationship.To] {
+			exported.Relationships = append(exported.Relationships, relationship)
+		}
+	}
+	for _, linkedProperty := range schemaElements.LinkedProperties {
+		if exportedModelIDs[linkedProperty.From] && exportedModelIDs[linkedProperty.To] {
+			exported.LinkedProperties = append(exported.LinkedProperties, linkedProperty)
+		}
+	}
+	return exported
+}
+
 // WriteRelationshipSchemas is a hack to get the special `belongs_to` package proxy relationship schema which is not included in graphSchemaFilePath.
 // The other relationship schemas retrieved will be duplicates of the info in graphSchemaFilePath.
-func (m *MetadataPreProcessor) WriteRelationshipSchemas(metadataDirectory string, datasetID string) error {
-	res, err := m.Pennsieve.GetRelationshipSchemas(datasetID)
+// Returns the proxy relationship, or nil if the dataset does not have one.
+func (m *MetadataPreProcessor) WriteRelationshipSchemas(ctx context.Context, metadataDirectory string, datasetID string) (*schema.NullableRelationship, error) {
+	res, err := m.Pennsieve.GetRelationshipSchemas(ctx, datasetID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	relationshipSchemaFilePath := filepath.Join(metadataDirectory, paths.RelationshipSchemasFilePath)
-	writtenCount, err := WriteResponse(res, relationshipSchemaFilePath)
-	if err != nil {
-		return fmt.Errorf("error writing/decoding relationship schemas: %w", err)
+	var relationships []schema.NullableRelationship
+	if err := WriteAndDecodeResponse(res, relationshipSchemaFilePath, &relationships); err != nil {
+		return nil, fmt.Errorf("error writing/decoding relationship schemas: %w", err)
 	}
 	logger.Info("wrote relationship schemas",
 		slog.String("path", relationshipSchemaFilePath),
-		slog.Int64("size", writtenCount))
-	return nil
+		slog.Int("count", len(relationships)))
+	if proxyIndex := slices.IndexFunc(relationships, schema.IsProxy); proxyIndex != -1 {
+		return &relationships[proxyIndex], nil
+	}
+	return nil, nil
 }
 
-func (m *MetadataPreProcessor) WriteProperties(metadataDirectory string, datasetID string, model *schema.Model) error {
+func (m *MetadataPreProcessor) WriteProperties(ctx context.Context, metadataDirectory string, datasetID string, model *schema.Model) error {
 	modelLogger := model.Logger(logger)
-	if propRes, err := m.Pennsieve.GetProperties(datasetID, model.ID); err != nil {
+	if propRes, err := m.Pennsieve.GetProperties(ctx, datasetID, model.ID); err != nil {
 		return fmt.Errorf("error getting model %s properties: %w", model.ID, err)
 	} else {
 		modelPropFilePath := filepath.Join(metadataDirectory, paths.PropertiesFilePath(model.ID))
@@ -183,108 +446,268 @@ func (m *MetadataPreProcessor) WriteProperties(metadataDirectory string, dataset
 				slog.String("path", modelPropFilePath))
 		}
 	}
+	m.written.add(paths.PropertiesFilePath(model.ID))
 	return nil
 }
 
-func (m *MetadataPreProcessor) WriteInstances(metadataDirectory string, datasetID string, schemaElements schema.Elements) error {
-	// Write the records and any package proxies
+// WriteInstances writes the records, package proxies, relationship instances, and linked property instances of the
+// given schema elements. Up to m.FetchConcurrency downloads run at once. Records and relationship and linked property
+// instances are fetched first, one worker per model or relationship, and then the proxies of every record written
+// according to m.ProxyMode.
+// Each of those units of work is recorded in the run's checkpoint journal once done, and skipped if an earlier run
+// being resumed already did it. When comparing with a snapshot, the proxies of records whose JSON is unchanged are kept
+// from the snapshot instead of being looked up again.
+// The first error cancels the remaining downloads.
+func (m *MetadataPreProcessor) WriteInstances(ctx context.Context, metadataDirectory string, datasetID string, schemaElements schema.Elements) error {
+	currentUnits := map[string]bool{}
 	for _, model := range schemaElements.Models {
-		modelLogger := model.Logger(logger)
-		recordRes, err := m.Pennsieve.GetAllRecords(datasetID, model.ID, m.RecordsBatchSize)
+		currentUnits[recordsUnit(model)] = true
+		currentUnits[proxiesUnit(model)] = true
+	}
+	for _, schemaRelationship := range schemaElements.Relationships {
+		currentUnits[relationshipUnit(schemaRelationship)] = true
+	}
+	for _, schemaLinkedProperty := range schemaElements.LinkedProperties {
+		currentUnits[linkedPropertyUnit(schemaLinkedProperty)] = true
+	}
+	if err := m.checkpoint.removeStale(currentUnits); err != nil {
+		return err
+	}
+
+	// recordIDs[i] is nil if schemaElements.Models[i] was deleted during the run
+	recordIDs := make([][]string, len(schemaElements.Models))
+	group := newFetchGroup(ctx, m.FetchConcurrency)
+	for i, model := range schemaElements.Models {
+		i, model := i, model
+		group.Go(func(ctx context.Context) error {
+			ids, err := m.writeRecordsUnit(ctx, metadataDirectory, datasetID, model)
+			if err != nil {
+				if errors.Is(err, pennsieve.ErrNotFound) {
+					model.Logger(logger).Warn("model not found when getting records; it may have been deleted during the run. Skipping it",
+						slog.Any("error", err))
+					return nil
+				}
+				return err
+			}
+			recordIDs[i] = ids
+			return nil
+		})
+	}
+	for _, schemaRelationship := range schemaElements.Relationships {
+		schemaRelationship := schemaRelationship
+		group.Go(func(ctx context.Context) error {
+			unit := relationshipUnit(schemaRelationship)
+			unitFingerprint, err := fingerprint(schemaRelationship)
+			if err != nil {
+				return err
+			}
+			if m.checkpoint.skip(unit, unitFingerprint) {
+				return nil
+			}
+			if err := m.WriteRelationshipInstances(ctx, metadataDirectory, datasetID, schemaRelationship); err != nil {
+				return err
+			}
+			return m.checkpoint.complete(unit, unitFingerprint, paths.RelationshipInstancesFilePath(schemaRelationship.ID))
+		})
+	}
+	for _, schemaLinkedProperty := range schemaElements.LinkedProperties {
+		schemaLinkedProperty := schemaLinkedProperty
+		group.Go(func(ctx context.Context) error {
+			unit := linkedPropertyUnit(schemaLinkedProperty)
+			unitFingerprint, err := fingerprint(schemaLinkedProperty)
+			if err != nil {
+				return err
+			}
+			if m.checkpoint.skip(unit, unitFingerprint) {
+				return nil
+			}
+			if err := m.WriteLinkedPropertyInstances(ctx, metadataDirectory, datasetID, schemaLinkedProperty); err != nil {
+				return err
+			}
+			return m.checkpoint.complete(unit, unitFingerprint, paths.LinkedPropertyInstancesFilePath(schemaLinkedProperty.ID))
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	if !m.FetchProxies {
+		logger.Info("fetching proxies is turned off; no proxy instances will be written")
+		return nil
+	}
+	// the proxies written for a model depend on its records, so they are redone if any of its records was added or removed
+	proxyElements := schemaElements
+	proxyElements.Models = nil
+	var proxyRecordIDs [][]string
+	var proxyFingerprints []string
+	for i, model := range schemaElements.Models {
+		unitFingerprint, err := fingerprint(schemaElements.Proxy, m.ProxyAncestors, recordIDs[i])
 		if err != nil {
 			return err
 		}
-		recordsFilePath := filepath.Join(metadataDirectory, paths.RecordsFilePath(model.ID))
-		recordsSz, err := WriteJSON(recordsFilePath, recordRes)
-		if err != nil {
-			return fmt.Errorf("error writing/decoding model %s records to %s: %w", model.ID, recordsFilePath, err)
+		if m.checkpoint.skip(proxiesUnit(model), unitFingerprint) {
+			continue
 		}
-		modelLogger.Info("wrote model records", slog.String("path", recordsFilePath),
-			slog.Int64("size", recordsSz))
-		if err := m.WriteProxies(metadataDirectory, datasetID, model.ID, recordRes); err != nil {
+		fetchIDs, keptIDs, err := m.changes.proxiesToFetch(model.ID, recordIDs[i])
+		if err != nil {
 			return err
 		}
+		if len(keptIDs) > 0 {
+			for _, recordID := range keptIDs {
+				m.written.add(paths.ProxyInstancesFilePath(model.ID, recordID))
+			}
+			model.Logger(logger).Info("keeping the snapshot's proxy instances of unchanged records",
+				slog.Int("kept", len(keptIDs)),
+				slog.Int("lookedUp", len(fetchIDs)))
+		}
+		proxyElements.Models = append(proxyElements.Models, model)
+		proxyRecordIDs = append(proxyRecordIDs, fetchIDs)
+		proxyFingerprints = append(proxyFingerprints, unitFingerprint)
+	}
+	return m.WriteAllProxies(ctx, metadataDirectory, datasetID, proxyElements, proxyRecordIDs, func(i int) error {
+		model := proxyElements.Models[i]
+		return m.checkpoint.completeDirectory(proxiesUnit(model), proxyFingerprints[i], paths.ProxyInstancesForModelDirectory(model.ID))
+	})
+}
 
+// writeRecordsUnit is WriteRecords, unless the checkpoint shows that an earlier run already wrote the model's records,
+// in which case the record IDs are read back from its records file
+func (m *MetadataPreProcessor) writeRecordsUnit(ctx context.Context, metadataDirectory string, datasetID string, model schema.Model) ([]string, error) {
+	unit := recordsUnit(model)
+	unitFingerprint, err := fingerprint(model)
+	if err != nil {
+		return nil, err
 	}
-
-	// Write the relationship instances
-	for _, schemaRelationship := range schemaElements.Relationships {
-		relLogger := schemaRelationship.Logger(logger)
-		relRes, err := m.Pennsieve.GetRelationshipInstances(datasetID, schemaRelationship.ID)
-		if err != nil {
-			return err
-		}
-		relationshipInstanceFilePath := filepath.Join(metadataDirectory, paths.RelationshipInstancesFilePath(schemaRelationship.ID))
-		if relSz, err := WriteResponse(relRes, relationshipInstanceFilePath); err != nil {
-			return fmt.Errorf("error writing/decoding relationship %s instances to %s: %w", schemaRelationship.ID, relationshipInstanceFilePath, err)
-		} else {
-			relLogger.Info("wrote relationship instances",
-				slog.String("path", relationshipInstanceFilePath),
-				slog.Int64("size", relSz))
+	recordsFilePath := paths.RecordsFilePath(model.ID)
+	if m.checkpoint.skip(unit, unitFingerprint) {
+		ids, err := readRecordIDs(filepath.Join(metadataDirectory, recordsFilePath))
+		if err == nil {
+			return ids, nil
 		}
+		model.Logger(logger).Warn("error reading record ids of completed unit; redoing it", slog.Any("error", err))
+	}
+	ids, err := m.WriteRecords(ctx, metadataDirectory, datasetID, model)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkpoint.complete(unit, unitFingerprint, recordsFilePath); err != nil {
+		return nil, err
 	}
+	return ids, nil
+}
 
-	// Write the linked property instances
-	for _, schemaLinkedProperties := range schemaElements.LinkedProperties {
-		linkedPropLogger := schemaLinkedProperties.Logger(logger)
-		// Using the RelationshipInstances here because linked props are modeled as relationships server side.
-		// There is a special linked prop instance endpoint, but it's done by record instead of by schema linked prop id, so
-		// its kind of awkward for the layout we've chosen here.
-		linkedPropRes, err := m.Pennsieve.GetRelationshipInstances(datasetID, schemaLinkedProperties.ID)
-		if err != nil {
-			return err
-		}
-		linkedPropertyInstanceFilePath := filepath.Join(metadataDirectory, paths.LinkedPropertyInstancesFilePath(schemaLinkedProperties.ID))
-		if relSz, err := WriteResponse(linkedPropRes, linkedPropertyInstanceFilePath); err != nil {
-			return fmt.Errorf("error writing/decoding linked property %s instances to %s: %w", schemaLinkedProperties.ID, linkedPropertyInstanceFilePath, err)
-		} else {
-			linkedPropLogger.Info("wrote linked property instances",
-				slog.String("path", linkedPropertyInstanceFilePath),
-				slog.Int64("size", relSz))
+func (m *MetadataPreProcessor) WriteRelationshipInstances(ctx context.Context, metadataDirectory string, datasetID string, schemaRelationship schema.Relationship) error {
+	relLogger := schemaRelationship.Logger(logger)
+	relRes, err := m.Pennsieve.GetRelationshipInstances(ctx, datasetID, schemaRelationship.ID)
+	if err != nil {
+		if errors.Is(err, pennsieve.ErrNotFound) {
+			relLogger.Warn("relationship not found; it may have been deleted during the run. Skipping it",
+				slog.Any("error", err))
+			return nil
 		}
+		return err
 	}
+	relationshipInstanceFilePath := filepath.Join(metadataDirectory, paths.RelationshipInstancesFilePath(schemaRelationship.ID))
+	relSz, err := writeResponse(relRes, relationshipInstanceFilePath, func(file *atomicFile) error {
+		return m.changes.compareArray(file, paths.RelationshipInstancesFilePath(schemaRelationship.ID), relationshipInstanceChanges, schemaRelationship.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing/decoding relationship %s instances to %s: %w", schemaRelationship.ID, relationshipInstanceFilePath, err)
+	}
+	m.written.add(paths.RelationshipInstancesFilePath(schemaRelationship.ID))
+	relLogger.Info("wrote relationship instances",
+		slog.String("path", relationshipInstanceFilePath),
+		slog.Int64("size", relSz))
 	return nil
 }
 
-func (m *MetadataPreProcessor) WriteProxies(metadataDirectory, datasetID, modelID string, records []map[string]any) error {
-	for _, record := range records {
-		recordID, err := GetID(record)
-		if err != nil {
-			return err
-		}
-		recordLogger := logger.With(slog.String("recordID", recordID))
-		proxies, err := m.Pennsieve.GetProxyInstancesForRecord(datasetID, modelID, recordID)
-		if err != nil {
-			return err
+func (m *MetadataPreProcessor) WriteLinkedPropertyInstances(ctx context.Context, metadataDirectory string, datasetID string, schemaLinkedProperty schema.LinkedProperty) error {
+	linkedPropLogger := schemaLinkedProperty.Logger(logger)
+	// Using the RelationshipInstances here because linked props are modeled as relationships server side.
+	// There is a special linked prop instance endpoint, but it's done by record instead of by schema linked prop id, so
+	// its kind of awkward for the layout we've chosen here.
+	linkedPropRes, err := m.Pennsieve.GetRelationshipInstances(ctx, datasetID, schemaLinkedProperty.ID)
+	if err != nil {
+		if errors.Is(err, pennsieve.ErrNotFound) {
+			linkedPropLogger.Warn("linked property not found; it may have been deleted during the run. Skipping it",
+				slog.Any("error", err))
+			return nil
 		}
-		if len(proxies) == 0 {
-			recordLogger.Info("no proxy instances for record")
-		} else {
-			proxyInstanceFilePath := filepath.Join(metadataDirectory, paths.ProxyInstancesFilePath(modelID, recordID))
-			directory := filepath.Dir(proxyInstanceFilePath)
-			if err := os.MkdirAll(directory, 0755); err != nil {
-				return fmt.Errorf("error creating proxy instance directory %s: %w", directory, err)
-			}
-			sz, err := WriteJSON(proxyInstanceFilePath, proxies)
+		return err
+	}
+	linkedPropertyInstanceFilePath := filepath.Join(metadataDirectory, paths.LinkedPropertyInstancesFilePath(schemaLinkedProperty.ID))
+	relSz, err := writeResponse(linkedPropRes, linkedPropertyInstanceFilePath, func(file *atomicFile) error {
+		return m.changes.compareArray(file, paths.LinkedPropertyInstancesFilePath(schemaLinkedProperty.ID), linkedPropertyInstanceChanges, schemaLinkedProperty.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing/decoding linked property %s instances to %s: %w", schemaLinkedProperty.ID, linkedPropertyInstanceFilePath, err)
+	}
+	m.written.add(paths.LinkedPropertyInstancesFilePath(schemaLinkedProperty.ID))
+	linkedPropLogger.Info("wrote linked property instances",
+		slog.String("path", linkedPropertyInstanceFilePath),
+		slog.Int64("size", relSz))
+	return nil
+}
+
+// WriteRecords streams the model's records into its records file one page at a time and returns the IDs of the records written.
+func (m *MetadataPreProcessor) WriteRecords(ctx context.Context, metadataDirectory string, datasetID string, model schema.Model) ([]string, error) {
+	return m.writeRecords(ctx, metadataDirectory, datasetID, model, nil)
+}
+
+// writeRecords is WriteRecords, but if keep is non-nil, only the records whose IDs are in keep are written.
+func (m *MetadataPreProcessor) writeRecords(ctx context.Context, metadataDirectory string, datasetID string, model schema.Model, keep map[string]bool) (recordIDs []string, err error) {
+	recordsFilePath := filepath.Join(metadataDirectory, paths.RecordsFilePath(model.ID))
+	file, err := createAtomic(recordsFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.finish(&err)
+
+	buffered := bufio.NewWriter(file)
+	records := NewJSONArrayWriter(buffered)
+	err = m.Pennsieve.ForEachRecordsPage(ctx, datasetID, model.ID, m.RecordsBatchSize, func(page []json.RawMessage) error {
+		for _, record := range page {
+			recordID, err := GetRawID(record)
 			if err != nil {
-				return fmt.Errorf("error writing/decoding proxy instances for %s to %s: %w", recordID, proxyInstanceFilePath, err)
+				return fmt.Errorf("error getting id of model %s record: %w", model.ID, err)
+			}
+			if keep != nil && !keep[recordID] {
+				continue
+			}
+			recordIDs = append(recordIDs, recordID)
+			if err := records.Append(record); err != nil {
+				return fmt.Errorf("error writing model %s records to %s: %w", model.ID, recordsFilePath, err)
 			}
-			recordLogger.Info("wrote proxy instances",
-				slog.String("path", proxyInstanceFilePath),
-				slog.Int64("count", sz),
-			)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if err := records.Close(); err != nil {
+		return nil, fmt.Errorf("error writing model %s records to %s: %w", model.ID, recordsFilePath, err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, fmt.Errorf("error writing model %s records to %s: %w", model.ID, recordsFilePath, err)
+	}
+	if err := m.changes.compareArray(file, paths.RecordsFilePath(model.ID), recordChanges, model.ID); err != nil {
+		return nil, err
+	}
+	m.written.add(paths.RecordsFilePath(model.ID))
+	model.Logger(logger).Info("wrote model records", slog.String("path", recordsFilePath),
+		slog.Int("count", records.Count()),
+		slog.Int64("size", records.Written()))
+	return recordIDs, nil
 }
 
-func WriteAndDecodeResponse(response *http.Response, filePath string, v any) error {
+func WriteAndDecodeResponse(response *http.Response, filePath string, v any) (err error) {
 	defer util.CloseAndWarn(response)
 
-	file, err := os.Create(filePath)
+	file, err := createAtomic(filePath)
 	if err != nil {
-		return fmt.Errorf("error creating file %s: %w", filePath, err)
+		return err
 	}
+	defer file.finish(&err)
 	tee := io.TeeReader(response.Body, file)
 	decoder := json.NewDecoder(tee)
 	if err = decoder.Decode(&v); err != nil {
@@ -296,14 +719,21 @@ func WriteAndDecodeResponse(response *http.Response, filePath string, v any) err
 	return nil
 }
 
-func WriteResponse(response *http.Response, filePath string) (int64, error) {
+func WriteResponse(response *http.Response, filePath string) (written int64, err error) {
+	return writeResponse(response, filePath, nil)
+}
+
+// writeResponse is WriteResponse, but if beforeFinish is non-nil, it is called once the response is fully written to
+// the temporary file
+func writeResponse(response *http.Response, filePath string, beforeFinish func(file *atomicFile) error) (written int64, err error) {
 	defer util.CloseAndWarn(response)
 
-	file, err := os.Create(filePath)
+	file, err := createAtomic(filePath)
 	if err != nil {
-		return 0, fmt.Errorf("error creating file %s: %w", filePath, err)
+		return 0, err
 	}
-	written, err := io.Copy(file, response.Body)
+	defer file.finish(&err)
+	written, err = io.Copy(file, response.Body)
 	if err != nil {
 		return 0, fmt.Errorf("error writing %s %s response to %s: %w",
 			response.Request.Method,
@@ -311,19 +741,25 @@ func WriteResponse(response *http.Response, filePath string) (int64, error) {
 			filePath,
 			err)
 	}
+	if beforeFinish != nil {
+		if err := beforeFinish(file); err != nil {
+			return 0, err
+		}
+	}
 	return written, nil
 }
 
-func WriteJSON(filePath string, v any) (int64, error) {
+func WriteJSON(filePath string, v any) (written int64, err error) {
 	jsonBytes, err := json.Marshal(v)
 	if err != nil {
 		return 0, fmt.Errorf("error marshalling JSON value %s to bytes: %w", v, err)
 	}
-	file, err := os.Create(filePath)
+	file, err := createAtomic(filePath)
 	if err != nil {
-		return 0, fmt.Errorf("error creating file %s: %w", filePath, err)
+		return 0, err
 	}
-	written, err := io.Copy(file, bytes.NewReader(jsonBytes))
+	defer file.finish(&err)
+	written, err = io.Copy(file, bytes.NewReader(jsonBytes))
 	if err != nil {
 		return 0, fmt.Errorf("error writing JSON value to file %s: %w", filePath, err)
 	}
@@ -338,6 +774,74 @@ func LookupRequiredEnvVar(key string) (string, error) {
 	return value, nil
 }
 
+// LookupIntEnvVar returns the value of the given environment variable as an int, or defaultValue if it is not set.
+func LookupIntEnvVar(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s value %q is not an integer: %w", key, value, err)
+	}
+	return intValue, nil
+}
+
+// LookupBoolEnvVar returns the value of the given environment variable as a bool, or defaultValue if it is not set.
+// Accepts the values accepted by strconv.ParseBool, for example "true" or "false".
+func LookupBoolEnvVar(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s value %q is not a boolean: %w", key, value, err)
+	}
+	return boolValue, nil
+}
+
+// LookupFloatEnvVar returns the value of the given environment variable as a float64, or defaultValue if it is not set.
+func LookupFloatEnvVar(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s value %q is not a number: %w", key, value, err)
+	}
+	return floatValue, nil
+}
+
+// LookupDurationEnvVar returns the value of the given environment variable as a time.Duration,
+// or defaultValue if it is not set. Values should be in the format accepted by time.ParseDuration, for example "500ms" or "2m".
+func LookupDurationEnvVar(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s value %q is not a duration: %w", key, value, err)
+	}
+	return duration, nil
+}
+
+// GetRawID returns the id of a JSON object without decoding the rest of it
+func GetRawID(jsonObject json.RawMessage) (string, error) {
+	var withID struct {
+		ID *string `json:"id"`
+	}
+	if err := json.Unmarshal(jsonObject, &withID); err != nil {
+		return "", fmt.Errorf("error decoding id: %w", err)
+	}
+	if withID.ID == nil {
+		return "", fmt.Errorf("id not found")
+	}
+	return *withID.ID, nil
+}
+
 func GetID(jsonMap map[string]any) (string, error) {
 	idAny, inResponse := jsonMap["id"]
 	if !inResponse {
@@ -1,23 +1,189 @@
 package preprocessor
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/pennsieve/processor-pre-metadata/client"
+	"github.com/pennsieve/processor-pre-metadata/client/models/instance"
+	"github.com/pennsieve/processor-pre-metadata/client/models/run"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
 	"github.com/pennsieve/processor-pre-metadata/client/paths"
 	"github.com/pennsieve/processor-pre-metadata/service/pennsieve"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestRun(t *testing.T) {
+	for _, proxyMode := range []ProxyMode{BulkProxyMode, PerRecordProxyMode} {
+		for _, fetchConcurrency := range []int{1, defaultFetchConcurrency, 16} {
+			t.Run(fmt.Sprintf("%s proxies, fetch concurrency %d", proxyMode, fetchConcurrency), func(t *testing.T) {
+				testRun(t, proxyMode, fetchConcurrency, http.StatusOK)
+			})
+		}
+	}
+}
+
+func TestRun_ProxyLinksUnsupported(t *testing.T) {
+	for _, proxyLinksStatusCode := range []int{http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented} {
+		t.Run(strconv.Itoa(proxyLinksStatusCode), func(t *testing.T) {
+			testRun(t, BulkProxyMode, defaultFetchConcurrency, proxyLinksStatusCode)
+		})
+	}
+}
+
+func TestRun_ProxyLinksFailed(t *testing.T) {
+	for proxyLinksStatusCode, expectedErr := range map[int]error{
+		http.StatusForbidden: pennsieve.ErrForbidden,
+		// the proxy relationship may have been deleted during the run, so a 404 is not taken to mean the links are unsupported
+		http.StatusNotFound: pennsieve.ErrNotFound,
+	} {
+		t.Run(strconv.Itoa(proxyLinksStatusCode), func(t *testing.T) {
+			datasetId := uuid.NewString()
+			integrationID := uuid.NewString()
+			expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+			expectedFiles.ProxyLinksStatusCode = proxyLinksStatusCode
+			mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+			defer mockServer.Close()
+
+			metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+			require.NoError(t, err)
+			err = metadataPP.Run(context.Background())
+			assert.ErrorIs(t, err, expectedErr)
+			assert.ErrorContains(t, err, "error getting package proxy links")
+		})
+	}
+}
+
+// TestRun_ProxyModesIdentical checks that both proxy modes write byte for byte the same instance files
+func TestRun_ProxyModesIdentical(t *testing.T) {
+	datasetId := uuid.NewString()
+	integrationID := uuid.NewString()
+	instancesFiles := map[ProxyMode]map[string][]byte{}
+	for _, proxyMode := range []ProxyMode{BulkProxyMode, PerRecordProxyMode} {
+		expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+		expectedFiles.ProxyMode = proxyMode
+		mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+		metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+		require.NoError(t, err)
+		metadataPP.ProxyMode = proxyMode
+		require.NoError(t, metadataPP.Run(context.Background()))
+		mockServer.Close()
+
+		files := map[string][]byte{}
+		err = filepath.WalkDir(filepath.Join(metadataPP.MetadataPath(), paths.InstancesDirectory), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			relativePath, err := filepath.Rel(metadataPP.MetadataPath(), path)
+			if err != nil {
+				return err
+			}
+			files[relativePath], err = os.ReadFile(path)
+			return err
+		})
+		require.NoError(t, err)
+		instancesFiles[proxyMode] = files
+	}
+	bulkFiles, perRecordFiles := instancesFiles[BulkProxyMode], instancesFiles[PerRecordProxyMode]
+	require.NotEmpty(t, bulkFiles)
+	assert.Len(t, perRecordFiles, len(bulkFiles))
+	for relativePath, bulkContent := range bulkFiles {
+		perRecordContent, written := perRecordFiles[relativePath]
+		if assert.True(t, written, "%s not written in per-record mode", relativePath) {
+			assert.Equal(t, string(bulkContent), string(perRecordContent), relativePath)
+		}
+	}
+	assert.Contains(t, bulkFiles, paths.ProxyInstancesFilePath("bb04a8ce-03c9-4801-a0d9-e35cea53ac1b", "a9b9d03b-19b3-4a43-b40e-5673ec955e49"))
+}
+
+// testRun checks that the output is the same whatever the proxy mode and fetch concurrency, and that bulk proxy mode
+// falls back to per-record lookups if the proxy links respond with a proxyLinksStatusCode that means they are not
+// served.
+func testRun(t *testing.T, proxyMode ProxyMode, fetchConcurrency int, proxyLinksStatusCode int) {
+	datasetId := uuid.NewString()
+
+	integrationID := uuid.NewString()
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	sessionToken := uuid.NewString()
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+	expectedFiles.ProxyMode = proxyMode
+	if proxyLinksStatusCode != http.StatusOK {
+		expectedFiles.ProxyLinksStatusCode = proxyLinksStatusCode
+	}
+	mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.FetchConcurrency = fetchConcurrency
+	metadataPP.ProxyMode = proxyMode
+
+	require.NoError(t, metadataPP.Run(context.Background()))
+	expectedFiles.AssertEqual(t, metadataPP.MetadataPath())
+
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	require.NotNil(t, reader.Marker)
+	assert.Equal(t, integrationID, reader.Marker.IntegrationID)
+	assert.Equal(t, datasetId, reader.Marker.DatasetID)
+	assert.Equal(t, string(DatasetScope), reader.Marker.Scope)
+	assert.False(t, reader.Marker.CompletedAt.Before(reader.Marker.StartedAt))
+	assertNoTempFiles(t, metadataPP.MetadataPath())
+
+	assert.NoError(t, reader.Verify())
+	manifest, err := reader.GetManifest()
+	require.NoError(t, err)
+	assert.Equal(t, integrationID, manifest.IntegrationID)
+	assert.Equal(t, datasetId, manifest.DatasetID)
+	assert.Equal(t, mockServer.URL, manifest.APIHost)
+	assert.Equal(t, Version, manifest.ProcessorVersion)
+	filesByPath := map[string]run.ManifestFile{}
+	for _, file := range manifest.Files {
+		filesByPath[file.Path] = file
+	}
+	for _, expectedFile := range expectedFiles.Files {
+		if len(expectedFile.TestdataPath) == 0 || expectedFile.ExpectFileNotExists {
+			continue
+		}
+		file, inManifest := filesByPath[filepath.ToSlash(expectedFile.TestdataPath)]
+		if assert.True(t, inManifest, "%s not in manifest", expectedFile.TestdataPath) {
+			if elements, isArray := expectedFile.Content.([]any); isArray {
+				assert.Equal(t, len(elements), file.Count, expectedFile.TestdataPath)
+			}
+		}
+	}
+	assert.NotContains(t, filesByPath, paths.CompleteMarkerFilePath)
+}
+
+// assertNoTempFiles fails if any temporary file created by createAtomic is left in dir
+func assertNoTempFiles(t *testing.T, dir string) {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		assert.False(t, strings.HasSuffix(path, ".tmp"), "temporary file %s left behind", path)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestRun_ModelDeleted(t *testing.T) {
 	datasetId := uuid.NewString()
 
 	integrationID := uuid.NewString()
@@ -25,62 +191,1004 @@ func TestRun(t *testing.T) {
 	outputDir := t.TempDir()
 	sessionToken := uuid.NewString()
 	expectedFiles := NewExpectedFiles(datasetId).WithModels(
-		"7931cbe6-7494-4c0b-95f0-9f4b34edc73b",
 		"83964537-46d2-4fb5-9408-0b6262a42a56",
 		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b",
+	).WithDeletedModels(
+		"7931cbe6-7494-4c0b-95f0-9f4b34edc73b",
 	).WithSchemaRelationships(
 		"30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d",
+	).WithUnexportedRelationships(
 		"2514a023-17fe-4743-af5f-094ed3dd339c",
-	).WithSchemaLinkedProperties(
 		"bbea65fd-b51f-464a-a5d3-dc228ff408c1",
 	).WithProxies(map[string][]string{
 		"83964537-46d2-4fb5-9408-0b6262a42a56": {"e79e8d65-b094-4f36-94f2-1553cd84b4a2"},
 		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b": {"a9b9d03b-19b3-4a43-b40e-5673ec955e49", "bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"}},
 	).WithNoProxies(map[string][]string{
-		"7931cbe6-7494-4c0b-95f0-9f4b34edc73b": {"7681b4f8-7d10-4855-8c87-7fef3b408c0b"},
 		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b": {"5b07e038-9829-46c9-b698-bf4efef81341"},
 	}).Build(t)
 	mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
 	defer mockServer.Close()
 
-	metadataPP := NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+
+	require.NoError(t, metadataPP.Run(context.Background()))
+	expectedFiles.AssertEqual(t, metadataPP.MetadataPath())
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"location", "object"}, reader.Schema.ModelNames())
+}
+
+func TestRun_IntegrationParams(t *testing.T) {
+	datasetId := uuid.NewString()
+
+	integrationID := uuid.NewString()
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	sessionToken := uuid.NewString()
+	expectedFiles := NewExpectedFiles(datasetId).WithModels(
+		"83964537-46d2-4fb5-9408-0b6262a42a56",
+		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b",
+	).WithUnexportedModels(
+		"7931cbe6-7494-4c0b-95f0-9f4b34edc73b",
+	).WithSchemaRelationships(
+		"30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d",
+	).WithUnexportedRelationships(
+		"2514a023-17fe-4743-af5f-094ed3dd339c",
+		"bbea65fd-b51f-464a-a5d3-dc228ff408c1",
+	).Build(t)
+	expectedFiles.IntegrationParams = map[string]any{
+		"otherProcessor": map[string]any{"ignored": true},
+		ParamsKey: map[string]any{
+			"version":      ParamsVersion,
+			"models":       []string{"location", "object"},
+			"fetchProxies": false,
+		},
+	}
+	mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+
+	require.NoError(t, metadataPP.Run(context.Background()))
+	expectedFiles.AssertEqual(t, metadataPP.MetadataPath())
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"location", "object"}, reader.Schema.ModelNames())
+	proxyEntries, err := os.ReadDir(filepath.Join(metadataPP.MetadataPath(), paths.InstancesDirectory, paths.ProxiesDirectory))
+	require.NoError(t, err)
+	assert.Empty(t, proxyEntries)
+}
+
+func TestRun_InvalidIntegrationParams(t *testing.T) {
+	datasetId := uuid.NewString()
+	integrationID := uuid.NewString()
+	expectedFiles := NewExpectedFiles(datasetId)
+	for i := range expectedFiles.Files {
+		expectedFiles.Files[i].ExpectNoCall = true
+	}
+	expectedFiles.IntegrationParams = map[string]any{
+		ParamsKey: map[string]any{"version": ParamsVersion, "recordsBatchSize": 0, "outputFormat": "csv"},
+	}
+	mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+
+	err = metadataPP.Run(context.Background())
+	var invalidParams *InvalidParamsError
+	require.ErrorAs(t, err, &invalidParams)
+	assert.Len(t, invalidParams.Problems, 2)
+}
+
+func TestRun_PackagesScope(t *testing.T) {
+	// In the testdata, subject record 7681b4f8 beholds object record 5b07e038 which has been at location record e79e8d65,
+	// and subject 7681b4f8 has location e79e8d65 as its address. Object records a9b9d03b and bcf06e0c are not linked to anything.
+	locationPackageID := "N:collection:e3c0abb8-7480-42af-9529-99cafe9ea235"
+	for scenario, tt := range map[string]struct {
+		hops                   int
+		expectedRecordIDs      map[string][]string
+		expectedRelationships  []string
+		expectedLinkedProperty bool
+	}{
+		"zero hops": {
+			hops:              0,
+			expectedRecordIDs: map[string][]string{"location": {"e79e8d65-b094-4f36-94f2-1553cd84b4a2"}},
+		},
+		"one hop": {
+			hops: 1,
+			expectedRecordIDs: map[string][]string{
+				"location": {"e79e8d65-b094-4f36-94f2-1553cd84b4a2"},
+				"object":   {"5b07e038-9829-46c9-b698-bf4efef81341"},
+				"subject":  {"7681b4f8-7d10-4855-8c87-7fef3b408c0b"},
+			},
+			expectedRelationships:  []string{"30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d", "2514a023-17fe-4743-af5f-094ed3dd339c"},
+			expectedLinkedProperty: true,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			datasetId := uuid.NewString()
+			integrationID := uuid.NewString()
+			expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+			expectedFiles.IntegrationPackageIDs = []string{locationPackageID}
+			expectedFiles.IntegrationParams = map[string]any{ParamsKey: map[string]any{"version": ParamsVersion, "scope": PackagesScope, "hops": tt.hops}}
+			mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+			defer mockServer.Close()
+
+			metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+			require.NoError(t, err)
+			require.NoError(t, metadataPP.Run(context.Background()))
+
+			reader, err := client.NewReader(metadataPP.InputDirectory)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.expectedRecordIDs), reader.Schema.ModelCount())
+			for modelName, expectedRecordIDs := range tt.expectedRecordIDs {
+				records, err := reader.GetRecordsForModel(modelName)
+				require.NoError(t, err)
+				var recordIDs []string
+				for _, record := range records {
+					recordIDs = append(recordIDs, record.ID)
+				}
+				assert.Equal(t, expectedRecordIDs, recordIDs)
+			}
+			locationProxies, err := reader.GetProxiesForModel("location")
+			require.NoError(t, err)
+			assert.Contains(t, locationProxies, "e79e8d65-b094-4f36-94f2-1553cd84b4a2")
+			assert.NoDirExists(t, filepath.Join(metadataPP.ProxiesPath(), "bb04a8ce-03c9-4801-a0d9-e35cea53ac1b"))
+
+			for _, relationshipID := range []string{"30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d", "2514a023-17fe-4743-af5f-094ed3dd339c"} {
+				relationshipFilePath := filepath.Join(metadataPP.MetadataPath(), paths.RelationshipInstancesFilePath(relationshipID))
+				if slices.Contains(tt.expectedRelationships, relationshipID) {
+					assert.FileExists(t, relationshipFilePath)
+				} else {
+					assert.NoFileExists(t, relationshipFilePath)
+				}
+			}
+			if tt.expectedLinkedProperty {
+				links, err := reader.GetLinkInstancesForProperty("address")
+				require.NoError(t, err)
+				assert.Len(t, links, 1)
+			} else {
+				assert.Zero(t, reader.Schema.LinkedPropertyCount())
+			}
+		})
+	}
+}
+
+func TestRun_ProxyAncestors(t *testing.T) {
+	// In the testdata, package log.txt is in collection object
+	logPackageID := "N:package:f90ff4bc-e3e5-4a53-b545-158ea770fbd8"
+	objectCollection := map[string]any{"content": map[string]any{
+		"id":          "N:collection:95bb7c19-0e8e-42b2-b53f-f5ce7a08e42a",
+		"nodeId":      "N:collection:95bb7c19-0e8e-42b2-b53f-f5ce7a08e42a",
+		"name":        "object",
+		"packageType": "Collection",
+	}}
+	for _, proxyMode := range []ProxyMode{BulkProxyMode, PerRecordProxyMode} {
+		t.Run(string(proxyMode), func(t *testing.T) {
+			datasetId := uuid.NewString()
+			integrationID := uuid.NewString()
+			expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+			expectedFiles.ProxyMode = proxyMode
+			objectCollectionBytes, err := json.Marshal([]any{objectCollection})
+			require.NoError(t, err)
+			expectedFiles.PackageAncestors = map[string]json.RawMessage{logPackageID: objectCollectionBytes}
+			expectedFiles.IntegrationParams = map[string]any{ParamsKey: map[string]any{"version": ParamsVersion, "proxyAncestors": true}}
+			// every written proxy package is expected to have its ancestors
+			for _, expectedFile := range expectedFiles.Files {
+				proxies, isProxiesFile := expectedFile.Content.([]any)
+				if !isProxiesFile || !strings.HasPrefix(expectedFile.TestdataPath, paths.InstancesDirectory+"/"+paths.ProxiesDirectory) {
+					continue
+				}
+				for _, proxy := range proxies {
+					pkg := proxy.([]any)[1].(map[string]any)
+					pkg["ancestors"] = []any{}
+					if pkg["content"].(map[string]any)["nodeId"] == logPackageID {
+						pkg["ancestors"] = []any{objectCollection}
+					}
+				}
+			}
+			mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+			defer mockServer.Close()
+
+			metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+			require.NoError(t, err)
+			metadataPP.ProxyMode = proxyMode
+			require.NoError(t, metadataPP.Run(context.Background()))
+			expectedFiles.AssertEqual(t, metadataPP.MetadataPath())
+
+			reader, err := client.NewReader(metadataPP.InputDirectory)
+			require.NoError(t, err)
+			objectProxies, err := reader.GetProxiesForModel("object")
+			require.NoError(t, err)
+			require.Len(t, objectProxies["bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"], 1)
+			assert.Equal(t, "object/log.txt", objectProxies["bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"][0].DatasetPath())
+		})
+	}
+}
+
+func TestRun_Canceled(t *testing.T) {
+	datasetId := uuid.NewString()
+
+	integrationID := uuid.NewString()
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	sessionToken := uuid.NewString()
+	canceledRelationshipID := "2514a023-17fe-4743-af5f-094ed3dd339c"
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The mock cancels the run half-way through writing a relationship instances response
+	mux := newMockMux(t, integrationID, datasetId, expectedFiles)
+	canceledPath := fmt.Sprintf("/models/datasets/%s/relationships/%s/instances", datasetId, canceledRelationshipID)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != canceledPath {
+			mux.ServeHTTP(writer, request)
+			return
+		}
+		_, err := writer.Write([]byte(`[{"id": `))
+		require.NoError(t, err)
+		writer.(http.Flusher).Flush()
+		cancel()
+		<-request.Context().Done()
+	}))
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+
+	err = metadataPP.Run(ctx)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, filepath.Join(metadataPP.MetadataPath(), paths.RelationshipInstancesFilePath(canceledRelationshipID)))
+	assert.NoFileExists(t, filepath.Join(metadataPP.MetadataPath(), paths.CompleteMarkerFilePath))
+	assertNoTempFiles(t, metadataPP.MetadataPath())
+
+	_, err = client.NewReader(metadataPP.InputDirectory)
+	assert.ErrorIs(t, err, client.ErrIncomplete)
+}
+
+func TestRun_DatasetIDGiven(t *testing.T) {
+	datasetId := uuid.NewString()
+	integrationID := uuid.NewString()
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+	expectedFiles.IntegrationParams = map[string]any{ParamsKey: map[string]any{"version": ParamsVersion, "fetchProxies": false}}
+	mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.WithDatasetID(datasetId)
+	require.NoError(t, metadataPP.Run(context.Background()))
+
+	// the integration's params apply even though the dataset id was given
+	assert.False(t, metadataPP.FetchProxies)
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.Equal(t, datasetId, reader.Marker.DatasetID)
+	assert.False(t, reader.Marker.FetchProxies)
+	proxyModelDirectories, err := os.ReadDir(metadataPP.ProxiesPath())
+	require.NoError(t, err)
+	assert.Empty(t, proxyModelDirectories)
+}
+
+func TestRun_RemovesStaleCompleteMarker(t *testing.T) {
+	integrationID := uuid.NewString()
+	mockServer := newFailingMockServer(t, integrationID, uuid.NewString())
+	defer mockServer.Close()
+	metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	require.NoError(t, metadataPP.MkDirectories())
+	markerFilePath := filepath.Join(metadataPP.MetadataPath(), paths.CompleteMarkerFilePath)
+	require.NoError(t, os.WriteFile(markerFilePath, []byte(`{}`), 0644))
+
+	// the run fails on its first request after the integration, after removing the marker
+	require.Error(t, metadataPP.Run(context.Background()))
+	assert.NoFileExists(t, markerFilePath)
+}
+
+func TestRun_Resume(t *testing.T) {
+	datasetId := uuid.NewString()
+
+	integrationID := uuid.NewString()
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	sessionToken := uuid.NewString()
+	completedRelationshipID := "30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d"
+	failedRelationshipID := "2514a023-17fe-4743-af5f-094ed3dd339c"
+	modelIDs := []string{
+		"7931cbe6-7494-4c0b-95f0-9f4b34edc73b",
+		"83964537-46d2-4fb5-9408-0b6262a42a56",
+		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b",
+	}
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+	recordsPath := func(modelID string) string {
+		return fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", datasetId, modelID)
+	}
+	relationshipPath := func(relationshipID string) string {
+		return fmt.Sprintf("/models/datasets/%s/relationships/%s/instances", datasetId, relationshipID)
+	}
+	// changedPropertiesModelID has a property added between the failed run and the resumed run
+	changedPropertiesModelID := modelIDs[0]
+	changedPropertiesPath := fmt.Sprintf("/models/datasets/%s/concepts/%s/properties", datasetId, changedPropertiesModelID)
+
+	// The mock fails the second relationship on the first run. With a fetch concurrency of 1, the records of every
+	// model and the first relationship are written before that.
+	failing := true
+	var requestCountsMutex sync.Mutex
+	requestCounts := map[string]int{}
+	mux := newMockMux(t, integrationID, datasetId, expectedFiles)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestCountsMutex.Lock()
+		requestCounts[request.URL.Path]++
+		requestCountsMutex.Unlock()
+		if failing && request.URL.Path == relationshipPath(failedRelationshipID) {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !failing && request.URL.Path == changedPropertiesPath {
+			propertiesBytes, err := os.ReadFile(filepath.Join("testdata", paths.PropertiesFilePath(changedPropertiesModelID)))
+			require.NoError(t, err)
+			var properties []map[string]any
+			require.NoError(t, json.Unmarshal(propertiesBytes, &properties))
+			properties = append(properties, map[string]any{"id": uuid.NewString(), "name": "added", "dataType": "String"})
+			propertiesBytes, err = json.Marshal(properties)
+			require.NoError(t, err)
+			_, err = writer.Write(propertiesBytes)
+			require.NoError(t, err)
+			return
+		}
+		mux.ServeHTTP(writer, request)
+	}))
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.FetchConcurrency = 1
+	require.Error(t, metadataPP.Run(context.Background()))
+	checkpointFilePath := filepath.Join(metadataPP.MetadataPath(), paths.CheckpointFilePath)
+	assert.FileExists(t, checkpointFilePath)
+
+	failing = false
+	requestCounts = map[string]int{}
+	metadataPP, err = NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.Resume = true
+	require.NoError(t, metadataPP.Run(context.Background()))
+
+	// the schema is always fetched again, and only the units not completed, or whose schema changed, are redone
+	assert.Equal(t, 1, requestCounts[fmt.Sprintf("/models/datasets/%s/concepts/schema/graph", datasetId)])
+	assert.Equal(t, 1, requestCounts[recordsPath(changedPropertiesModelID)])
+	for _, modelID := range modelIDs[1:] {
+		assert.Zero(t, requestCounts[recordsPath(modelID)], "records of model %s fetched again", modelID)
+	}
+	assert.Zero(t, requestCounts[relationshipPath(completedRelationshipID)])
+	assert.Equal(t, 1, requestCounts[relationshipPath(failedRelationshipID)])
+
+	assert.NoFileExists(t, checkpointFilePath)
+	assertNoTempFiles(t, metadataPP.MetadataPath())
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.NoError(t, reader.Verify())
+}
+
+func TestRun_Resume_Proxies(t *testing.T) {
+	datasetId := uuid.NewString()
+	integrationID := uuid.NewString()
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	sessionToken := uuid.NewString()
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+	expectedFiles.ProxyMode = PerRecordProxyMode
+	recordProxiesPath := func(modelID, recordID string) string {
+		return fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/files", datasetId, modelID, recordID)
+	}
+	// The mock fails the proxies of the subject record on the first run. With a fetch concurrency of 1, the proxies of
+	// the location and object models, which come before subject in the schema, are written before that.
+	completedRecordProxiesPaths := []string{
+		recordProxiesPath("83964537-46d2-4fb5-9408-0b6262a42a56", "e79e8d65-b094-4f36-94f2-1553cd84b4a2"),
+		recordProxiesPath("bb04a8ce-03c9-4801-a0d9-e35cea53ac1b", "a9b9d03b-19b3-4a43-b40e-5673ec955e49"),
+		recordProxiesPath("bb04a8ce-03c9-4801-a0d9-e35cea53ac1b", "bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"),
+		recordProxiesPath("bb04a8ce-03c9-4801-a0d9-e35cea53ac1b", "5b07e038-9829-46c9-b698-bf4efef81341"),
+	}
+	failedRecordProxiesPath := recordProxiesPath("7931cbe6-7494-4c0b-95f0-9f4b34edc73b", "7681b4f8-7d10-4855-8c87-7fef3b408c0b")
+
+	failing := true
+	var requestCountsMutex sync.Mutex
+	requestCounts := map[string]int{}
+	mux := newMockMux(t, integrationID, datasetId, expectedFiles)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestCountsMutex.Lock()
+		requestCounts[request.URL.Path]++
+		requestCountsMutex.Unlock()
+		if failing && request.URL.Path == failedRecordProxiesPath {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mux.ServeHTTP(writer, request)
+	}))
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.FetchConcurrency = 1
+	metadataPP.ProxyMode = PerRecordProxyMode
+	require.Error(t, metadataPP.Run(context.Background()))
+
+	failing = false
+	requestCounts = map[string]int{}
+	metadataPP, err = NewMetadataPreProcessor(integrationID, inputDir, outputDir, sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.ProxyMode = PerRecordProxyMode
+	metadataPP.Resume = true
+	require.NoError(t, metadataPP.Run(context.Background()))
+
+	for _, completedPath := range completedRecordProxiesPaths {
+		assert.Zero(t, requestCounts[completedPath], "%s fetched again", completedPath)
+	}
+	assert.Equal(t, 1, requestCounts[failedRecordProxiesPath])
+	expectedFiles.AssertEqual(t, metadataPP.MetadataPath())
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.NoError(t, reader.Verify())
+}
+
+func TestRun_Incremental(t *testing.T) {
+	datasetId := uuid.NewString()
+
+	integrationID := uuid.NewString()
+	inputDir := t.TempDir()
+	sessionToken := uuid.NewString()
+	changedModelID := "bb04a8ce-03c9-4801-a0d9-e35cea53ac1b"
+	unchangedModelID := "7931cbe6-7494-4c0b-95f0-9f4b34edc73b"
+	changedRecordID := "5b07e038-9829-46c9-b698-bf4efef81341"
+	deletedRecordID := "bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"
+	addedRecordID := uuid.NewString()
+	changedRelationshipID := "2514a023-17fe-4743-af5f-094ed3dd339c"
+	deletedInstanceID := "cf2a668c-0e4c-46bc-b799-c29397b22feb"
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+
+	// after the first run, the mock changes one record of changedModelID, deletes another along with its proxies,
+	// adds a third, and deletes the only instance of changedRelationshipID
+	changed := false
+	mux := newMockMux(t, integrationID, datasetId, expectedFiles)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case changed && request.URL.Path == fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", datasetId, changedModelID):
+			recordsBytes, err := os.ReadFile(filepath.Join("testdata", paths.RecordsFilePath(changedModelID)))
+			require.NoError(t, err)
+			var records []map[string]any
+			require.NoError(t, json.Unmarshal(recordsBytes, &records))
+			records = slices.DeleteFunc(records, func(record map[string]any) bool {
+				return record["id"] == deletedRecordID
+			})
+			for _, record := range records {
+				if record["id"] == changedRecordID {
+					record["updatedAt"] = "2024-09-26T22:05:14.087000+00:00"
+				}
+			}
+			records = append(records, map[string]any{"id": addedRecordID, "type": "object", "values": []any{}})
+			recordsBytes, err = json.Marshal(records)
+			require.NoError(t, err)
+			_, err = writer.Write(recordsBytes)
+			require.NoError(t, err)
+		case changed && request.URL.Path == fmt.Sprintf("/models/datasets/%s/relationships/%s/instances", datasetId, changedRelationshipID):
+			_, err := writer.Write([]byte(`[]`))
+			require.NoError(t, err)
+		default:
+			mux.ServeHTTP(writer, request)
+		}
+	}))
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	require.NoError(t, metadataPP.Run(context.Background()))
+	unchangedRecordsFilePath := filepath.Join(metadataPP.MetadataPath(), paths.RecordsFilePath(unchangedModelID))
+	unchangedRecordsFileBefore, err := os.Stat(unchangedRecordsFilePath)
+	require.NoError(t, err)
+	unchangedProxiesFilePath := filepath.Join(metadataPP.MetadataPath(), paths.ProxyInstancesFilePath(changedModelID, "a9b9d03b-19b3-4a43-b40e-5673ec955e49"))
+	unchangedProxiesFileBefore, err := os.Stat(unchangedProxiesFilePath)
+	require.NoError(t, err)
+
+	changed = true
+	metadataPP, err = NewMetadataPreProcessor(integrationID, inputDir, t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.PreviousMetadataDirectory = metadataPP.MetadataPath()
+	require.NoError(t, metadataPP.Run(context.Background()))
+
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.NoError(t, reader.Verify())
+	changes, err := reader.GetChanges()
+	require.NoError(t, err)
+	require.NotNil(t, changes.Previous)
+	assert.Equal(t, datasetId, changes.Previous.DatasetID)
+	assert.Equal(t, datasetId, changes.DatasetID)
+	assert.True(t, changes.Models.IsEmpty())
+	assert.True(t, changes.Relationships.IsEmpty())
+	assert.True(t, changes.LinkedProperties.IsEmpty())
+	assert.Equal(t, map[string]run.ElementChanges{changedModelID: {
+		Added:   []string{addedRecordID},
+		Changed: []string{changedRecordID},
+		Deleted: []string{deletedRecordID},
+	}}, changes.Records)
+	assert.Equal(t, map[string]run.ElementChanges{changedRelationshipID: {Deleted: []string{deletedInstanceID}}}, changes.RelationshipInstances)
+	assert.Empty(t, changes.LinkedPropertyInstances)
+	assert.Equal(t, map[string]run.ElementChanges{changedModelID: {Deleted: []string{deletedRecordID}}}, changes.Proxies)
+
+	assert.NoFileExists(t, filepath.Join(metadataPP.MetadataPath(), paths.ProxyInstancesFilePath(changedModelID, deletedRecordID)))
+	// unchanged files are kept rather than replaced
+	unchangedRecordsFileAfter, err := os.Stat(unchangedRecordsFilePath)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(unchangedRecordsFileBefore, unchangedRecordsFileAfter))
+	unchangedProxiesFileAfter, err := os.Stat(unchangedProxiesFilePath)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(unchangedProxiesFileBefore, unchangedProxiesFileAfter))
+	records, err := reader.GetRecordsForModel("object")
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+}
+
+func TestRun_Incremental_KeepsProxiesOfUnchangedRecords(t *testing.T) {
+	objectModelID := "bb04a8ce-03c9-4801-a0d9-e35cea53ac1b"
+	changedRecordID := "a9b9d03b-19b3-4a43-b40e-5673ec955e49"
+	for _, proxyMode := range []ProxyMode{BulkProxyMode, PerRecordProxyMode} {
+		t.Run(string(proxyMode), func(t *testing.T) {
+			datasetId := uuid.NewString()
+			integrationID := uuid.NewString()
+			inputDir := t.TempDir()
+			expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+			expectedFiles.ProxyMode = proxyMode
+
+			// after the first run, the mock changes the updatedAt of one object record with proxies
+			changed := false
+			var requestCountsMutex sync.Mutex
+			requestCounts := map[string]int{}
+			mux := newMockMux(t, integrationID, datasetId, expectedFiles)
+			mockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if changed {
+					requestCountsMutex.Lock()
+					requestCounts[request.URL.Path]++
+					requestCountsMutex.Unlock()
+				}
+				if changed && request.URL.Path == fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", datasetId, objectModelID) {
+					recordsBytes, err := os.ReadFile(filepath.Join("testdata", paths.RecordsFilePath(objectModelID)))
+					require.NoError(t, err)
+					var records []map[string]any
+					require.NoError(t, json.Unmarshal(recordsBytes, &records))
+					for _, record := range records {
+						if record["id"] == changedRecordID {
+							record["updatedAt"] = "2024-09-26T22:05:14.087000+00:00"
+						}
+					}
+					recordsBytes, err = json.Marshal(records)
+					require.NoError(t, err)
+					_, err = writer.Write(recordsBytes)
+					require.NoError(t, err)
+					return
+				}
+				mux.ServeHTTP(writer, request)
+			}))
+			defer mockServer.Close()
+
+			incrementalRun := func() *MetadataPreProcessor {
+				metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+				require.NoError(t, err)
+				metadataPP.ProxyMode = proxyMode
+				metadataPP.PreviousMetadataDirectory = metadataPP.MetadataPath()
+				require.NoError(t, metadataPP.Run(context.Background()))
+				return metadataPP
+			}
+			incrementalRun()
+			changed = true
+			metadataPP := incrementalRun()
+
+			recordProxiesPath := func(modelID, recordID string) string {
+				return fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/files", datasetId, modelID, recordID)
+			}
+			changedPackages := map[string]bool{}
+			for _, link := range expectedFiles.ProxyLinks {
+				if link.RecordID == changedRecordID {
+					changedPackages[link.PackageNodeID] = true
+				}
+			}
+			require.NotEmpty(t, changedPackages)
+			for _, link := range expectedFiles.ProxyLinks {
+				expectedPackageRequests := 0
+				if proxyMode == BulkProxyMode && changedPackages[link.PackageNodeID] {
+					expectedPackageRequests = 1
+				}
+				assert.Equal(t, expectedPackageRequests, requestCounts[fmt.Sprintf("/packages/%s", link.PackageNodeID)], "package %s", link.PackageNodeID)
+			}
+			if proxyMode == PerRecordProxyMode {
+				assert.Equal(t, 1, requestCounts[recordProxiesPath(objectModelID, changedRecordID)])
+				// the snapshot has proxies for these records, and they are unchanged
+				assert.Zero(t, requestCounts[recordProxiesPath(objectModelID, "bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c")])
+				assert.Zero(t, requestCounts[recordProxiesPath("83964537-46d2-4fb5-9408-0b6262a42a56", "e79e8d65-b094-4f36-94f2-1553cd84b4a2")])
+				// the snapshot has no proxies file for these records, so their proxies are looked up again
+				assert.Equal(t, 1, requestCounts[recordProxiesPath(objectModelID, "5b07e038-9829-46c9-b698-bf4efef81341")])
+				assert.Equal(t, 1, requestCounts[recordProxiesPath("7931cbe6-7494-4c0b-95f0-9f4b34edc73b", "7681b4f8-7d10-4855-8c87-7fef3b408c0b")])
+			}
+
+			reader, err := client.NewReader(metadataPP.InputDirectory)
+			require.NoError(t, err)
+			assert.NoError(t, reader.Verify())
+			changes, err := reader.GetChanges()
+			require.NoError(t, err)
+			assert.Equal(t, map[string]run.ElementChanges{objectModelID: {Changed: []string{changedRecordID}}}, changes.Records)
+			assert.Empty(t, changes.Proxies)
+			objectProxies, err := reader.GetProxiesForModel("object")
+			require.NoError(t, err)
+			assert.Len(t, objectProxies, 2)
+			locationProxies, err := reader.GetProxiesForModel("location")
+			require.NoError(t, err)
+			assert.Len(t, locationProxies, 1)
+		})
+	}
+}
+
+func TestRun_Incremental_CopiesSnapshot(t *testing.T) {
+	datasetId := uuid.NewString()
+	integrationID := uuid.NewString()
+	sessionToken := uuid.NewString()
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+	mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+	defer mockServer.Close()
+
+	previousPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	require.NoError(t, previousPP.Run(context.Background()))
+	// a file of a model deleted since the snapshot is removed from the copy
+	deletedModelRecordsFilePath := paths.RecordsFilePath(uuid.NewString())
+	require.NoError(t, os.WriteFile(filepath.Join(previousPP.MetadataPath(), deletedModelRecordsFilePath), []byte(`[{"id": "deleted"}]`), 0644))
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.PreviousMetadataDirectory = previousPP.MetadataPath()
+	require.NoError(t, metadataPP.Run(context.Background()))
+	expectedFiles.AssertEqual(t, metadataPP.MetadataPath())
+	assert.NoFileExists(t, filepath.Join(metadataPP.MetadataPath(), deletedModelRecordsFilePath))
+	assert.FileExists(t, filepath.Join(previousPP.MetadataPath(), deletedModelRecordsFilePath))
+
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.NoError(t, reader.Verify())
+	changes, err := reader.GetChanges()
+	require.NoError(t, err)
+	require.NotNil(t, changes.Previous)
+	assert.Empty(t, changes.Models)
+	assert.Equal(t, map[string]run.ElementChanges{
+		strings.TrimSuffix(filepath.Base(deletedModelRecordsFilePath), ".json"): {Deleted: []string{"deleted"}},
+	}, changes.Records)
+	assert.Empty(t, changes.RelationshipInstances)
+	assert.Empty(t, changes.Proxies)
+}
+
+func TestRun_Incremental_NoSnapshot(t *testing.T) {
+	datasetId := uuid.NewString()
+	integrationID := uuid.NewString()
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+	mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+	defer mockServer.Close()
 
-	require.NoError(t, metadataPP.Run())
+	metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	metadataPP.PreviousMetadataDirectory = filepath.Join(t.TempDir(), paths.MetadataDirectory)
+	require.NoError(t, metadataPP.Run(context.Background()))
 	expectedFiles.AssertEqual(t, metadataPP.MetadataPath())
 
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	changes, err := reader.GetChanges()
+	require.NoError(t, err)
+	assert.Nil(t, changes.Previous)
+	assert.Len(t, changes.Models.Added, 3)
+	assert.Len(t, changes.Relationships.Added, 2)
+	assert.Equal(t, []string{"5b07e038-9829-46c9-b698-bf4efef81341", "a9b9d03b-19b3-4a43-b40e-5673ec955e49", "bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"},
+		changes.Records["bb04a8ce-03c9-4801-a0d9-e35cea53ac1b"].Added)
+	assert.Len(t, changes.Proxies, 2)
+}
+
+func TestRun_Incremental_ProxySettingsChanged(t *testing.T) {
+	for scenario, tt := range map[string]struct {
+		fetchProxies   bool
+		proxyAncestors bool
+	}{
+		"proxies off":        {fetchProxies: false, proxyAncestors: false},
+		"proxy ancestors on": {fetchProxies: true, proxyAncestors: true},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			datasetId := uuid.NewString()
+			integrationID := uuid.NewString()
+			inputDir := t.TempDir()
+			sessionToken := uuid.NewString()
+			expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+			mockServer := newMockServer(t, integrationID, datasetId, expectedFiles)
+			defer mockServer.Close()
+
+			previousPP, err := NewMetadataPreProcessor(integrationID, inputDir, t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+			require.NoError(t, err)
+			require.NoError(t, previousPP.Run(context.Background()))
+
+			metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+			require.NoError(t, err)
+			metadataPP.FetchProxies = tt.fetchProxies
+			metadataPP.ProxyAncestors = tt.proxyAncestors
+			metadataPP.PreviousMetadataDirectory = metadataPP.MetadataPath()
+			require.NoError(t, metadataPP.Run(context.Background()))
+
+			reader, err := client.NewReader(metadataPP.InputDirectory)
+			require.NoError(t, err)
+			assert.NoError(t, reader.Verify())
+			changes, err := reader.GetChanges()
+			require.NoError(t, err)
+			// the snapshot is not compared with, so no proxies are reported as changed or deleted
+			assert.Nil(t, changes.Previous)
+			for modelID, proxyChanges := range changes.Proxies {
+				assert.Empty(t, proxyChanges.Changed, modelID)
+				assert.Empty(t, proxyChanges.Deleted, modelID)
+			}
+			if tt.fetchProxies {
+				assert.Len(t, changes.Proxies, 2)
+			} else {
+				assert.Empty(t, changes.Proxies)
+				proxyModelDirectories, err := os.ReadDir(metadataPP.ProxiesPath())
+				require.NoError(t, err)
+				assert.Empty(t, proxyModelDirectories)
+			}
+		})
+	}
+}
+
+func TestRun_RemovesFilesNotWritten(t *testing.T) {
+	datasetId := uuid.NewString()
+	integrationID := uuid.NewString()
+	inputDir := t.TempDir()
+	sessionToken := uuid.NewString()
+	expectedFiles := fullDatasetExpectedFiles(t, datasetId)
+
+	// after the first run, the mock deletes the location model along with the relationship and linked property to it
+	locationModelID := "83964537-46d2-4fb5-9408-0b6262a42a56"
+	deletedElementIDs := []string{locationModelID, "30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d", "bbea65fd-b51f-464a-a5d3-dc228ff408c1"}
+	deleted := false
+	mux := newMockMux(t, integrationID, datasetId, expectedFiles)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if deleted && request.URL.Path == fmt.Sprintf("/models/datasets/%s/concepts/schema/graph", datasetId) {
+			graphSchemaBytes, err := os.ReadFile(filepath.Join("testdata", paths.SchemaFilePath))
+			require.NoError(t, err)
+			var graphSchema []map[string]any
+			require.NoError(t, json.Unmarshal(graphSchemaBytes, &graphSchema))
+			graphSchema = slices.DeleteFunc(graphSchema, func(element map[string]any) bool {
+				return slices.Contains(deletedElementIDs, element["id"].(string))
+			})
+			graphSchemaBytes, err = json.Marshal(graphSchema)
+			require.NoError(t, err)
+			_, err = writer.Write(graphSchemaBytes)
+			require.NoError(t, err)
+			return
+		}
+		mux.ServeHTTP(writer, request)
+	}))
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(integrationID, inputDir, t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	require.NoError(t, metadataPP.Run(context.Background()))
+	deletedFilePaths := []string{
+		paths.PropertiesFilePath(locationModelID),
+		paths.RecordsFilePath(locationModelID),
+		paths.ProxyInstancesFilePath(locationModelID, "e79e8d65-b094-4f36-94f2-1553cd84b4a2"),
+		paths.RelationshipInstancesFilePath(deletedElementIDs[1]),
+		paths.LinkedPropertyInstancesFilePath(deletedElementIDs[2]),
+	}
+	for _, deletedFilePath := range deletedFilePaths {
+		require.FileExists(t, filepath.Join(metadataPP.MetadataPath(), deletedFilePath))
+	}
+
+	deleted = true
+	metadataPP, err = NewMetadataPreProcessor(integrationID, inputDir, t.TempDir(), sessionToken, mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	require.NoError(t, metadataPP.Run(context.Background()))
+
+	for _, deletedFilePath := range deletedFilePaths {
+		assert.NoFileExists(t, filepath.Join(metadataPP.MetadataPath(), deletedFilePath))
+	}
+	assert.NoDirExists(t, filepath.Join(metadataPP.MetadataPath(), paths.ProxyInstancesForModelDirectory(locationModelID)))
+	manifestBytes, err := os.ReadFile(filepath.Join(metadataPP.MetadataPath(), paths.ManifestFilePath))
+	require.NoError(t, err)
+	var manifest run.Manifest
+	require.NoError(t, json.Unmarshal(manifestBytes, &manifest))
+	for _, file := range manifest.Files {
+		assert.NotContains(t, deletedFilePaths, file.Path)
+	}
+	reader, err := client.NewReader(metadataPP.InputDirectory)
+	require.NoError(t, err)
+	assert.NoError(t, reader.Verify())
+	assert.Equal(t, 2, reader.Schema.ModelCount())
+}
+
+func TestRun_FullRunRemovesChanges(t *testing.T) {
+	integrationID := uuid.NewString()
+	mockServer := newFailingMockServer(t, integrationID, uuid.NewString())
+	defer mockServer.Close()
+	metadataPP, err := NewMetadataPreProcessor(integrationID, t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, defaultRecordsBatchSize)
+	require.NoError(t, err)
+	require.NoError(t, metadataPP.MkDirectories())
+	changesFilePath := filepath.Join(metadataPP.MetadataPath(), paths.ChangesFilePath)
+	require.NoError(t, os.WriteFile(changesFilePath, []byte(`{}`), 0644))
+
+	require.Error(t, metadataPP.Run(context.Background()))
+	assert.NoFileExists(t, changesFilePath)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("connection reset")
+}
+
+func TestWriteResponse_KeepsExistingFileOnError(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "instances.json")
+	request := httptest.NewRequest(http.MethodGet, "/instances", nil)
+	response := &http.Response{Request: request, Body: io.NopCloser(strings.NewReader(`[{"id": 1}]`))}
+	_, err := WriteResponse(response, filePath)
+	require.NoError(t, err)
+	info, err := os.Stat(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	response = &http.Response{Request: request, Body: io.NopCloser(io.MultiReader(strings.NewReader(`[{"id": `), failingReader{}))}
+	_, err = WriteResponse(response, filePath)
+	require.Error(t, err)
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"id": 1}]`, string(content))
+	assertNoTempFiles(t, filepath.Dir(filePath))
+}
+
+func TestWriteRecords_Paging(t *testing.T) {
+	datasetID := uuid.NewString()
+	modelID := "bb04a8ce-03c9-4801-a0d9-e35cea53ac1b"
+	expectedBytes, err := os.ReadFile(filepath.Join("testdata", paths.RecordsFilePath(modelID)))
+	require.NoError(t, err)
+	var expectedRecords []json.RawMessage
+	require.NoError(t, json.Unmarshal(expectedBytes, &expectedRecords))
+	require.Len(t, expectedRecords, 3)
+
+	batchSize := 2
+	var requestedOffsets []int
+	mockServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		require.Equal(t, fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", datasetID, modelID), request.URL.Path)
+		require.Equal(t, strconv.Itoa(batchSize), request.URL.Query().Get("limit"))
+		offset, err := strconv.Atoi(request.URL.Query().Get("offset"))
+		require.NoError(t, err)
+		requestedOffsets = append(requestedOffsets, offset)
+		page := expectedRecords[min(offset, len(expectedRecords)):min(offset+batchSize, len(expectedRecords))]
+		pageBytes, err := json.Marshal(page)
+		require.NoError(t, err)
+		_, err = writer.Write(pageBytes)
+		require.NoError(t, err)
+	}))
+	defer mockServer.Close()
+
+	metadataPP, err := NewMetadataPreProcessor(uuid.NewString(), t.TempDir(), t.TempDir(), uuid.NewString(), mockServer.URL, mockServer.URL, batchSize)
+	require.NoError(t, err)
+	require.NoError(t, metadataPP.MkDirectories())
+
+	recordIDs, err := metadataPP.WriteRecords(context.Background(), metadataPP.MetadataPath(), datasetID, schema.Model{Element: schema.Element{ID: modelID}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, requestedOffsets)
+	assert.Equal(t, []string{
+		"5b07e038-9829-46c9-b698-bf4efef81341",
+		"bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c",
+		"a9b9d03b-19b3-4a43-b40e-5673ec955e49",
+	}, recordIDs)
+
+	actualBytes, err := os.ReadFile(filepath.Join(metadataPP.MetadataPath(), paths.RecordsFilePath(modelID)))
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expectedBytes), string(actualBytes))
+}
+
+func TestJSONArrayWriter_Empty(t *testing.T) {
+	var buffer bytes.Buffer
+	arrayWriter := NewJSONArrayWriter(&buffer)
+	require.NoError(t, arrayWriter.Close())
+	assert.Equal(t, "[]", buffer.String())
+	assert.Equal(t, 0, arrayWriter.Count())
+	assert.Equal(t, int64(2), arrayWriter.Written())
 }
 
 type ExpectedFile struct {
 	// TestdataPath is the path relative to the testdata directory  (which should be the same as the path relative to the metadata directory in the input directory)
+	// If empty, there is no expected file, only a response.
 	TestdataPath string
-	Bytes        []byte
-	Content      any
+	// ResponseTestdataPath is the path relative to the testdata directory of the response, if it differs from the file written.
+	ResponseTestdataPath string
+	Bytes                []byte
+	Content              any
 	// APIPath is the request path the mock server will match against.
 	APIPath             string
 	QueryParams         url.Values
 	ExpectFileNotExists bool
+	// StatusCode is the status the mock server will respond with. Defaults to http.StatusOK
+	StatusCode int
+	// ExpectNoCall means the test fails if APIPath is requested
+	ExpectNoCall bool
+	// PerRecordProxy means APIPath is only expected to be requested when proxies are looked up per record
+	PerRecordProxy bool
 }
 
 func (e ExpectedFile) HandlerFunc(t *testing.T) func(http.ResponseWriter, *http.Request) {
 	return func(writer http.ResponseWriter, request *http.Request) {
+		require.False(t, e.ExpectNoCall, "unexpected call to %s", request.URL)
 		require.Equal(t, http.MethodGet, request.Method, "expected method %s for %s, got %s", http.MethodGet, request.URL, request.Method)
 		if e.QueryParams != nil {
 			require.Equal(t, e.QueryParams, request.URL.Query(), "expected query %s for %s, got %s", e.QueryParams, request.URL, request.URL.Query())
 		}
+		if e.StatusCode != 0 {
+			writer.WriteHeader(e.StatusCode)
+		}
 		_, err := writer.Write(e.Bytes)
 		require.NoError(t, err)
 	}
 }
 
+// proxyRelationshipID is the id of the belongs_to relationship in testdata/schema/relationships.json
+const proxyRelationshipID = "e18a8519-8368-4062-977a-60707c9c93ec"
+
+// fullDatasetExpectedFiles are the expected files of a run exporting every model, relationship and linked property of
+// the testdata dataset. Tests adjust the result for whatever their run does differently.
+func fullDatasetExpectedFiles(t *testing.T, datasetId string) *ExpectedFiles {
+	return NewExpectedFiles(datasetId).WithModels(
+		"7931cbe6-7494-4c0b-95f0-9f4b34edc73b",
+		"83964537-46d2-4fb5-9408-0b6262a42a56",
+		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b",
+	).WithSchemaRelationships(
+		"30e7861f-ebae-4cf8-b9bc-2d6b1ae6008d",
+		"2514a023-17fe-4743-af5f-094ed3dd339c",
+	).WithSchemaLinkedProperties(
+		"bbea65fd-b51f-464a-a5d3-dc228ff408c1",
+	).WithProxies(map[string][]string{
+		"83964537-46d2-4fb5-9408-0b6262a42a56": {"e79e8d65-b094-4f36-94f2-1553cd84b4a2"},
+		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b": {"a9b9d03b-19b3-4a43-b40e-5673ec955e49", "bcf06e0c-42dc-4ce9-9c70-9ee6865ebc7c"}},
+	).WithNoProxies(map[string][]string{
+		"7931cbe6-7494-4c0b-95f0-9f4b34edc73b": {"7681b4f8-7d10-4855-8c87-7fef3b408c0b"},
+		"bb04a8ce-03c9-4801-a0d9-e35cea53ac1b": {"5b07e038-9829-46c9-b698-bf4efef81341"},
+	}).Build(t)
+}
+
 type ExpectedFiles struct {
 	DatasetID string
 	Files     []ExpectedFile
+	// ProxyLinks and Packages are the bulk proxy responses built from the proxy testdata files passed to WithProxies
+	ProxyLinks []pennsieve.ProxyLink
+	Packages   map[string]json.RawMessage
+	// PackageAncestors are added to the Packages responses when ancestors are requested. A package not in the map has no ancestors.
+	PackageAncestors map[string]json.RawMessage
+	// ProxyLinksStatusCode is the status the mock server will respond with for the proxy links. Defaults to http.StatusOK
+	ProxyLinksStatusCode int
+	// ProxyMode is the ProxyMode the pre-processor is expected to use. Defaults to BulkProxyMode
+	ProxyMode ProxyMode
+	// IntegrationParams and IntegrationPackageIDs are returned as the Params and PackageIDs of the mock integration
+	IntegrationParams     any
+	IntegrationPackageIDs []string
+
+	proxyRecords [][2]string
+	// unexportedModelIDs are left out of the expected schema files, along with the relationships from or to them
+	unexportedModelIDs map[string]bool
 }
 
 func NewExpectedFiles(datasetID string) *ExpectedFiles {
 	return &ExpectedFiles{
 		DatasetID: datasetID,
 		Files: []ExpectedFile{
+			{TestdataPath: paths.DatasetFilePath, APIPath: fmt.Sprintf("/datasets/%s", datasetID), ResponseTestdataPath: filepath.Join("api", "dataset.json")},
+			{APIPath: fmt.Sprintf("/datasets/%s/contributors", datasetID), ResponseTestdataPath: filepath.Join("api", "contributors.json")},
 			{TestdataPath: paths.SchemaFilePath, APIPath: fmt.Sprintf("/models/datasets/%s/concepts/schema/graph", datasetID)},
 			{TestdataPath: paths.RelationshipSchemasFilePath, APIPath: fmt.Sprintf("/models/datasets/%s/relationships", datasetID)},
 		},
@@ -101,6 +1209,68 @@ func (e *ExpectedFiles) WithModels(modelIDs ...string) *ExpectedFiles {
 	return e
 }
 
+// WithDeletedModels adds models whose properties endpoint returns 404, as if they were deleted after the graph schema
+// was fetched. No other calls are expected for these models, and no files should be written for them.
+func (e *ExpectedFiles) WithDeletedModels(modelIDs ...string) *ExpectedFiles {
+	for _, modelID := range modelIDs {
+		e.unexportModel(modelID)
+		e.Files = append(e.Files, ExpectedFile{
+			TestdataPath:        paths.PropertiesFilePath(modelID),
+			APIPath:             fmt.Sprintf("/models/datasets/%s/concepts/%s/properties", e.DatasetID, modelID),
+			Bytes:               json.RawMessage(`{"type": "NotFound", "message": "model not found", "code": 404}`),
+			StatusCode:          http.StatusNotFound,
+			ExpectFileNotExists: true,
+		}, ExpectedFile{
+			TestdataPath:        paths.RecordsFilePath(modelID),
+			APIPath:             fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", e.DatasetID, modelID),
+			ExpectFileNotExists: true,
+			ExpectNoCall:        true,
+		})
+	}
+	return e
+}
+
+// WithUnexportedModels adds models that are in the graph schema but are not selected for export.
+// No calls are expected for these models, and no files should be written for them.
+func (e *ExpectedFiles) WithUnexportedModels(modelIDs ...string) *ExpectedFiles {
+	for _, modelID := range modelIDs {
+		e.unexportModel(modelID)
+		e.Files = append(e.Files, ExpectedFile{
+			TestdataPath:        paths.PropertiesFilePath(modelID),
+			APIPath:             fmt.Sprintf("/models/datasets/%s/concepts/%s/properties", e.DatasetID, modelID),
+			ExpectFileNotExists: true,
+			ExpectNoCall:        true,
+		}, ExpectedFile{
+			TestdataPath:        paths.RecordsFilePath(modelID),
+			APIPath:             fmt.Sprintf("/models/datasets/%s/concepts/%s/instances", e.DatasetID, modelID),
+			ExpectFileNotExists: true,
+			ExpectNoCall:        true,
+		})
+	}
+	return e
+}
+
+func (e *ExpectedFiles) unexportModel(modelID string) {
+	if e.unexportedModelIDs == nil {
+		e.unexportedModelIDs = map[string]bool{}
+	}
+	e.unexportedModelIDs[modelID] = true
+}
+
+// WithUnexportedRelationships adds schema relationships or linked properties that should not be fetched because
+// one of their ends is not exported.
+func (e *ExpectedFiles) WithUnexportedRelationships(schemaRelationshipIDs ...string) *ExpectedFiles {
+	for _, schemaRelationshipID := range schemaRelationshipIDs {
+		e.Files = append(e.Files, ExpectedFile{
+			TestdataPath:        paths.RelationshipInstancesFilePath(schemaRelationshipID),
+			APIPath:             fmt.Sprintf("/models/datasets/%s/relationships/%s/instances", e.DatasetID, schemaRelationshipID),
+			ExpectFileNotExists: true,
+			ExpectNoCall:        true,
+		})
+	}
+	return e
+}
+
 func (e *ExpectedFiles) WithSchemaRelationships(schemaRelationshipsIDs ...string) *ExpectedFiles {
 	for _, schemaRelationshipID := range schemaRelationshipsIDs {
 		e.Files = append(e.Files, ExpectedFile{
@@ -125,9 +1295,11 @@ func (e *ExpectedFiles) WithProxies(modelIDToRecordIDs map[string][]string) *Exp
 	for modelID, recordIDs := range modelIDToRecordIDs {
 		for _, recordID := range recordIDs {
 			e.Files = append(e.Files, ExpectedFile{
-				TestdataPath: paths.ProxyInstancesFilePath(modelID, recordID),
-				APIPath:      fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/files", e.DatasetID, modelID, recordID),
+				TestdataPath:   paths.ProxyInstancesFilePath(modelID, recordID),
+				APIPath:        fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/files", e.DatasetID, modelID, recordID),
+				PerRecordProxy: true,
 			})
+			e.proxyRecords = append(e.proxyRecords, [2]string{modelID, recordID})
 		}
 	}
 	return e
@@ -141,6 +1313,7 @@ func (e *ExpectedFiles) WithNoProxies(modelIDToRecordIDs map[string][]string) *E
 				APIPath:             fmt.Sprintf("/models/datasets/%s/concepts/%s/instances/%s/files", e.DatasetID, modelID, recordID),
 				Bytes:               json.RawMessage("[]"),
 				ExpectFileNotExists: true,
+				PerRecordProxy:      true,
 			})
 		}
 	}
@@ -150,19 +1323,69 @@ func (e *ExpectedFiles) WithNoProxies(modelIDToRecordIDs map[string][]string) *E
 func (e *ExpectedFiles) Build(t *testing.T) *ExpectedFiles {
 	for i := range e.Files {
 		expected := &e.Files[i]
-		if !expected.ExpectFileNotExists {
+		if len(expected.TestdataPath) > 0 && !expected.ExpectFileNotExists {
 			file := filepath.Join("testdata", expected.TestdataPath)
 			bytes, err := os.ReadFile(file)
 			require.NoError(t, err)
 			expected.Bytes = bytes
 			require.NoError(t, json.Unmarshal(bytes, &expected.Content))
+			if expected.TestdataPath == paths.SchemaFilePath || expected.TestdataPath == paths.RelationshipSchemasFilePath {
+				expected.Content = e.exportedSchema(expected.Content.([]any))
+			}
+		}
+		if len(expected.ResponseTestdataPath) > 0 {
+			bytes, err := os.ReadFile(filepath.Join("testdata", expected.ResponseTestdataPath))
+			require.NoError(t, err)
+			expected.Bytes = bytes
+		}
+	}
+	e.Packages = map[string]json.RawMessage{}
+	for _, proxyRecord := range e.proxyRecords {
+		modelID, recordID := proxyRecord[0], proxyRecord[1]
+		bytes, err := os.ReadFile(filepath.Join("testdata", paths.ProxyInstancesFilePath(modelID, recordID)))
+		require.NoError(t, err)
+		var proxies []instance.RawFromFile
+		require.NoError(t, json.Unmarshal(bytes, &proxies))
+		for _, proxy := range proxies {
+			var proxyID instance.ProxyID
+			require.NoError(t, json.Unmarshal(proxy[0], &proxyID))
+			var proxyPackage instance.ProxyPackage
+			require.NoError(t, json.Unmarshal(proxy[1], &proxyPackage))
+			e.ProxyLinks = append(e.ProxyLinks, pennsieve.ProxyLink{
+				ID:            proxyID.ID,
+				RecordID:      recordID,
+				PackageNodeID: proxyPackage.Content.NodeID,
+			})
+			e.Packages[proxyPackage.Content.NodeID] = proxy[1]
 		}
 	}
 	return e
 }
 
+// exportedSchema returns the elements of a schema file that are not, and are not from or to, an unexported model
+func (e *ExpectedFiles) exportedSchema(elements []any) []any {
+	exported := []any{}
+	for _, element := range elements {
+		elementMap := element.(map[string]any)
+		if e.unexportedModelIDs[elementMap["id"].(string)] {
+			continue
+		}
+		if from, isString := elementMap["from"].(string); isString && e.unexportedModelIDs[from] {
+			continue
+		}
+		if to, isString := elementMap["to"].(string); isString && e.unexportedModelIDs[to] {
+			continue
+		}
+		exported = append(exported, element)
+	}
+	return exported
+}
+
 func (e *ExpectedFiles) AssertEqual(t *testing.T, actualDir string) {
 	for _, expectedFile := range e.Files {
+		if len(expectedFile.TestdataPath) == 0 {
+			continue
+		}
 		actualFilePath := filepath.Join(actualDir, expectedFile.TestdataPath)
 		if expectedFile.ExpectFileNotExists {
 			assert.NoFileExists(t, actualFilePath)
@@ -180,6 +1403,22 @@ func (e *ExpectedFiles) AssertEqual(t *testing.T, actualDir string) {
 }
 
 func newMockServer(t *testing.T, integrationID string, datasetID string, expectedFiles *ExpectedFiles) *httptest.Server {
+	return httptest.NewServer(newMockMux(t, integrationID, datasetID, expectedFiles))
+}
+
+// newFailingMockServer serves the integration of datasetID, and responds to every other request with 400
+func newFailingMockServer(t *testing.T, integrationID string, datasetID string) *httptest.Server {
+	mux := newMockMux(t, integrationID, datasetID, NewExpectedFiles(datasetID))
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != fmt.Sprintf("/integrations/%s", integrationID) {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mux.ServeHTTP(writer, request)
+	}))
+}
+
+func newMockMux(t *testing.T, integrationID string, datasetID string, expectedFiles *ExpectedFiles) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc(fmt.Sprintf("/integrations/%s", integrationID), func(writer http.ResponseWriter, request *http.Request) {
 		require.Equal(t, http.MethodGet, request.Method, "expected method %s for %s, got %s", http.MethodGet, request.URL, request.Method)
@@ -187,19 +1426,56 @@ func newMockServer(t *testing.T, integrationID string, datasetID string, expecte
 			Uuid:          uuid.NewString(),
 			ApplicationID: 0,
 			DatasetNodeID: datasetID,
-			PackageIDs:    nil,
-			Params:        nil,
+			PackageIDs:    expectedFiles.IntegrationPackageIDs,
+			Params:        expectedFiles.IntegrationParams,
 		}
 		integrationResponse, err := json.Marshal(integration)
 		require.NoError(t, err)
 		_, err = writer.Write(integrationResponse)
 		require.NoError(t, err)
 	})
+	// per-record proxy lookups are expected in per-record mode, or in bulk mode if Pennsieve does not serve the proxy links
+	perRecordProxies := expectedFiles.ProxyMode == PerRecordProxyMode || proxyLinksUnsupported(&pennsieve.APIError{StatusCode: expectedFiles.ProxyLinksStatusCode})
 	for _, expectedFile := range expectedFiles.Files {
+		if expectedFile.PerRecordProxy && !perRecordProxies {
+			expectedFile.ExpectNoCall = true
+		}
 		mux.HandleFunc(expectedFile.APIPath, expectedFile.HandlerFunc(t))
 	}
+	mux.HandleFunc(fmt.Sprintf("/models/datasets/%s/relationships/%s/instances", datasetID, proxyRelationshipID), func(writer http.ResponseWriter, request *http.Request) {
+		require.NotEqual(t, PerRecordProxyMode, expectedFiles.ProxyMode, "unexpected call to %s in per-record proxy mode", request.URL)
+		require.Equal(t, http.MethodGet, request.Method, "expected method %s for %s, got %s", http.MethodGet, request.URL, request.Method)
+		if expectedFiles.ProxyLinksStatusCode != 0 {
+			writer.WriteHeader(expectedFiles.ProxyLinksStatusCode)
+			return
+		}
+		linksResponse, err := json.Marshal(expectedFiles.ProxyLinks)
+		require.NoError(t, err)
+		_, err = writer.Write(linksResponse)
+		require.NoError(t, err)
+	})
+	for packageNodeID, packageBytes := range expectedFiles.Packages {
+		packageNodeID, packageBytes := packageNodeID, packageBytes
+		mux.HandleFunc(fmt.Sprintf("/packages/%s", packageNodeID), func(writer http.ResponseWriter, request *http.Request) {
+			require.Equal(t, http.MethodGet, request.Method, "expected method %s for %s, got %s", http.MethodGet, request.URL, request.Method)
+			responseBytes := packageBytes
+			if request.URL.Query().Get("includeAncestors") == "true" {
+				var pkg map[string]json.RawMessage
+				require.NoError(t, json.Unmarshal(packageBytes, &pkg))
+				pkg["ancestors"] = json.RawMessage("[]")
+				if ancestors, hasAncestors := expectedFiles.PackageAncestors[packageNodeID]; hasAncestors {
+					pkg["ancestors"] = ancestors
+				}
+				var err error
+				responseBytes, err = json.Marshal(pkg)
+				require.NoError(t, err)
+			}
+			_, err := writer.Write(responseBytes)
+			require.NoError(t, err)
+		})
+	}
 	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		require.Fail(t, "unexpected call to Pennsieve", "%s %s", request.Method, request.URL)
 	})
-	return httptest.NewServer(mux)
+	return mux
 }
@@ -0,0 +1,328 @@
+package preprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"github.com/pennsieve/processor-pre-metadata/service/pennsieve"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// ProxyMode is how the package proxies of records are downloaded
+type ProxyMode string
+
+const (
+	// BulkProxyMode downloads every package proxy link in the dataset with one request, and then each distinct
+	// linked package once. Falls back to PerRecordProxyMode if Pennsieve responds to the links request with 400, 405
+	// or 501, as a deployment without the links endpoint does.
+	BulkProxyMode ProxyMode = "bulk"
+	// PerRecordProxyMode makes one request per record, whether the record has proxies or not
+	PerRecordProxyMode ProxyMode = "per-record"
+)
+
+// ProxyModeFromEnv returns the ProxyMode set by PROXY_MODE, or BulkProxyMode if it is not set.
+func ProxyModeFromEnv() (ProxyMode, error) {
+	value := os.Getenv("PROXY_MODE")
+	if len(value) == 0 {
+		return BulkProxyMode, nil
+	}
+	switch mode := ProxyMode(value); mode {
+	case BulkProxyMode, PerRecordProxyMode:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("PROXY_MODE value %q is not one of %q or %q", value, BulkProxyMode, PerRecordProxyMode)
+	}
+}
+
+// WriteAllProxies writes the package proxies of the records written for the given models. recordIDs[i] holds the IDs of the
+// records written for schemaElements.Models[i]. If not nil, modelWritten is called with i once the proxies of every
+// record of schemaElements.Models[i] are written.
+func (m *MetadataPreProcessor) WriteAllProxies(ctx context.Context, metadataDirectory, datasetID string, schemaElements schema.Elements, recordIDs [][]string, modelWritten func(i int) error) error {
+	if modelWritten == nil {
+		modelWritten = func(int) error { return nil }
+	}
+	if m.ProxyMode != PerRecordProxyMode {
+		if schemaElements.Proxy == nil {
+			logger.Info("dataset has no package proxy relationship; no proxy instances to write")
+			for i := range schemaElements.Models {
+				if err := modelWritten(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		links, err := m.Pennsieve.GetProxyLinks(ctx, datasetID, schemaElements.Proxy.ID)
+		if err == nil {
+			return m.WriteProxiesFromLinks(ctx, metadataDirectory, schemaElements.Models, recordIDs, links, modelWritten)
+		}
+		if !proxyLinksUnsupported(err) {
+			return fmt.Errorf("error getting package proxy links: %w", err)
+		}
+		logger.Warn("package proxy links not available; falling back to per-record proxy lookups", slog.Any("error", err))
+	}
+	group := newFetchGroup(ctx, m.FetchConcurrency)
+	for i, model := range schemaElements.Models {
+		i := i
+		m.goWriteProxies(group, metadataDirectory, datasetID, model.ID, recordIDs[i], func() error {
+			return modelWritten(i)
+		})
+	}
+	return group.Wait()
+}
+
+// proxyLinksUnsupported returns true if err, returned by GetProxyLinks, is a status with which a Pennsieve
+// deployment without the package proxy links endpoint responds, rather than a failure of the request. A 404 is not
+// one of them, since it may mean the proxy relationship was deleted during the run.
+func proxyLinksUnsupported(err error) bool {
+	var apiError *pennsieve.APIError
+	if !errors.As(err, &apiError) {
+		return false
+	}
+	switch apiError.StatusCode {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
+
+// WriteProxiesFromLinks writes the same proxy instance files as WriteProxies, but from the given package proxy links.
+// Each distinct linked package is downloaded once, m.FetchConcurrency at a time. Links to records that were not written
+// and to packages that no longer exist are skipped. modelWritten is as for WriteAllProxies.
+func (m *MetadataPreProcessor) WriteProxiesFromLinks(ctx context.Context, metadataDirectory string, models []schema.Model, recordIDs [][]string, links []pennsieve.ProxyLink, modelWritten func(i int) error) error {
+	if modelWritten == nil {
+		modelWritten = func(int) error { return nil }
+	}
+	recordModelIDs := map[string]string{}
+	for i, model := range models {
+		for _, recordID := range recordIDs[i] {
+			recordModelIDs[recordID] = model.ID
+		}
+	}
+	linksByRecordID := map[string][]pennsieve.ProxyLink{}
+	var packageNodeIDs []string
+	seenPackages := map[string]bool{}
+	skippedLinks := 0
+	for _, link := range links {
+		if _, written := recordModelIDs[link.RecordID]; !written {
+			skippedLinks++
+			continue
+		}
+		linksByRecordID[link.RecordID] = append(linksByRecordID[link.RecordID], link)
+		if !seenPackages[link.PackageNodeID] {
+			seenPackages[link.PackageNodeID] = true
+			packageNodeIDs = append(packageNodeIDs, link.PackageNodeID)
+		}
+	}
+	logger.Info("got package proxy links",
+		slog.Int("count", len(links)),
+		slog.Int("records", len(linksByRecordID)),
+		slog.Int("packages", len(packageNodeIDs)),
+		slog.Int("skipped", skippedLinks))
+
+	packages, err := m.getPackages(ctx, packageNodeIDs)
+	if err != nil {
+		return err
+	}
+
+	for i, model := range models {
+		for _, recordID := range recordIDs[i] {
+			recordLinks, hasLinks := linksByRecordID[recordID]
+			if !hasLinks {
+				continue
+			}
+			// same layout as the response to GetProxyInstancesForRecord
+			var proxies []any
+			for _, link := range recordLinks {
+				if pkg, found := packages[link.PackageNodeID]; found {
+					proxies = append(proxies, []any{map[string]any{"id": link.ID}, pkg})
+				}
+			}
+			if err := m.writeRecordProxiesFile(metadataDirectory, model.ID, recordID, proxies); err != nil {
+				return err
+			}
+		}
+		if err := modelWritten(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPackages returns a map from package node id to package. Packages that are not found are left out of the map.
+func (m *MetadataPreProcessor) getPackages(ctx context.Context, packageNodeIDs []string) (map[string]map[string]any, error) {
+	var packagesMutex sync.Mutex
+	packages := make(map[string]map[string]any, len(packageNodeIDs))
+	group := newFetchGroup(ctx, m.FetchConcurrency)
+	for _, packageNodeID := range packageNodeIDs {
+		packageNodeID := packageNodeID
+		group.Go(func(ctx context.Context) error {
+			pkg, err := m.Pennsieve.GetPackage(ctx, packageNodeID, m.ProxyAncestors)
+			if err != nil {
+				if errors.Is(err, pennsieve.ErrNotFound) {
+					logger.Warn("package not found; it may have been deleted during the run. Skipping its proxies",
+						slog.String("packageID", packageNodeID),
+						slog.Any("error", err))
+					return nil
+				}
+				return fmt.Errorf("error getting package %s: %w", packageNodeID, err)
+			}
+			packagesMutex.Lock()
+			defer packagesMutex.Unlock()
+			packages[packageNodeID] = pkg
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return packages, nil
+}
+
+// WriteProxies writes the package proxies of each of the given records, looking up m.FetchConcurrency records at a time.
+func (m *MetadataPreProcessor) WriteProxies(ctx context.Context, metadataDirectory, datasetID, modelID string, recordIDs []string) error {
+	group := newFetchGroup(ctx, m.FetchConcurrency)
+	m.goWriteProxies(group, metadataDirectory, datasetID, modelID, recordIDs, nil)
+	return group.Wait()
+}
+
+// goWriteProxies starts writing the proxies of each record in group. If not nil, written is called once the proxies
+// of every record are written.
+func (m *MetadataPreProcessor) goWriteProxies(group *fetchGroup, metadataDirectory, datasetID, modelID string, recordIDs []string, written func() error) {
+	if written == nil {
+		written = func() error { return nil }
+	}
+	if len(recordIDs) == 0 {
+		group.Go(func(context.Context) error {
+			return written()
+		})
+		return
+	}
+	var remaining atomic.Int64
+	remaining.Store(int64(len(recordIDs)))
+	for _, recordID := range recordIDs {
+		recordID := recordID
+		group.Go(func(ctx context.Context) error {
+			if err := m.WriteRecordProxies(ctx, metadataDirectory, datasetID, modelID, recordID); err != nil {
+				return err
+			}
+			if remaining.Add(-1) == 0 {
+				return written()
+			}
+			return nil
+		})
+	}
+}
+
+func (m *MetadataPreProcessor) WriteRecordProxies(ctx context.Context, metadataDirectory, datasetID, modelID string, recordID string) error {
+	recordLogger := logger.With(slog.String("recordID", recordID))
+	proxies, err := m.Pennsieve.GetProxyInstancesForRecord(ctx, datasetID, modelID, recordID)
+	if err != nil {
+		if errors.Is(err, pennsieve.ErrNotFound) {
+			recordLogger.Warn("record not found when getting proxies; it may have been deleted during the run. Skipping it",
+				slog.Any("error", err))
+			return nil
+		}
+		return fmt.Errorf("error getting proxy instances for model %s record %s: %w", modelID, recordID, err)
+	}
+	if m.ProxyAncestors {
+		if err := m.addAncestors(ctx, proxies); err != nil {
+			return err
+		}
+	}
+	return m.writeRecordProxiesFile(metadataDirectory, modelID, recordID, proxies)
+}
+
+func (m *MetadataPreProcessor) writeRecordProxiesFile(metadataDirectory, modelID, recordID string, proxies []any) error {
+	recordLogger := logger.With(slog.String("recordID", recordID))
+	if len(proxies) == 0 {
+		recordLogger.Info("no proxy instances for record")
+		return nil
+	}
+	proxyInstanceFilePath := filepath.Join(metadataDirectory, paths.ProxyInstancesFilePath(modelID, recordID))
+	m.written.add(paths.ProxyInstancesFilePath(modelID, recordID))
+	if unchanged, err := m.changes.compareProxies(modelID, recordID, proxies); err != nil {
+		return err
+	} else if unchanged {
+		recordLogger.Info("proxy instances unchanged", slog.String("path", proxyInstanceFilePath))
+		return nil
+	}
+	directory := filepath.Dir(proxyInstanceFilePath)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return fmt.Errorf("error creating proxy instance directory %s: %w", directory, err)
+	}
+	sz, err := WriteJSON(proxyInstanceFilePath, proxies)
+	if err != nil {
+		return fmt.Errorf("error writing/decoding proxy instances for %s to %s: %w", recordID, proxyInstanceFilePath, err)
+	}
+	recordLogger.Info("wrote proxy instances",
+		slog.String("path", proxyInstanceFilePath),
+		slog.Int64("count", sz),
+	)
+	return nil
+}
+
+// addAncestors adds the "ancestors" of each proxy package returned by GetProxyInstancesForRecord. The ancestors of
+// each package are only requested once per run.
+func (m *MetadataPreProcessor) addAncestors(ctx context.Context, proxies []any) error {
+	for _, proxy := range proxies {
+		proxyPair, isPair := proxy.([]any)
+		if !isPair || len(proxyPair) != 2 {
+			return fmt.Errorf("unexpected proxy instance format: %v", proxy)
+		}
+		pkg, isMap := proxyPair[1].(map[string]any)
+		if !isMap {
+			return fmt.Errorf("unexpected proxy package format: %v", proxyPair[1])
+		}
+		content, _ := pkg["content"].(map[string]any)
+		packageNodeID, _ := content["nodeId"].(string)
+		if len(packageNodeID) == 0 {
+			return fmt.Errorf("no nodeId in proxy package content: %v", content)
+		}
+		ancestors, found, err := m.packageAncestors(ctx, packageNodeID)
+		if err != nil {
+			return err
+		}
+		if found {
+			pkg["ancestors"] = ancestors
+		}
+	}
+	return nil
+}
+
+// packageAncestors returns the ancestors of the given package, and false if the package was not found.
+func (m *MetadataPreProcessor) packageAncestors(ctx context.Context, packageNodeID string) (ancestors any, found bool, err error) {
+	m.ancestorsMutex.Lock()
+	ancestors, found = m.ancestorsByPackage[packageNodeID]
+	m.ancestorsMutex.Unlock()
+	if found {
+		return ancestors, ancestors != nil, nil
+	}
+
+	pkg, err := m.Pennsieve.GetPackage(ctx, packageNodeID, true)
+	if err != nil {
+		if !errors.Is(err, pennsieve.ErrNotFound) {
+			return nil, false, fmt.Errorf("error getting ancestors of package %s: %w", packageNodeID, err)
+		}
+		logger.Warn("package not found when getting ancestors; it may have been deleted during the run",
+			slog.String("packageID", packageNodeID),
+			slog.Any("error", err))
+	} else if ancestors = pkg["ancestors"]; ancestors == nil {
+		ancestors = []any{}
+	}
+	m.ancestorsMutex.Lock()
+	defer m.ancestorsMutex.Unlock()
+	if m.ancestorsByPackage == nil {
+		m.ancestorsByPackage = map[string]any{}
+	}
+	// nil records that the package was not found
+	m.ancestorsByPackage[packageNodeID] = ancestors
+	return ancestors, ancestors != nil, nil
+}
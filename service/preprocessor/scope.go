@@ -0,0 +1,340 @@
+package preprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/models/schema"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"github.com/pennsieve/processor-pre-metadata/service/pennsieve"
+	"github.com/pennsieve/processor-pre-metadata/service/util"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Scope is how much of the dataset's metadata graph a run exports
+type Scope string
+
+const (
+	// DatasetScope exports the whole metadata graph of the dataset
+	DatasetScope Scope = "dataset"
+	// PackagesScope exports only the records linked to the integration's packages, and the records within
+	// ScopeHops relationships or linked properties of those, in either direction.
+	PackagesScope Scope = "packages"
+)
+
+const defaultScopeHops = 1
+
+// ScopeFromEnv returns the Scope set by SCOPE, or DatasetScope if it is not set.
+func ScopeFromEnv() (Scope, error) {
+	value := os.Getenv("SCOPE")
+	if len(value) == 0 {
+		return DatasetScope, nil
+	}
+	switch scope := Scope(value); scope {
+	case DatasetScope, PackagesScope:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("SCOPE value %q is not one of %q or %q", value, DatasetScope, PackagesScope)
+	}
+}
+
+// relationshipInstance is an instance of a schema relationship or linked property. Raw is written to the
+// instances file as is; From and To are the linked record IDs.
+type relationshipInstance struct {
+	Raw  json.RawMessage
+	From string
+	To   string
+}
+
+// WriteScopedInstances is used in place of WriteInstances when m.Scope is PackagesScope. Records, relationship instances,
+// linked property instances, and proxies are written for the records within m.ScopeHops of the records linked to
+// m.PackageIDs. The schema files already written by WriteGraphSchema are then reduced to the models with at least one
+// of these records and the relationships and linked properties between those models, so that the metadata directory has
+// the same layout as an export of the whole dataset.
+func (m *MetadataPreProcessor) WriteScopedInstances(ctx context.Context, metadataDirectory string, datasetID string, schemaElements schema.Elements) error {
+	if len(m.PackageIDs) == 0 {
+		return fmt.Errorf("scope %q requires package ids, but the integration has none", PackagesScope)
+	}
+	var links []pennsieve.ProxyLink
+	if schemaElements.Proxy == nil {
+		logger.Warn("dataset has no package proxy relationship; no records are linked to the integration's packages")
+	} else {
+		var err error
+		if links, err = m.Pennsieve.GetProxyLinks(ctx, datasetID, schemaElements.Proxy.ID); err != nil {
+			return fmt.Errorf("error getting package proxy links for scoped export: %w", err)
+		}
+	}
+	scopePackageIDs := map[string]bool{}
+	for _, packageID := range m.PackageIDs {
+		scopePackageIDs[packageID] = true
+	}
+	var startRecordIDs []string
+	for _, link := range links {
+		if scopePackageIDs[link.PackageNodeID] {
+			startRecordIDs = append(startRecordIDs, link.RecordID)
+		}
+	}
+
+	instances, err := m.getRelationshipInstances(ctx, datasetID, schemaElements)
+	if err != nil {
+		return err
+	}
+	reachable := reachableRecords(startRecordIDs, instances, m.ScopeHops)
+	logger.Info("computed export scope",
+		slog.Any("packageIDs", m.PackageIDs),
+		slog.Int("hops", m.ScopeHops),
+		slog.Int("linkedRecords", len(startRecordIDs)),
+		slog.Int("reachableRecords", len(reachable)))
+
+	recordIDs := make([][]string, len(schemaElements.Models))
+	group := newFetchGroup(ctx, m.FetchConcurrency)
+	for i, model := range schemaElements.Models {
+		i, model := i, model
+		group.Go(func(ctx context.Context) error {
+			ids, err := m.writeRecords(ctx, metadataDirectory, datasetID, model, reachable)
+			if err != nil {
+				if errors.Is(err, pennsieve.ErrNotFound) {
+					model.Logger(logger).Warn("model not found when getting records; it may have been deleted during the run. Skipping it",
+						slog.Any("error", err))
+					return nil
+				}
+				return err
+			}
+			recordIDs[i] = ids
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	scoped := schema.Elements{Proxy: schemaElements.Proxy}
+	var scopedRecordIDs [][]string
+	scopedModelIDs := map[string]bool{}
+	for i, model := range schemaElements.Models {
+		if len(recordIDs[i]) == 0 {
+			model.Logger(logger).Info("no records of model in scope. Leaving it out of the export")
+			if err := removeIfExists(filepath.Join(metadataDirectory, paths.RecordsFilePath(model.ID))); err != nil {
+				return err
+			}
+			if err := removeIfExists(filepath.Join(metadataDirectory, paths.PropertiesFilePath(model.ID))); err != nil {
+				return err
+			}
+			continue
+		}
+		scoped.Models = append(scoped.Models, model)
+		scopedRecordIDs = append(scopedRecordIDs, recordIDs[i])
+		scopedModelIDs[model.ID] = true
+	}
+	for _, relationship := range schemaElements.Relationships {
+		relationshipInstances, found := instances[relationship.ID]
+		if !found || !scopedModelIDs[relationship.From] || !scopedModelIDs[relationship.To] {
+			continue
+		}
+		filePath := filepath.Join(metadataDirectory, paths.RelationshipInstancesFilePath(relationship.ID))
+		if err := writeScopedRelationshipInstances(filePath, relationshipInstances, reachable); err != nil {
+			return fmt.Errorf("error writing relationship %s instances to %s: %w", relationship.ID, filePath, err)
+		}
+		m.written.add(paths.RelationshipInstancesFilePath(relationship.ID))
+		scoped.Relationships = append(scoped.Relationships, relationship)
+	}
+	for _, linkedProperty := range schemaElements.LinkedProperties {
+		linkedPropertyInstances, found := instances[linkedProperty.ID]
+		if !found || !scopedModelIDs[linkedProperty.From] || !scopedModelIDs[linkedProperty.To] {
+			continue
+		}
+		filePath := filepath.Join(metadataDirectory, paths.LinkedPropertyInstancesFilePath(linkedProperty.ID))
+		if err := writeScopedRelationshipInstances(filePath, linkedPropertyInstances, reachable); err != nil {
+			return fmt.Errorf("error writing linked property %s instances to %s: %w", linkedProperty.ID, filePath, err)
+		}
+		m.written.add(paths.LinkedPropertyInstancesFilePath(linkedProperty.ID))
+		scoped.LinkedProperties = append(scoped.LinkedProperties, linkedProperty)
+	}
+	if err := rewriteSchema(metadataDirectory, scoped); err != nil {
+		return err
+	}
+	if !m.FetchProxies {
+		logger.Info("fetching proxies is turned off; no proxy instances will be written")
+		return nil
+	}
+	return m.WriteProxiesFromLinks(ctx, metadataDirectory, scoped.Models, scopedRecordIDs, links, nil)
+}
+
+// getRelationshipInstances returns the instances of every relationship and linked property in schemaElements,
+// keyed by schema relationship or linked property id. Those that are not found are left out.
+func (m *MetadataPreProcessor) getRelationshipInstances(ctx context.Context, datasetID string, schemaElements schema.Elements) (map[string][]relationshipInstance, error) {
+	var schemaIDs []string
+	for _, relationship := range schemaElements.Relationships {
+		schemaIDs = append(schemaIDs, relationship.ID)
+	}
+	for _, linkedProperty := range schemaElements.LinkedProperties {
+		schemaIDs = append(schemaIDs, linkedProperty.ID)
+	}
+	var instancesMutex sync.Mutex
+	instances := make(map[string][]relationshipInstance, len(schemaIDs))
+	group := newFetchGroup(ctx, m.FetchConcurrency)
+	for _, schemaID := range schemaIDs {
+		schemaID := schemaID
+		group.Go(func(ctx context.Context) error {
+			schemaInstances, err := m.getRelationshipInstancesFor(ctx, datasetID, schemaID)
+			if err != nil {
+				if errors.Is(err, pennsieve.ErrNotFound) {
+					logger.Warn("relationship or linked property not found; it may have been deleted during the run. Skipping it",
+						slog.String("id", schemaID),
+						slog.Any("error", err))
+					return nil
+				}
+				return err
+			}
+			instancesMutex.Lock()
+			defer instancesMutex.Unlock()
+			instances[schemaID] = schemaInstances
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (m *MetadataPreProcessor) getRelationshipInstancesFor(ctx context.Context, datasetID string, schemaID string) ([]relationshipInstance, error) {
+	res, err := m.Pennsieve.GetRelationshipInstances(ctx, datasetID, schemaID)
+	if err != nil {
+		return nil, err
+	}
+	defer util.CloseAndWarn(res)
+	var rawInstances []json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&rawInstances); err != nil {
+		return nil, fmt.Errorf("error decoding instances of %s: %w", schemaID, err)
+	}
+	instances := make([]relationshipInstance, 0, len(rawInstances))
+	for _, raw := range rawInstances {
+		var ends struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		}
+		if err := json.Unmarshal(raw, &ends); err != nil {
+			return nil, fmt.Errorf("error decoding instance of %s: %w", schemaID, err)
+		}
+		instances = append(instances, relationshipInstance{Raw: raw, From: ends.From, To: ends.To})
+	}
+	return instances, nil
+}
+
+// reachableRecords returns the set of record IDs within hops instances of the start records, following instances in either direction.
+func reachableRecords(startRecordIDs []string, instances map[string][]relationshipInstance, hops int) map[string]bool {
+	neighbors := map[string][]string{}
+	for _, schemaInstances := range instances {
+		for _, instance := range schemaInstances {
+			neighbors[instance.From] = append(neighbors[instance.From], instance.To)
+			neighbors[instance.To] = append(neighbors[instance.To], instance.From)
+		}
+	}
+	reachable := map[string]bool{}
+	var frontier []string
+	for _, recordID := range startRecordIDs {
+		if !reachable[recordID] {
+			reachable[recordID] = true
+			frontier = append(frontier, recordID)
+		}
+	}
+	for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, recordID := range frontier {
+			for _, neighbor := range neighbors[recordID] {
+				if !reachable[neighbor] {
+					reachable[neighbor] = true
+					next = append(next, neighbor)
+				}
+			}
+		}
+		frontier = next
+	}
+	return reachable
+}
+
+// writeScopedRelationshipInstances writes the instances with both ends in reachable
+func writeScopedRelationshipInstances(filePath string, instances []relationshipInstance, reachable map[string]bool) (err error) {
+	file, err := createAtomic(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.finish(&err)
+	arrayWriter := NewJSONArrayWriter(file)
+	for _, instance := range instances {
+		if reachable[instance.From] && reachable[instance.To] {
+			if err := arrayWriter.Append(instance.Raw); err != nil {
+				return err
+			}
+		}
+	}
+	if err := arrayWriter.Close(); err != nil {
+		return err
+	}
+	logger.Info("wrote scoped instances", slog.String("path", filePath), slog.Int("count", arrayWriter.Count()))
+	return nil
+}
+
+// rewriteSchema removes the elements that are not in kept from the graph schema and relationship schemas files.
+func rewriteSchema(metadataDirectory string, kept schema.Elements) error {
+	keptIDs := map[string]bool{}
+	for _, model := range kept.Models {
+		keptIDs[model.ID] = true
+	}
+	for _, relationship := range kept.Relationships {
+		keptIDs[relationship.ID] = true
+	}
+	for _, linkedProperty := range kept.LinkedProperties {
+		keptIDs[linkedProperty.ID] = true
+	}
+	if kept.Proxy != nil {
+		keptIDs[kept.Proxy.ID] = true
+	}
+	for _, schemaFilePath := range []string{paths.SchemaFilePath, paths.RelationshipSchemasFilePath} {
+		filePath := filepath.Join(metadataDirectory, schemaFilePath)
+		if err := filterSchemaFile(filePath, keptIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func filterSchemaFile(filePath string, keepIDs map[string]bool) error {
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+	var elements []map[string]any
+	if err := json.Unmarshal(fileBytes, &elements); err != nil {
+		return fmt.Errorf("error decoding %s: %w", filePath, err)
+	}
+	var kept []map[string]any
+	for _, element := range elements {
+		id, err := GetID(element)
+		if err != nil {
+			return fmt.Errorf("error getting id of element in %s: %w", filePath, err)
+		}
+		if keepIDs[id] {
+			kept = append(kept, element)
+		}
+	}
+	if kept == nil {
+		kept = []map[string]any{}
+	}
+	if _, err := WriteJSON(filePath, kept); err != nil {
+		return err
+	}
+	return nil
+}
+
+func removeIfExists(filePath string) error {
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing %s: %w", filePath, err)
+	}
+	return nil
+}
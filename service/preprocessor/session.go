@@ -0,0 +1,113 @@
+package preprocessor
+
+import (
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/service/pennsieve"
+	"os"
+	"time"
+)
+
+// Version is the version of this processor reported in the User-Agent header.
+// Set at build time with -ldflags "-X github.com/pennsieve/processor-pre-metadata/service/preprocessor.Version=<version>"
+var Version = "development"
+
+const defaultRequestTimeout = 5 * time.Minute
+
+// UserAgent returns the User-Agent header value sent to Pennsieve on behalf of the given integration.
+func UserAgent(integrationID string) string {
+	return fmt.Sprintf("processor-pre-metadata/%s (integration %s)", Version, integrationID)
+}
+
+// AuthFromEnv returns the Pennsieve credentials from the environment. If PENNSIEVE_API_KEY or PENNSIEVE_API_SECRET is set,
+// both are required, and the returned option makes the Session exchange them for session tokens, refreshing as needed.
+// Otherwise, SESSION_TOKEN is required and is returned as the session token.
+func AuthFromEnv() (sessionToken string, options []pennsieve.SessionOption, err error) {
+	apiKey, apiSecret := os.Getenv("PENNSIEVE_API_KEY"), os.Getenv("PENNSIEVE_API_SECRET")
+	if len(apiKey) > 0 || len(apiSecret) > 0 {
+		if len(apiKey) == 0 || len(apiSecret) == 0 {
+			return "", nil, fmt.Errorf("PENNSIEVE_API_KEY and PENNSIEVE_API_SECRET must be set together")
+		}
+		return "", []pennsieve.SessionOption{pennsieve.WithAPIKey(apiKey, apiSecret)}, nil
+	}
+	sessionToken, err = LookupRequiredEnvVar("SESSION_TOKEN")
+	if err != nil {
+		return "", nil, fmt.Errorf("%w; set SESSION_TOKEN, or PENNSIEVE_API_KEY and PENNSIEVE_API_SECRET", err)
+	}
+	return sessionToken, nil, nil
+}
+
+// SessionOptionsFromEnv returns options for pennsieve.NewSession configured by the optional environment variables
+// RETRY_*, RATE_LIMIT_*, HTTP_REQUEST_TIMEOUT, HTTP_OVERALL_TIMEOUT, PENNSIEVE_PROXY_URL, and CA_BUNDLE_PATH.
+func SessionOptionsFromEnv(integrationID string) ([]pennsieve.SessionOption, error) {
+	retryPolicy, err := RetryPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	rateLimiter, err := RateLimiterFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	requestTimeout, err := LookupDurationEnvVar("HTTP_REQUEST_TIMEOUT", defaultRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	overallTimeout, err := LookupDurationEnvVar("HTTP_OVERALL_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+	options := []pennsieve.SessionOption{
+		pennsieve.WithRetryPolicy(retryPolicy),
+		pennsieve.WithRateLimiter(rateLimiter),
+		pennsieve.WithRequestTimeout(requestTimeout),
+		pennsieve.WithOverallTimeout(overallTimeout),
+		pennsieve.WithUserAgent(UserAgent(integrationID)),
+	}
+	if proxyURL := os.Getenv("PENNSIEVE_PROXY_URL"); len(proxyURL) > 0 {
+		options = append(options, pennsieve.WithProxy(proxyURL))
+	}
+	if caBundlePath := os.Getenv("CA_BUNDLE_PATH"); len(caBundlePath) > 0 {
+		options = append(options, pennsieve.WithCABundle(caBundlePath))
+	}
+	return options, nil
+}
+
+// RateLimiterFromEnv returns a pennsieve.RateLimiter configured by the optional RATE_LIMIT_RPS and RATE_LIMIT_BURST
+// environment variables. Returns nil, meaning no rate limit, if RATE_LIMIT_RPS is set to 0.
+func RateLimiterFromEnv() (*pennsieve.RateLimiter, error) {
+	requestsPerSecond, err := LookupFloatEnvVar("RATE_LIMIT_RPS", pennsieve.DefaultRequestsPerSecond)
+	if err != nil {
+		return nil, err
+	}
+	burst, err := LookupIntEnvVar("RATE_LIMIT_BURST", pennsieve.DefaultBurst)
+	if err != nil {
+		return nil, err
+	}
+	return pennsieve.NewRateLimiter(requestsPerSecond, burst), nil
+}
+
+// RetryPolicyFromEnv returns pennsieve.DefaultRetryPolicy with any values overridden by the optional
+// RETRY_MAX_ATTEMPTS, RETRY_INITIAL_BACKOFF, RETRY_MAX_BACKOFF, and RETRY_MAX_RETRY_AFTER environment variables.
+func RetryPolicyFromEnv() (pennsieve.RetryPolicy, error) {
+	policy := pennsieve.DefaultRetryPolicy()
+	if maxAttempts, err := LookupIntEnvVar("RETRY_MAX_ATTEMPTS", policy.MaxAttempts); err != nil {
+		return pennsieve.RetryPolicy{}, err
+	} else {
+		policy.MaxAttempts = maxAttempts
+	}
+	if initialBackoff, err := LookupDurationEnvVar("RETRY_INITIAL_BACKOFF", policy.InitialBackoff); err != nil {
+		return pennsieve.RetryPolicy{}, err
+	} else {
+		policy.InitialBackoff = initialBackoff
+	}
+	if maxBackoff, err := LookupDurationEnvVar("RETRY_MAX_BACKOFF", policy.MaxBackoff); err != nil {
+		return pennsieve.RetryPolicy{}, err
+	} else {
+		policy.MaxBackoff = maxBackoff
+	}
+	if maxRetryAfter, err := LookupDurationEnvVar("RETRY_MAX_RETRY_AFTER", policy.MaxRetryAfter); err != nil {
+		return pennsieve.RetryPolicy{}, err
+	} else {
+		policy.MaxRetryAfter = maxRetryAfter
+	}
+	return policy, nil
+}
@@ -0,0 +1,95 @@
+package preprocessor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pennsieve/processor-pre-metadata/client/paths"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// writtenFiles collects the paths, relative to the metadata directory, of the property and instance files that a run
+// wrote, or kept from an earlier run. The other files of those directories were left by an earlier run into the same
+// directory, and are removed before the manifest is written so that it does not vouch for them.
+// A nil *writtenFiles collects nothing.
+type writtenFiles struct {
+	mutex sync.Mutex
+	paths map[string]bool
+}
+
+func newWrittenFiles() *writtenFiles {
+	return &writtenFiles{paths: map[string]bool{}}
+}
+
+// add records that the files at relativePaths were written or kept by the run
+func (w *writtenFiles) add(relativePaths ...string) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, relativePath := range relativePaths {
+		w.paths[filepath.Clean(relativePath)] = true
+	}
+}
+
+// removeOthers removes the property, record, relationship, linked property and proxy files in metadataDirectory that
+// the run neither wrote nor kept, such as those of a model, relationship or record deleted since an earlier run, and
+// the proxy directories of models left without proxies. If not nil, removed is called with the relative path of each
+// file before it is removed.
+func (w *writtenFiles) removeOthers(metadataDirectory string, removed func(relativePath string)) error {
+	if w == nil {
+		return nil
+	}
+	proxiesDirectory := filepath.Join(paths.InstancesDirectory, paths.ProxiesDirectory)
+	directories := []string{
+		filepath.Join(paths.SchemaDirectory, paths.PropertiesDirectory),
+		filepath.Join(paths.InstancesDirectory, paths.RecordsDirectory),
+		filepath.Join(paths.InstancesDirectory, paths.RelationshipsDirectory),
+		filepath.Join(paths.InstancesDirectory, paths.LinkedPropertiesDirectory),
+		proxiesDirectory,
+	}
+	for _, directory := range directories {
+		err := filepath.WalkDir(filepath.Join(metadataDirectory, directory), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				return nil
+			}
+			relativePath, err := filepath.Rel(metadataDirectory, path)
+			if err != nil {
+				return err
+			}
+			if w.paths[relativePath] {
+				return nil
+			}
+			if removed != nil {
+				removed(relativePath)
+			}
+			logger.Info("removing file not written by this run", slog.String("path", relativePath))
+			return removeIfExists(path)
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing files not written by this run from %s: %w", directory, err)
+		}
+	}
+	// a run does not create the proxies directory of a model without proxies
+	modelDirectories, err := os.ReadDir(filepath.Join(metadataDirectory, proxiesDirectory))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading %s: %w", proxiesDirectory, err)
+	}
+	for _, modelDirectory := range modelDirectories {
+		modelDirectoryPath := filepath.Join(metadataDirectory, proxiesDirectory, modelDirectory.Name())
+		if entries, err := os.ReadDir(modelDirectoryPath); err == nil && len(entries) == 0 {
+			if err := os.Remove(modelDirectoryPath); err != nil {
+				return fmt.Errorf("error removing empty directory %s: %w", modelDirectoryPath, err)
+			}
+		}
+	}
+	return nil
+}